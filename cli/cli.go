@@ -0,0 +1,206 @@
+// Package cli drives the text CLI of a Betaflight flight controller: entering
+// CLI mode, running commands and collecting their output.
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robhaswell/btflcli/msp"
+)
+
+const (
+	// DefaultTimeout is how long to wait for the FC to say anything before
+	// giving up on a command.
+	DefaultTimeout = 5 * time.Second
+
+	pollInterval = 50 * time.Millisecond
+	prompt       = "# "
+)
+
+// ErrTimeout is returned when the FC stops responding.
+var ErrTimeout = errors.New("timed out waiting for the flight controller")
+
+// Session is a flight controller in CLI mode. Commands are run one at a
+// time; after each one a comment line is sent, which the FC echoes once the
+// command has finished, to mark the end of its output.
+type Session struct {
+	port    msp.Transport
+	buf     []byte
+	seq     int
+	Timeout time.Duration
+}
+
+// Enter switches the FC on port into CLI mode. The port must not be in use
+// by an MSP reader.
+func Enter(port msp.Transport) (*Session, error) {
+	s := &Session{
+		port:    port,
+		Timeout: DefaultTimeout,
+	}
+	if err := port.SetReadTimeout(pollInterval); err != nil {
+		return nil, err
+	}
+	if _, err := port.Write([]byte("#\r\n")); err != nil {
+		return nil, err
+	}
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("entering CLI mode: %w", err)
+		}
+		if strings.Contains(line, "Entering CLI Mode") {
+			break
+		}
+	}
+	// Skip the prompt
+	if _, err := s.Run(""); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Run sends a command and returns the lines it printed, without the echo of
+// the command or the prompt.
+func (s *Session) Run(command string) ([]string, error) {
+	s.seq++
+	marker := fmt.Sprintf("# btfl %d", s.seq)
+	var out bytes.Buffer
+	if command != "" {
+		out.WriteString(command + "\r\n")
+	}
+	out.WriteString(marker + "\r\n")
+	if _, err := s.port.Write(out.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("running %q: %w", command, err)
+		}
+		if strings.HasSuffix(line, marker) {
+			break
+		}
+		lines = append(lines, line)
+	}
+	// Drop what came before the echo of the command, then the blank line
+	// and prompt printed when it finished.
+	for ii, line := range lines {
+		if command != "" && line == prompt+command {
+			lines = lines[ii+1:]
+			break
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+// CommandError is returned by Exec when the FC rejects a command.
+type CommandError struct {
+	Command string
+	// Message is the line the FC printed to report the error
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Command, e.Message)
+}
+
+// errorMarkers are the ways the FC reports a command failed. Current
+// firmware uses ###ERROR...###, older releases print plain messages.
+var errorMarkers = []string{"###error", "invalid name", "invalid value", "parse error"}
+
+// ResponseError returns the line of a command's output reporting an error,
+// or "" if the command succeeded.
+func ResponseError(lines []string) string {
+	for _, line := range lines {
+		lower := strings.ToLower(line)
+		for _, m := range errorMarkers {
+			if strings.Contains(lower, m) {
+				return strings.TrimSpace(line)
+			}
+		}
+	}
+	return ""
+}
+
+// Exec runs a command like Run, returning a *CommandError if the FC reports
+// that it failed.
+func (s *Session) Exec(command string) ([]string, error) {
+	lines, err := s.Run(command)
+	if err != nil {
+		return nil, err
+	}
+	if msg := ResponseError(lines); msg != "" {
+		return lines, &CommandError{Command: command, Message: msg}
+	}
+	return lines, nil
+}
+
+// Save saves the configuration. The FC reboots afterwards, so the session
+// can't be used again.
+func (s *Session) Save() error {
+	return s.Leave("save")
+}
+
+// Exit leaves CLI mode, discarding unsaved changes. The FC reboots
+// afterwards, so the session can't be used again.
+func (s *Session) Exit() error {
+	return s.Leave("exit")
+}
+
+// Leave runs a command after which the FC reboots, such as save, exit or
+// defaults, and waits for it to go.
+func (s *Session) Leave(command string) error {
+	if _, err := s.port.Write([]byte(command + "\r\n")); err != nil {
+		return err
+	}
+	// The FC doesn't finish the line before rebooting, and the port may
+	// well vanish as it does, so any error means we're done.
+	last := time.Now()
+	chunk := make([]byte, 256)
+	for !bytes.Contains(s.buf, []byte("Rebooting")) && time.Since(last) < s.Timeout {
+		n, err := s.port.Read(chunk)
+		if err != nil {
+			break
+		}
+		if n > 0 {
+			last = time.Now()
+			s.buf = append(s.buf, chunk[:n]...)
+		}
+	}
+	s.buf = nil
+	return nil
+}
+
+// readLine returns the next line from the FC without its line ending.
+func (s *Session) readLine() (string, error) {
+	last := time.Now()
+	chunk := make([]byte, 256)
+	for {
+		if i := bytes.IndexByte(s.buf, '\n'); i >= 0 {
+			line := strings.TrimRight(string(s.buf[:i]), "\r")
+			s.buf = s.buf[i+1:]
+			return line, nil
+		}
+		n, err := s.port.Read(chunk)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 {
+			if time.Since(last) > s.Timeout {
+				return "", ErrTimeout
+			}
+			continue
+		}
+		last = time.Now()
+		s.buf = append(s.buf, chunk[:n]...)
+	}
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"sort"
+	"strings"
+)
+
+// settingCommands take a setting name as their first argument.
+var settingCommands = map[string]bool{"get": true, "set": true}
+
+// Completer completes command lines from the commands and settings the FC
+// lists in its `help` and `get` output, so it matches the firmware in use.
+type Completer struct {
+	Commands []string
+	Settings []string
+}
+
+// NewCompleter asks the FC which commands and settings it has.
+func NewCompleter(s *Session) (*Completer, error) {
+	help, err := s.Run("help")
+	if err != nil {
+		return nil, err
+	}
+	get, err := s.Run("get")
+	if err != nil {
+		return nil, err
+	}
+	return &Completer{
+		Commands: helpCommands(help),
+		Settings: getSettings(get),
+	}, nil
+}
+
+// helpCommands returns the command names from `help` output. Each command
+// is at the start of a line, followed by its description; the lines
+// describing the arguments are indented.
+func helpCommands(lines []string) []string {
+	var names []string
+	for _, line := range lines {
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		name, _, _ := strings.Cut(line, " ")
+		if name = strings.TrimSpace(name); name != "" && !strings.HasPrefix(name, "#") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getSettings returns the setting names from `get` output.
+func getSettings(lines []string) []string {
+	var names []string
+	for _, v := range ParseGet(lines) {
+		names = append(names, v.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Complete returns the possible completions of a line: a command name for
+// the first word, and a setting name after get or set.
+func (c *Completer) Complete(line string) []string {
+	cmd, rest, found := strings.Cut(line, " ")
+	if !found {
+		return withPrefix(c.Commands, "", cmd)
+	}
+	if !settingCommands[strings.ToLower(cmd)] || strings.ContainsAny(rest, " =") {
+		return nil
+	}
+	return withPrefix(c.Settings, cmd+" ", rest)
+}
+
+// withPrefix returns lead followed by each of names which starts with word.
+func withPrefix(names []string, lead, word string) []string {
+	var matches []string
+	word = strings.ToLower(word)
+	for _, name := range names {
+		if strings.HasPrefix(name, word) {
+			matches = append(matches, lead+name)
+		}
+	}
+	return matches
+}
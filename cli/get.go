@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Variable is a setting as described by the `get` command.
+type Variable struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Profile and RateProfile are the profile the value was read from, for
+	// settings which are held per profile
+	Profile     *int `json:"profile,omitempty"`
+	RateProfile *int `json:"rate_profile,omitempty"`
+	// Range is the range of a number
+	Range *Range `json:"range,omitempty"`
+	// Values are the values a lookup setting can take
+	Values []string `json:"allowed_values,omitempty"`
+	// ArrayLength is the number of elements of an array setting
+	ArrayLength int `json:"array_length,omitempty"`
+	// StringLength is the range of the length of a string setting
+	StringLength *Range `json:"string_length,omitempty"`
+	Default      string `json:"default,omitempty"`
+}
+
+// Range is an inclusive range of numbers.
+type Range struct {
+	Min int64 `json:"min"`
+	Max int64 `json:"max"`
+}
+
+// Get returns the settings whose names contain name.
+func (s *Session) Get(name string) ([]Variable, error) {
+	lines, err := s.Exec("get " + name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGet(lines), nil
+}
+
+// Set changes a setting and returns the value the FC stored. If the FC
+// rejects the value the *CommandError describes what it accepts.
+func (s *Session) Set(name, value string) (string, error) {
+	command := fmt.Sprintf("set %s = %s", name, value)
+	lines, err := s.Exec(command)
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		// The FC follows an invalid value with the allowed ones
+		if v := ParseGet(append([]string{name + " = " + value}, lines...)); len(v) == 1 {
+			if allowed := v[0].Allowed(); allowed != "" {
+				cmdErr.Message += ", " + allowed
+			}
+		}
+		return "", err
+	}
+	if err != nil {
+		return "", err
+	}
+	// The FC confirms with the name in lower case, whatever it was given
+	prefix := name + " set to "
+	for _, line := range lines {
+		if len(line) >= len(prefix) && strings.EqualFold(line[:len(prefix)], prefix) {
+			return line[len(prefix):], nil
+		}
+	}
+	return "", &CommandError{Command: command, Message: "no confirmation from the flight controller"}
+}
+
+// Allowed describes the values a setting accepts, as `get` does.
+func (v *Variable) Allowed() string {
+	switch {
+	case v.Range != nil:
+		return fmt.Sprintf("Allowed range: %d - %d", v.Range.Min, v.Range.Max)
+	case v.Values != nil:
+		return "Allowed values: " + strings.Join(v.Values, ", ")
+	case v.StringLength != nil:
+		return fmt.Sprintf("String length: %d - %d", v.StringLength.Min, v.StringLength.Max)
+	case v.ArrayLength > 0:
+		return fmt.Sprintf("Array length: %d", v.ArrayLength)
+	}
+	return ""
+}
+
+// ParseGet parses the output of `get`. Each setting is a `name = value`
+// line, followed by lines giving its profile, the values it accepts and its
+// default.
+func ParseGet(lines []string) []Variable {
+	var vars []Variable
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if name, value, ok := strings.Cut(line, " ="); ok && name != "" && !strings.ContainsAny(name, " :") {
+			vars = append(vars, Variable{Name: name, Value: strings.TrimSpace(value)})
+			continue
+		}
+		if len(vars) == 0 {
+			continue
+		}
+		v := &vars[len(vars)-1]
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "profile", "rateprofile":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+			if key == "profile" {
+				v.Profile = &n
+			} else {
+				v.RateProfile = &n
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(key) {
+		case "allowed range":
+			v.Range = parseRange(value)
+		case "allowed values":
+			v.Values = strings.Split(value, ", ")
+		case "array length":
+			v.ArrayLength, _ = strconv.Atoi(value)
+		case "string length":
+			v.StringLength = parseRange(value)
+		case "default value":
+			v.Default = value
+		}
+	}
+	return vars
+}
+
+// parseRange parses a range such as "-180 - 360".
+func parseRange(s string) *Range {
+	lo, hi, ok := strings.Cut(s, " - ")
+	if !ok {
+		return nil
+	}
+	min, err := strconv.ParseInt(strings.TrimSpace(lo), 10, 64)
+	if err != nil {
+		return nil
+	}
+	max, err := strconv.ParseInt(strings.TrimSpace(hi), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &Range{Min: min, Max: max}
+}
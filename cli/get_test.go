@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/robhaswell/btflcli/fcsim"
+)
+
+func intp(n int) *int { return &n }
+
+// splitOutput splits recorded CLI output into lines as Run returns them.
+func splitOutput(s string) []string {
+	return strings.Split(strings.TrimSuffix(s, "\r\n"), "\r\n")
+}
+
+func TestParseGet(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Variable
+	}{
+		{
+			name:   "lookup",
+			output: "gyro_lpf1_type = PT1\r\nAllowed values: PT1, BIQUAD, PT2, PT3\r\nDefault value: PT1\r\n",
+			want: []Variable{{
+				Name:    "gyro_lpf1_type",
+				Value:   "PT1",
+				Values:  []string{"PT1", "BIQUAD", "PT2", "PT3"},
+				Default: "PT1",
+			}},
+		},
+		{
+			name:   "profile setting",
+			output: "p_pitch = 47\r\nprofile 0\r\nAllowed range: 0 - 250\r\nDefault value: 47\r\n",
+			want: []Variable{{
+				Name:    "p_pitch",
+				Value:   "47",
+				Profile: intp(0),
+				Range:   &Range{0, 250},
+				Default: "47",
+			}},
+		},
+		{
+			name:   "rate profile setting",
+			output: "roll_srate = 80\r\nrateprofile 2\r\nAllowed range: 0 - 255\r\nDefault value: 67\r\n",
+			want: []Variable{{
+				Name:        "roll_srate",
+				Value:       "80",
+				RateProfile: intp(2),
+				Range:       &Range{0, 255},
+				Default:     "67",
+			}},
+		},
+		{
+			name:   "negative range",
+			output: "acc_trim_pitch = -3\r\nAllowed range: -300 - 300\r\nDefault value: 0\r\n",
+			want: []Variable{{
+				Name:    "acc_trim_pitch",
+				Value:   "-3",
+				Range:   &Range{-300, 300},
+				Default: "0",
+			}},
+		},
+		{
+			name:   "array",
+			output: "acc_calibration = -12,3,-40,1\r\nArray length: 4\r\n\r\nDefault value: 0,0,0,0\r\n",
+			want: []Variable{{
+				Name:        "acc_calibration",
+				Value:       "-12,3,-40,1",
+				ArrayLength: 4,
+				Default:     "0,0,0,0",
+			}},
+		},
+		{
+			name:   "string",
+			output: "craft_name = Tiny Whoop\r\nString length: 1 - 16\r\n",
+			want: []Variable{{
+				Name:         "craft_name",
+				Value:        "Tiny Whoop",
+				StringLength: &Range{1, 16},
+			}},
+		},
+		{
+			name: "several matches",
+			output: "gyro_lpf1_type = PT1\r\nAllowed values: PT1, BIQUAD, PT2, PT3\r\nDefault value: PT1\r\n\r\n" +
+				"gyro_lpf1_static_hz = 200\r\nAllowed range: 0 - 1000\r\nDefault value: 250\r\n\r\n" +
+				"gyro_lpf1_dyn_min_hz = 250\r\nAllowed range: 0 - 1000\r\nDefault value: 250\r\n",
+			want: []Variable{
+				{Name: "gyro_lpf1_type", Value: "PT1", Values: []string{"PT1", "BIQUAD", "PT2", "PT3"}, Default: "PT1"},
+				{Name: "gyro_lpf1_static_hz", Value: "200", Range: &Range{0, 1000}, Default: "250"},
+				{Name: "gyro_lpf1_dyn_min_hz", Value: "250", Range: &Range{0, 1000}, Default: "250"},
+			},
+		},
+		{
+			name:   "no match",
+			output: "###ERROR IN get: INVALID NAME: no_such_setting###\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseGet(splitOutput(tt.output))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"p_pitch = 47\r\nAllowed range: 0 - 250\r\n", "Allowed range: 0 - 250"},
+		{"gyro_lpf1_type = PT1\r\nAllowed values: PT1, BIQUAD\r\n", "Allowed values: PT1, BIQUAD"},
+		{"craft_name = \r\nString length: 1 - 16\r\n", "String length: 1 - 16"},
+		{"acc_calibration = 0,0,0,0\r\nArray length: 4\r\n", "Array length: 4"},
+		{"mystery = 1\r\n", ""},
+	}
+	for _, tt := range tests {
+		v := ParseGet(splitOutput(tt.output))
+		if len(v) != 1 {
+			t.Fatalf("%q parsed as %+v", tt.output, v)
+		}
+		if got := v[0].Allowed(); got != tt.want {
+			t.Errorf("%q allows %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func enterSim(t *testing.T) (*Session, *fcsim.Sim) {
+	sim := fcsim.New()
+	port := sim.Dial()
+	t.Cleanup(func() { port.Close() })
+	s, err := Enter(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, sim
+}
+
+func TestSet(t *testing.T) {
+	s, _ := enterSim(t)
+	tests := []struct {
+		name, value string
+		stored      string
+		err         string
+	}{
+		{"p_pitch", "50", "50", ""},
+		{"P_PITCH", "51", "51", ""},
+		{"gyro_lpf1_type", "biquad", "BIQUAD", ""},
+		{"p_pitch", "9999", "", "###ERROR IN set: INVALID VALUE###, Allowed range: 0 - 250"},
+		{"gyro_lpf1_type", "FOO", "", "###ERROR IN set: INVALID VALUE###, Allowed values: PT1, BIQUAD, PT2, PT3"},
+		{"no_such_setting", "1", "", "###ERROR IN set: INVALID NAME: no_such_setting###"},
+	}
+	for _, tt := range tests {
+		stored, err := s.Set(tt.name, tt.value)
+		if tt.err == "" {
+			if err != nil || stored != tt.stored {
+				t.Errorf("set %s = %s stored %q, %v, want %q", tt.name, tt.value, stored, err, tt.stored)
+			}
+			continue
+		}
+		var cmdErr *CommandError
+		if !errors.As(err, &cmdErr) {
+			t.Errorf("set %s = %s: got error %v, want a CommandError", tt.name, tt.value, err)
+			continue
+		}
+		if cmdErr.Message != tt.err {
+			t.Errorf("set %s = %s: got message %q, want %q", tt.name, tt.value, cmdErr.Message, tt.err)
+		}
+	}
+
+	vars, err := s.Get("p_pitch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vars) != 1 || vars[0].Value != "51" || vars[0].Range == nil || *vars[0].Range != (Range{0, 250}) {
+		t.Errorf("got %+v", vars)
+	}
+}
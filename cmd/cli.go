@@ -0,0 +1,199 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterh/liner"
+	"github.com/robhaswell/btflcli/cli"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cliHistoryFile string
+	saveOnExit     bool
+)
+
+// cliCmd represents the cli command
+var cliCmd = &cobra.Command{
+	Use:   "cli",
+	Short: "Open an interactive shell on the flight controller's CLI",
+	Long: `Enter CLI mode on the flight controller and pass commands typed at the prompt
+through to it, as the Configurator's CLI tab does.
+
+Commands and setting names are completed with Tab, using the flight
+controller's own help and get output. History is kept between sessions.
+
+Typing exit or save leaves CLI mode as usual. Ctrl-D or Ctrl-C leave with
+exit, discarding unsaved changes, or with save if --save is given.`,
+	Args: cobra.NoArgs,
+	Run:  runCLI,
+}
+
+func init() {
+	rootCmd.AddCommand(cliCmd)
+
+	cliCmd.Flags().StringVar(&cliHistoryFile, "history-file", defaultHistoryFile(), "file to keep the command history in")
+	cliCmd.Flags().BoolVar(&saveOnExit, "save", false, "save changes when leaving with Ctrl-D or Ctrl-C")
+}
+
+func defaultHistoryFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "btfl", "cli_history")
+}
+
+func runCLI(cmd *cobra.Command, args []string) {
+	// Connect to the selected or auto-detected flight controller
+	fc, err := connectFC()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Activate the CLI mode
+	session, err := cli.Enter(fc.Port)
+	if err != nil {
+		log.Fatal(err)
+	}
+	completer, err := cli.NewCompleter(session)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := shell(session, completer); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// shell runs the REPL until the user or the FC leaves CLI mode. The terminal
+// is restored before returning.
+func shell(session *cli.Session, completer *cli.Completer) error {
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+	line.SetCompleter(completer.Complete)
+	readCLIHistory(line)
+	defer writeCLIHistory(line)
+
+	// Ctrl-C while a command is running arrives as a signal rather than a
+	// keypress, and leaves once the command has finished
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	prompt := func() (string, error) {
+		input, err := line.Prompt("# ")
+		if input = strings.TrimSpace(input); err == nil && input != "" {
+			line.AppendHistory(input)
+		}
+		return input, err
+	}
+	return runShell(session, prompt, interrupt)
+}
+
+// runShell passes each line prompt returns to the FC and prints its output,
+// until a command leaves CLI mode or the user gives up at the prompt or with
+// an interrupt.
+func runShell(session *cli.Session, prompt func() (string, error), interrupt <-chan os.Signal) error {
+	for {
+		input, err := prompt()
+		if errors.Is(err, io.EOF) || errors.Is(err, liner.ErrPromptAborted) {
+			fmt.Println()
+			return leaveShell(session)
+		}
+		if err != nil {
+			return err
+		}
+		if input == "" {
+			continue
+		}
+
+		if fields := strings.Fields(input); leavesCLI(fields[0], fields[1:]) {
+			fmt.Println("Rebooting")
+			return session.Leave(input)
+		}
+		out, err := session.Run(input)
+		if err != nil {
+			return err
+		}
+		for _, l := range out {
+			fmt.Println(l)
+		}
+
+		select {
+		case <-interrupt:
+			return leaveShell(session)
+		default:
+		}
+	}
+}
+
+// leavesCLI reports whether a command ends CLI mode: exit and save, and the
+// commands which reboot or reset the FC.
+func leavesCLI(name string, args []string) bool {
+	name = strings.ToLower(name)
+	return name == "exit" || name == "save" || interrupts(name, args)
+}
+
+func leaveShell(session *cli.Session) error {
+	if saveOnExit {
+		fmt.Println("Saving and rebooting")
+		return session.Save()
+	}
+	fmt.Println("Leaving CLI mode, unsaved changes lost")
+	return session.Exit()
+}
+
+func readCLIHistory(line *liner.State) {
+	if cliHistoryFile == "" {
+		return
+	}
+	f, err := os.Open(cliHistoryFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	line.ReadHistory(f)
+}
+
+func writeCLIHistory(line *liner.State) {
+	if cliHistoryFile == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(cliHistoryFile), os.ModePerm); err != nil {
+		log.Printf("Saving history: %v", err)
+		return
+	}
+	f, err := os.Create(cliHistoryFile)
+	if err != nil {
+		log.Printf("Saving history: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := line.WriteHistory(f); err != nil {
+		log.Printf("Saving history: %v", err)
+	}
+}
@@ -0,0 +1,400 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robhaswell/btflcli/cli"
+	"github.com/robhaswell/btflcli/config"
+	"github.com/robhaswell/btflcli/fcsim"
+	"github.com/robhaswell/btflcli/msp"
+)
+
+// testSim is the simulator that simtest:// ports connect to.
+var testSim *fcsim.Sim
+
+func init() {
+	msp.RegisterScheme("simtest", func(u *url.URL, baudRate int) (msp.Transport, error) {
+		return testSim.Dial(), nil
+	})
+}
+
+// useSim connects simtest:// to a new simulator and selects it as the port,
+// and runs the rest of the test in a temporary directory so that snapshots
+// and dumps go there.
+func useSim(t *testing.T) *fcsim.Sim {
+	testSim = fcsim.New()
+	dumpOutputDir = "."
+	portName = "simtest://"
+	if err := testSim.Set("craft_name", "Bench Quad"); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return testSim
+}
+
+// run runs btfl with args against the simulator and returns what it wrote
+// to stdout.
+func run(t *testing.T, args ...string) string {
+	t.Helper()
+	var err error
+	printed := captureStdout(t, func() {
+		rootCmd.SetArgs(append(args, "--port", "simtest://"))
+		err = rootCmd.Execute()
+	})
+	if err != nil {
+		t.Fatalf("btfl %s: %v", strings.Join(args, " "), err)
+	}
+	return printed
+}
+
+// captureStdout calls f and returns what it wrote to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+
+	f()
+	os.Stdout = stdout
+	w.Close()
+	return <-out
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func checkSetting(t *testing.T, sim *fcsim.Sim, name, want string) {
+	t.Helper()
+	if got, _ := sim.Get(name); got != want {
+		t.Errorf("%s is %q, want %q", name, got, want)
+	}
+}
+
+func TestDump(t *testing.T) {
+	sim := useSim(t)
+	if err := sim.Set("p_pitch", "55"); err != nil {
+		t.Fatal(err)
+	}
+	out := run(t, "dump", "--output-dir", "out", "--sections", "diff-all,dump-all")
+
+	dir := filepath.Join("out", "Bench Quad")
+	for _, tt := range []struct {
+		file, command string
+		lines         []string
+	}{
+		{"BTFL_4.5.0_DIFF.txt", "diff all", []string{"set craft_name = Bench Quad", "set p_pitch = 55"}},
+		{"BTFL_4.5.0_DUMP.txt", "dump all", []string{"set craft_name = Bench Quad", "set p_pitch = 55", "set p_roll = 45"}},
+	} {
+		name := filepath.Join(dir, tt.file)
+		if !strings.Contains(out, name) {
+			t.Errorf("%s isn't reported in %q", name, out)
+		}
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text := string(b)
+		if !strings.HasPrefix(text, "# "+tt.command+"\r\n") {
+			t.Errorf("%s doesn't start with the command: %q", name, text[:min(len(text), 40)])
+		}
+		lines := strings.Split(text, "\r\n")
+		for _, want := range tt.lines {
+			found := false
+			for _, l := range lines {
+				found = found || l == want
+			}
+			if !found {
+				t.Errorf("%s has no line %q", name, want)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "BTFL_4.5.0_DIFF_CURRENT.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("unselected section was dumped")
+	}
+}
+
+func TestLoadSaves(t *testing.T) {
+	sim := useSim(t)
+	name := writeFile(t, "cfg.txt", "# diff all\r\nset gyro_lpf1_static_hz = 200\r\nfeature -TELEMETRY\r\nsave\r\n")
+	out := run(t, "load", name, "--continue-on-error=false")
+	if !strings.Contains(out, "Configuration loaded") {
+		t.Errorf("unexpected output %q", out)
+	}
+	checkSetting(t, sim, "gyro_lpf1_static_hz", "200")
+
+	// The snapshot taken before loading has the old value
+	snapshots, err := filepath.Glob(filepath.Join("Bench Quad", "BTFL_4.5.0_*_DIFF.txt"))
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("got snapshots %v, %v", snapshots, err)
+	}
+	b, err := os.ReadFile(snapshots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "gyro_lpf1_static_hz") {
+		t.Error("the snapshot has the loaded setting")
+	}
+}
+
+func TestLoadRejectedLine(t *testing.T) {
+	sim := useSim(t)
+	board, err := connectFC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer board.Close()
+	session, err := cli.Enter(board.Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.ParseString("set gyro_lpf1_static_hz = 200\r\nset no_such_setting = 1\r\nset small_angle = 999\r\n")
+	var progress []string
+	failed, err := applyConfig(session, cfg, func(l *config.Line, err error) {
+		progress = append(progress, l.Text)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) != 3 {
+		t.Errorf("got progress for %q", progress)
+	}
+	if len(failed) != 2 || failed[0].Line.Number != 2 || failed[1].Line.Number != 3 {
+		t.Fatalf("got failures %+v", failed)
+	}
+	for _, f := range failed {
+		var cmdErr *cli.CommandError
+		if !errors.As(f.Err, &cmdErr) {
+			t.Errorf("line %d failed with %v, want a CLI error", f.Line.Number, f.Err)
+		}
+	}
+
+	// Nothing is saved until the caller decides to
+	if err := session.Exit(); err != nil {
+		t.Fatal(err)
+	}
+	checkSetting(t, sim, "gyro_lpf1_static_hz", "250")
+}
+
+func TestLoadContinueOnError(t *testing.T) {
+	sim := useSim(t)
+	name := writeFile(t, "cfg.txt", "set gyro_lpf1_static_hz = 200\r\nset no_such_setting = 1\r\n")
+	run(t, "load", name, "--continue-on-error")
+	checkSetting(t, sim, "gyro_lpf1_static_hz", "200")
+}
+
+func TestVerifyConfig(t *testing.T) {
+	sim := useSim(t)
+	if err := sim.Set("gyro_lpf1_static_hz", "200"); err != nil {
+		t.Fatal(err)
+	}
+	board, err := connectFC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer board.Close()
+
+	// p_pitch is set back to its default, so it isn't in the diff, while
+	// p_roll didn't stick
+	cfg := config.ParseString("# diff all\r\nset gyro_lpf1_static_hz = 200\r\n\r\nprofile 0\r\nset p_pitch = 47\r\nset p_roll = 60\r\n")
+	changes, err := verifyConfig(board, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := config.Change{Section: config.Section{Kind: config.Profile}, Key: "set p_roll", Kind: config.Changed, Old: "60", New: "45"}
+	if len(changes) != 1 {
+		t.Fatalf("got %+v, want only %+v", changes, want)
+	}
+	if ch := changes[0]; ch.Section != want.Section || ch.Key != want.Key || ch.Kind != want.Kind || ch.Old != want.Old || ch.New != want.New {
+		t.Errorf("got %+v, want %+v", ch, want)
+	}
+}
+
+func TestCheckLoadable(t *testing.T) {
+	tests := []struct {
+		text string
+		// refused lists the lines refused, if any
+		refused string
+	}{
+		{"# diff all\r\nbatch start\r\ndefaults nosave\r\nset p_pitch = 50\r\nsave\r\n", ""},
+		{"set p_pitch = 50\r\nexit\r\n", ""},
+		{"DEFAULTS NOSAVE\r\n", ""},
+		{"defaults show\r\n", ""},
+		{"defaults bare nosave\r\n", ""},
+		{"defaults\r\nset p_pitch = 50\r\n", "line 1: defaults"},
+		{"set p_pitch = 50\r\ndefaults bare\r\n", "line 2: defaults bare"},
+		{"Defaults group_id 3\r\n", "line 1: Defaults group_id 3"},
+		{"set p_pitch = 50\r\nbl\r\n", "line 2: bl"},
+		{"bl rom\r\ndfu\r\n", "line 1: bl rom, line 2: dfu"},
+		{"msc utc\r\n", "line 1: msc utc"},
+		{"REBOOT\r\n", "line 1: REBOOT"},
+		{"# bl\r\n", ""},
+	}
+	for _, tt := range tests {
+		err := checkLoadable(config.ParseString(tt.text))
+		if tt.refused == "" {
+			if err != nil {
+				t.Errorf("%q: got error %v", tt.text, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "("+tt.refused+")") {
+			t.Errorf("%q: got error %v, want %s refused", tt.text, err, tt.refused)
+		}
+	}
+}
+
+func TestShell(t *testing.T) {
+	tests := []struct {
+		lines   []string
+		pitch   string
+		printed string
+	}{
+		{[]string{"set p_pitch = 50", "", "defaults nosave", "set p_pitch = 51", "save"}, "51", "Rebooting"},
+		{[]string{"set p_pitch = 50", "SAVE"}, "50", "Rebooting"},
+		{[]string{"set p_pitch = 50", "defaults"}, "47", "Rebooting"},
+		{[]string{"set p_pitch = 50"}, "47", "unsaved changes lost"},
+	}
+	for _, tt := range tests {
+		sim := useSim(t)
+		port := sim.Dial()
+		session, err := cli.Enter(port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := tt.lines
+		prompt := func() (string, error) {
+			if len(lines) == 0 {
+				return "", io.EOF
+			}
+			input := lines[0]
+			lines = lines[1:]
+			return input, nil
+		}
+		printed := captureStdout(t, func() { err = runShell(session, prompt, nil) })
+		port.Close()
+		if err != nil {
+			t.Errorf("%q: %v", tt.lines, err)
+		}
+		if len(lines) > 0 {
+			t.Errorf("%q: left the shell before %q", tt.lines, lines)
+		}
+		if !strings.Contains(printed, tt.printed) {
+			t.Errorf("%q: no %q in\n%s", tt.lines, tt.printed, printed)
+		}
+		checkSetting(t, sim, "p_pitch", tt.pitch)
+	}
+}
+
+func TestUnknownIdentity(t *testing.T) {
+	sim := useSim(t)
+	sim.Unsupported = map[uint16]bool{msp.MspBoardInfo: true}
+	name := writeFile(t, "cfg.txt", "# diff all\r\n# Betaflight / STM32F7X2 (S7X2) 4.5.0 Jan  1 2024 / 00:00:00 (abcdef0) MSP API: 1.46\r\n# board_name OTHERBOARD\r\nset gyro_lpf1_static_hz = 200\r\n")
+	run(t, "load", name)
+	checkSetting(t, sim, "gyro_lpf1_static_hz", "200")
+
+	run(t, "dump", "--output-dir", "out", "--sections", "diff-all")
+	if _, err := os.Stat(filepath.Join("out", "Bench Quad", "BTFL_4.5.0_DIFF.txt")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRenderDashboard(t *testing.T) {
+	sim := useSim(t)
+	sim.Unsupported = map[uint16]bool{msp.MspRawIMU: true, msp.MspBatteryState: true, msp.MspAnalog: true}
+	board, err := connectFC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer board.Close()
+	poller := board.NewPoller(monitorMessages...)
+	tm, err := poller.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, width := range []int{120, 40} {
+		out := renderDashboard(board, poller, tm, width)
+		var lines []string
+		for _, l := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+			l = strings.TrimSuffix(l, clearToEOL)
+			if n := len([]rune(l)); n > width {
+				t.Errorf("line %q is %d wide", l, n)
+			}
+			lines = append(lines, l)
+		}
+		text := strings.Join(lines, "\n")
+		for _, want := range []string{
+			"BTFL 4.5.0 Bench Quad",
+			"Attitude   roll",
+			"IMU        not supported by BTFL",
+			"Battery    not supported by BTFL",
+			"Arming",
+			"RC channels",
+			"Motors",
+		} {
+			if !strings.Contains(text, want[:min(len(want), width)]) {
+				t.Errorf("width %d: no %q in\n%s", width, want, text)
+			}
+		}
+		if strings.Contains(text, "Gyro") {
+			t.Errorf("width %d: gyro readings shown:\n%s", width, text)
+		}
+	}
+}
+
+func TestServeSetting(t *testing.T) {
+	sim := useSim(t)
+	board, err := connectFC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer board.Close()
+	s := &fcServer{board: board}
+
+	tests := []struct {
+		method, name, body string
+		status             int
+		want               string
+	}{
+		{http.MethodGet, "p_pitch", "", http.StatusOK, `"value":"47"`},
+		{http.MethodGet, "no_such_setting", "", http.StatusNotFound, "unknown setting"},
+		{http.MethodPut, "p_pitch", `{"value": "9999"}`, http.StatusBadRequest, "Allowed range: 0 - 250"},
+		{http.MethodPut, "P_PITCH", `{"value": "55"}`, http.StatusOK, `"value":"55"`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.setting(w, httptest.NewRequest(tt.method, "/api/settings/"+tt.name, strings.NewReader(tt.body)))
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s %s: got %d %s, want %d with %q", tt.method, tt.name, w.Code, w.Body, tt.status, tt.want)
+		}
+	}
+	checkSetting(t, sim, "p_pitch", "55")
+}
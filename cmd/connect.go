@@ -0,0 +1,99 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/robhaswell/btflcli/msp"
+	"go.bug.st/serial"
+
+	// Register sim:// ports for working without a board
+	_ "github.com/robhaswell/btflcli/fcsim"
+)
+
+const (
+	defaultBaudRate = 115200
+	probeTimeout    = 500 * time.Millisecond
+)
+
+var (
+	portName string
+	baudRate int
+	mspV2    bool
+)
+
+// connectFC connects to the flight controller on the port given with --port,
+// or to the only board found by probing the serial ports otherwise.
+func connectFC() (*fc.FC, error) {
+	name := portName
+	if name == "" {
+		var err error
+		name, err = detectPort()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Create the fc options to connect to the flight controller. Progress
+	// goes to stderr so the output of commands can be piped.
+	fcOpts := fc.FCOptions{
+		PortName:    name,
+		BaudRate:    baudRate,
+		PreferMSPV2: mspV2,
+		Stdout:      os.Stderr,
+	}
+
+	// Initialise the flight controller connection
+	return fc.NewFC(fcOpts)
+}
+
+// readIdentity requests the identity of the FC. Firmware which refuses the
+// identity requests gives an empty identity and a warning, so that nothing is
+// compared against it or recorded from it.
+func readIdentity(board *fc.FC) (*fc.Identity, error) {
+	id, err := board.Identity()
+	if msp.IsReplyError(err) {
+		fmt.Fprintf(os.Stderr, "Warning: the identity of the flight controller is unknown: %s\n", err)
+		return &fc.Identity{}, nil
+	}
+	return id, err
+}
+
+// detectPort probes all serial ports for flight controllers, reports each one
+// that answered and returns its port if there is exactly one.
+func detectPort() (string, error) {
+	ports, err := serial.GetPortsList()
+	if err != nil {
+		return "", err
+	}
+	boards := fc.Detect(ports, baudRate, probeTimeout)
+	for _, b := range boards {
+		fmt.Fprintf(os.Stderr, "Found %s\n", b)
+	}
+	switch len(boards) {
+	case 0:
+		return "", errors.New("no flight controller found, connect one or select a port with --port")
+	case 1:
+		return boards[0].PortName, nil
+	default:
+		return "", fmt.Errorf("found %d flight controllers, select one with --port", len(boards))
+	}
+}
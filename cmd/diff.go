@@ -0,0 +1,189 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/robhaswell/btflcli/cli"
+	"github.com/robhaswell/btflcli/config"
+	"github.com/spf13/cobra"
+)
+
+var diffFormat string
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <a> [b]",
+	Short: "Show the settings that differ between two configurations",
+	Long: `Compare two diff or dump files, or a file and the connected flight controller
+if only one file is given. Settings are compared per section and profile, so
+the order of the lines and any comments don't matter.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run:  diffConfigs,
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVarP(&diffFormat, "format", "f", "human", "output format: human, patch or json")
+}
+
+func diffConfigs(cmd *cobra.Command, args []string) {
+	switch diffFormat {
+	case "human", "patch", "json":
+	default:
+		log.Fatalf("Unknown format %q", diffFormat)
+	}
+
+	a, err := readConfigFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	var b *config.Config
+	bName := "flight controller"
+	if len(args) == 2 {
+		bName = args[1]
+		b, err = readConfigFile(bName)
+	} else {
+		b, err = readBoardConfig()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	changes := config.Compare(a, b)
+	switch diffFormat {
+	case "json":
+		if changes == nil {
+			changes = []config.Change{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(changes)
+	case "patch":
+		err = writePatch(os.Stdout, args[0], bName, changes)
+	default:
+		err = writeHumanDiff(os.Stdout, changes)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func readConfigFile(name string) (*config.Config, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return config.Parse(f)
+}
+
+// readBoardConfig reads `diff all` from the connected flight controller.
+func readBoardConfig() (*config.Config, error) {
+	fc, err := connectFC()
+	if err != nil {
+		return nil, err
+	}
+	session, err := cli.Enter(fc.Port)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Exit()
+	diffAll, err := readFcDump(session, "diff all")
+	if err != nil {
+		return nil, err
+	}
+	return config.ParseString(diffAll), nil
+}
+
+// showValue formats one side of a change, where a setting missing from a
+// diff has its default value.
+func showValue(v string) string {
+	if v == "" {
+		return "(default)"
+	}
+	return v
+}
+
+func writeHumanDiff(w io.Writer, changes []config.Change) error {
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(w, "No differences")
+		return err
+	}
+	for ii, ch := range changes {
+		if ii == 0 || changes[ii-1].Section != ch.Section {
+			if ii > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "[%s]\n", ch.Section)
+		}
+		key := strings.TrimPrefix(ch.Key, "set ")
+		var err error
+		if strings.HasPrefix(ch.Key, "set ") {
+			_, err = fmt.Fprintf(w, "  %s: %s -> %s\n", key, showValue(ch.Old), showValue(ch.New))
+		} else {
+			switch ch.Kind {
+			case config.Added:
+				_, err = fmt.Fprintf(w, "  + %s\n", ch.New)
+			case config.Removed:
+				_, err = fmt.Fprintf(w, "  - %s\n", ch.Old)
+			default:
+				_, err = fmt.Fprintf(w, "  %s -> %s\n", ch.Old, ch.New)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changeLine returns the CLI text for one side of a change.
+func changeLine(ch config.Change, value string) string {
+	if name, ok := strings.CutPrefix(ch.Key, "set "); ok {
+		return fmt.Sprintf("set %s = %s", name, value)
+	}
+	return value
+}
+
+// writePatch writes the changes as a unified diff, with a hunk per section.
+func writePatch(w io.Writer, aName, bName string, changes []config.Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", aName, bName)
+	for ii, ch := range changes {
+		if ii == 0 || changes[ii-1].Section != ch.Section {
+			fmt.Fprintf(w, "@@ %s @@\n", ch.Section)
+		}
+		if ch.Kind != config.Added {
+			fmt.Fprintf(w, "-%s\n", changeLine(ch, ch.Old))
+		}
+		if ch.Kind != config.Removed {
+			if _, err := fmt.Fprintf(w, "+%s\n", changeLine(ch, ch.New)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/robhaswell/btflcli/config"
+)
+
+// diffTests are pairs of configurations with the human and patch output for
+// the differences between them.
+var diffTests = []struct {
+	name  string
+	a, b  string
+	human string
+	patch string
+}{
+	{
+		"no differences",
+		"# diff\r\nset p_pitch = 50\r\n",
+		"set P_PITCH = 50\r\n",
+		"No differences\n",
+		"",
+	},
+	{
+		"settings",
+		"set p_pitch = 50\r\nset p_roll = 45\r\n",
+		"set p_roll = 46\r\nset d_roll = 30\r\n",
+		`[master]
+  p_pitch: 50 -> (default)
+  p_roll: 45 -> 46
+  d_roll: (default) -> 30
+`,
+		`--- a.txt
++++ b.txt
+@@ master @@
+-set p_pitch = 50
+-set p_roll = 45
++set p_roll = 46
++set d_roll = 30
+`,
+	},
+	{
+		"other commands",
+		"feature -AIRMODE\r\nserial 0 64 115200 57600 0 115200\r\naux 0 0 0 1700 2100 0 0\r\n",
+		"feature AIRMODE\r\nserial 0 1 115200 57600 0 115200\r\nresource MOTOR 1 B04\r\n",
+		`[master]
+  feature -AIRMODE -> feature AIRMODE
+  serial 0 64 115200 57600 0 115200 -> serial 0 1 115200 57600 0 115200
+  - aux 0 0 0 1700 2100 0 0
+  + resource MOTOR 1 B04
+`,
+		`--- a.txt
++++ b.txt
+@@ master @@
+-feature -AIRMODE
++feature AIRMODE
+-serial 0 64 115200 57600 0 115200
++serial 0 1 115200 57600 0 115200
+-aux 0 0 0 1700 2100 0 0
++resource MOTOR 1 B04
+`,
+	},
+	{
+		"sections",
+		"rateprofile 1\r\nset roll_rc_rate = 7\r\nprofile 2\r\nset p_pitch = 50\r\n",
+		"set name = Quad\r\nprofile 2\r\nset p_pitch = 52\r\nrateprofile 1\r\nset roll_rc_rate = 8\r\n",
+		`[master]
+  name: (default) -> Quad
+
+[profile 2]
+  p_pitch: 50 -> 52
+
+[rateprofile 1]
+  roll_rc_rate: 7 -> 8
+`,
+		`--- a.txt
++++ b.txt
+@@ master @@
++set name = Quad
+@@ profile 2 @@
+-set p_pitch = 50
++set p_pitch = 52
+@@ rateprofile 1 @@
+-set roll_rc_rate = 7
++set roll_rc_rate = 8
+`,
+	},
+}
+
+func TestWriteHumanDiff(t *testing.T) {
+	for _, tt := range diffTests {
+		var b strings.Builder
+		changes := config.Compare(config.ParseString(tt.a), config.ParseString(tt.b))
+		if err := writeHumanDiff(&b, changes); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.human {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, b.String(), tt.human)
+		}
+	}
+}
+
+func TestWritePatch(t *testing.T) {
+	for _, tt := range diffTests {
+		var b strings.Builder
+		changes := config.Compare(config.ParseString(tt.a), config.ParseString(tt.b))
+		if err := writePatch(&b, "a.txt", "b.txt", changes); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.patch {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, b.String(), tt.patch)
+		}
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	// jsonChange is a Change as it is written out
+	type jsonChange struct {
+		Section string
+		Key     string
+		Kind    config.ChangeKind
+		Old     string
+		New     string
+		OldLine int `json:"old_line"`
+		NewLine int `json:"new_line"`
+	}
+	useSim(t)
+	for _, tt := range diffTests {
+		a := writeFile(t, "a.txt", tt.a)
+		b := writeFile(t, "b.txt", tt.b)
+		out := run(t, "diff", a, b, "--format", "json")
+		var got []jsonChange
+		if err := json.Unmarshal([]byte(out), &got); err != nil {
+			t.Fatalf("%s: %v in %s", tt.name, err, out)
+		}
+		want := []jsonChange{}
+		for _, ch := range config.Compare(config.ParseString(tt.a), config.ParseString(tt.b)) {
+			want = append(want, jsonChange{ch.Section.String(), ch.Key, ch.Kind, ch.Old, ch.New, ch.OldLine, ch.NewLine})
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, want)
+		}
+	}
+}
@@ -16,143 +16,330 @@ limitations under the License.
 package cmd
 
 import (
-	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"text/template"
 	"time"
 
+	"github.com/robhaswell/btflcli/cli"
+	"github.com/robhaswell/btflcli/config"
 	"github.com/robhaswell/btflcli/fc"
-
+	"github.com/robhaswell/btflcli/history"
 	"github.com/spf13/cobra"
-	"go.bug.st/serial"
 )
 
-type MyPIDReceiver struct {
+const (
+	defaultDumpName = "{{.CraftName}}/{{.Variant}}_{{.Version}}_{{.Kind}}.txt"
+	// unnamedCraft is the directory used for a board without a craft_name
+	unnamedCraft = "unnamed"
+)
+
+// dumpSections are the diffs and dumps the dump command can capture, by the
+// name used with --sections, with the CLI command and the Kind used in the
+// file name.
+var dumpSections = []struct {
+	Name    string
+	Command string
+	Kind    string
+}{
+	{"diff-all", "diff all", "DIFF"},
+	{"dump-all", "dump all", "DUMP"},
+	{"diff", "diff", "DIFF_CURRENT"},
+	{"dump-hardware", "dump hardware", "DUMP_HARDWARE"},
+	{"dump-master", "dump master", "DUMP_MASTER"},
+	{"dump-profile", "dump profile", "DUMP_PROFILE"},
+	{"dump-rates", "dump rates", "DUMP_RATES"},
 }
 
-const (
-	baudRate = 115200
+var (
+	dumpOutputDir string
+	dumpName      string
+	dumpSelected  []string
+	dumpGit       bool
 )
 
+type MyPIDReceiver struct {
+}
+
 // dumpCmd represents the dump command
 var dumpCmd = &cobra.Command{
 	Use:   "dump",
 	Short: "Dump the configuration from a connected flight controller",
-	Run:   dumpBoard,
+	Long: `Dump the configuration from a connected flight controller.
+
+By default 'diff all' and 'dump all' are saved to a directory named after the
+craft. Use --sections to choose what to capture from diff-all, dump-all, diff,
+dump-hardware, dump-master, dump-profile and dump-rates.
+
+The file names are made from the --name template, which can use these fields:
+
+  {{.CraftName}}  the craft_name, or "unnamed" if it isn't set
+  {{.Variant}}    the firmware variant, e.g. BTFL
+  {{.Version}}    the firmware version, e.g. 4.5.0
+  {{.BoardName}}  the board_name, e.g. SPEEDYBEEF7V3
+  {{.UID}}        the unique ID of the MCU
+  {{.Date}}       the date of the dump, e.g. 2023-12-09
+  {{.Time}}       the time of the dump, e.g. 142501
+  {{.Kind}}       what was captured, e.g. DIFF or DUMP_HARDWARE
+
+Characters which aren't safe in file names are replaced with underscores.
+
+With --git the directory given with --output-dir is kept as a git repository,
+which mustn't be inside another one, and each dump is committed unless nothing
+changed but comments or the order of the lines. The history command shows and
+restores earlier revisions.`,
+	Run: dumpBoard,
 }
 
 func init() {
 	rootCmd.AddCommand(dumpCmd)
 
-	// Here you will define your flags and configuration settings.
+	addOutputDirFlag(dumpCmd, "directory to write the files to")
+	dumpCmd.Flags().StringVar(&dumpName, "name", defaultDumpName, "template for the file names")
+	dumpCmd.Flags().StringSliceVar(&dumpSelected, "sections", []string{"diff-all", "dump-all"}, "diffs and dumps to capture")
+	dumpCmd.Flags().BoolVar(&dumpGit, "git", false, "commit the files to a git repository in the output directory")
+}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// dumpCmd.PersistentFlags().String("foo", "", "A help for foo")
+// addOutputDirFlag adds --output-dir to a command that reads or writes the
+// files in a craft's directory, which are found there the same way by every
+// command.
+func addOutputDirFlag(cmd *cobra.Command, usage string) {
+	cmd.Flags().StringVarP(&dumpOutputDir, "output-dir", "o", ".", usage)
+}
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// dumpCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+// dumpFields are the fields of the --name template.
+type dumpFields struct {
+	CraftName string
+	Variant   string
+	Version   string
+	BoardName string
+	UID       string
+	Date      string
+	Time      string
+	Kind      string
 }
 
-// Connect to the flight controller over serial, request a dump and save it to a file
-func dumpBoard(cmd *cobra.Command, args []string) {
-	// Get the most recently connected serial device
-	ports, err := serial.GetPortsList()
+// parseDumpName parses a --name template, checking it only uses the fields
+// of dumpFields.
+func parseDumpName(name string) (*template.Template, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(name)
+	if err == nil {
+		err = tmpl.Execute(io.Discard, dumpFields{})
+	}
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("invalid --name: %w", err)
 	}
+	return tmpl, nil
+}
 
-	portName := ports[len(ports)-1]
-
-	// Create the fc options to connect to the flight controller
-	fcOpts := fc.FCOptions{
-		PortName: portName,
-		BaudRate: baudRate,
+// dumpPath returns the path of a dump relative to the output directory.
+func dumpPath(tmpl *template.Template, fields dumpFields) (string, error) {
+	var name bytes.Buffer
+	if err := tmpl.Execute(&name, fields); err != nil {
+		return "", fmt.Errorf("invalid --name: %w", err)
 	}
+	return sanitisePath(name.String()), nil
+}
 
-	// Initialise the flight controller connection
-	fc, err := fc.NewFC(fcOpts)
+// Connect to the flight controller over serial, request a dump and save it to a file
+func dumpBoard(cmd *cobra.Command, args []string) {
+	// Catch unknown fields before connecting
+	tmpl, err := parseDumpName(dumpName)
 	if err != nil {
 		log.Fatal(err)
 	}
-	p := fc.Port
-	diffFilename := fmt.Sprintf("%s/%s_%d.%d.%d_DIFF.txt", fc.Name, fc.Variant, fc.VersionMajor, fc.VersionMinor, fc.VersionPatch)
-	dumpFilename := fmt.Sprintf("%s/%s_%d.%d.%d_DUMP.txt", fc.Name, fc.Variant, fc.VersionMajor, fc.VersionMinor, fc.VersionPatch)
-
-	// Create a reader utility to read from the flight controller
-	reader := bufio.NewReader(p)
-	scanner := bufio.NewScanner(reader)
-	scanner.Split(bufio.ScanLines)
-
-	// Activate the CLI mode
-	p.Write([]byte("#\r\n"))
-
-	for scanner.Scan() {
-		// Read a line from the flight controller
-		line := scanner.Text()
-		if strings.Contains(line, "Entering CLI Mode") {
-			// Read one more blank link from the FC.
-			scanner.Scan()
-			break
+	if dumpGit && !cmd.Flags().Changed("output-dir") {
+		log.Fatal("--git needs --output-dir, the directory to keep the history in")
+	}
+	var commands []string
+	var kinds []string
+	for _, name := range dumpSelected {
+		found := false
+		for _, s := range dumpSections {
+			if s.Name == name {
+				commands = append(commands, s.Command)
+				kinds = append(kinds, s.Kind)
+				found = true
+			}
+		}
+		if !found {
+			log.Fatalf("Unknown section %q", name)
 		}
 	}
 
-	// Request a diff
-	p.Write([]byte("diff all\r\n"))
-	diffAll := readFcDump(scanner)
+	// Open the history before connecting, so that failing to doesn't leave
+	// the FC in CLI mode
+	var repo *history.Repo
+	var head *history.Revision
+	if dumpGit {
+		if err := os.MkdirAll(dumpOutputDir, os.ModePerm); err != nil {
+			log.Fatal(err)
+		}
+		if repo, err = history.OpenOrInit(dumpOutputDir); err != nil {
+			log.Fatal(err)
+		}
+		if head, err = repo.Head(); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	// Make the output directory if it doesn't exist
-	os.MkdirAll(fc.Name, os.ModePerm)
+	// Connect to the selected or auto-detected flight controller
+	fc, err := connectFC()
+	if err != nil {
+		log.Fatal(err)
+	}
+	id, err := readIdentity(fc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	now := time.Now()
+	fields := dumpFields{
+		CraftName: sanitiseFilename(craftName(fc)),
+		Variant:   sanitiseFilename(fc.Variant),
+		Version:   fmt.Sprintf("%d.%d.%d", fc.VersionMajor, fc.VersionMinor, fc.VersionPatch),
+		BoardName: sanitiseFilename(id.BoardName),
+		UID:       id.MCUID,
+		Date:      now.Format("2006-01-02"),
+		Time:      now.Format("150405"),
+	}
 
-	// Write the diff to a file
-	err = os.WriteFile(diffFilename, []byte(diffAll), 0644)
+	// Activate the CLI mode
+	session, err := cli.Enter(fc.Port)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// reader.Reset(p)
-	// log.Println(scanner.Text())
+	var written, unchanged, paths []string
+	for ii, command := range commands {
+		fields.Kind = kinds[ii]
+		rel, err := dumpPath(tmpl, fields)
+		if err != nil {
+			log.Fatal(err)
+		}
+		filename := filepath.Join(dumpOutputDir, rel)
 
-	// Request a dump
-	p.Write([]byte("dump all\r\n"))
-	dumpAll := readFcDump(scanner)
+		output, err := readFcDump(session, command)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	// Write the dump to a file
-	err = os.WriteFile(dumpFilename, []byte(dumpAll), 0644)
+		// Leave files alone if nothing changed but comments or ordering
+		if head != nil {
+			old, err := repo.ReadFile(head.Hash, filepath.ToSlash(rel))
+			if err != nil && !errors.Is(err, history.ErrNotFound) {
+				log.Fatal(err)
+			}
+			if err == nil && len(config.Compare(config.ParseBytes(old), config.ParseString(output))) == 0 {
+				unchanged = append(unchanged, filename)
+				continue
+			}
+		}
+
+		// Make the output directory if it doesn't exist
+		if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(output), 0644); err != nil {
+			log.Fatal(err)
+		}
+		written = append(written, filename)
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	session.Exit()
+
+	if len(written) > 0 {
+		fmt.Printf("Written files: %s\n", strings.Join(written, ", "))
+	}
+	if len(unchanged) > 0 {
+		fmt.Printf("Unchanged files: %s\n", strings.Join(unchanged, ", "))
+	}
+	if repo == nil {
+		return
+	}
+	if len(paths) == 0 {
+		fmt.Printf("No changes since %s\n", head.Short())
+		return
+	}
+	rev, err := repo.Commit(paths, historyMessage(fc, id))
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("Written files: %s, %s\n", diffFilename, dumpFilename)
-
-	closeFcCli(p)
+	fmt.Printf("Committed %s: %s\n", rev.Short(), rev.Subject())
 }
 
-func readFcDump(scanner *bufio.Scanner) string {
-	output := ""
+// historyMessage returns the commit message for a dump added to the history.
+func historyMessage(board *fc.FC, id *fc.Identity) string {
+	version := fmt.Sprintf("%d.%d.%d", board.VersionMajor, board.VersionMinor, board.VersionPatch)
+	return fmt.Sprintf("%s: %s %s on %s\n\nVariant: %s\nVersion: %s\nBoard: %s\nUID: %s\n",
+		craftName(board), board.Variant, version, id.BoardName,
+		board.Variant, version, id.BoardName, id.MCUID)
+}
 
-	timeStart := time.Now()
+// craftName returns the craft_name of the FC, or a placeholder if it hasn't
+// been set.
+func craftName(board *fc.FC) string {
+	if strings.TrimSpace(board.Name) == "" {
+		return unnamedCraft
+	}
+	return board.Name
+}
 
-	// Read the dump
-	for scanner.Scan() {
-		// Check for timeout
-		if time.Since(timeStart) > 5*time.Second {
-			log.Fatal("Timed out waiting for dump")
+// sanitiseFilename replaces the characters of s which aren't safe in a file
+// name, including path separators, with underscores.
+func sanitiseFilename(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20, r == 0x7f, strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
 		}
-		// Read a line from the flight controller
-		line := scanner.Text()
-		output += line + "\r\n"
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+	// Leading dots would hide the file or climb out of the directory
+	if strings.Trim(s, ".") == "" || strings.HasPrefix(s, ".") {
+		s = "_" + s
+	}
+	return s
+}
 
-		// Look to see that we got the whole dump
-		if strings.HasSuffix(output, "\r\nsave\r\n") {
-			break
+// sanitisePath sanitises each element of a slash separated path, so that
+// the result is always relative and inside the output directory.
+func sanitisePath(p string) string {
+	var elems []string
+	for _, e := range strings.Split(p, "/") {
+		if e == "" {
+			continue
 		}
+		elems = append(elems, sanitiseFilename(e))
 	}
-	return output
+	return filepath.Join(elems...)
+}
+
+// craftDir returns the directory for files about the craft, where dump puts
+// them with the default --name.
+func craftDir(board *fc.FC) string {
+	return filepath.Join(dumpOutputDir, sanitiseFilename(craftName(board)))
 }
 
-func closeFcCli(p serial.Port) {
-	p.Write([]byte("exit\r\n"))
+// craftFilename returns the name of a file in the craft's directory named
+// after the firmware, e.g. My Quad/BTFL_4.5.0_DIFF.txt for suffix DIFF.
+func craftFilename(board *fc.FC, suffix string) string {
+	return filepath.Join(craftDir(board),
+		fmt.Sprintf("%s_%d.%d.%d_%s.txt", sanitiseFilename(board.Variant), board.VersionMajor, board.VersionMinor, board.VersionPatch, suffix))
+}
+
+// readFcDump runs a diff or dump command and returns its output in the same
+// form as the Configurator saves it.
+func readFcDump(session *cli.Session, command string) (string, error) {
+	lines, err := session.Run(command)
+	if err != nil {
+		return "", err
+	}
+	return "# " + command + "\r\n" + strings.Join(lines, "\r\n") + "\r\n", nil
 }
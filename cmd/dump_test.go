@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robhaswell/btflcli/fc"
+)
+
+func TestSanitiseFilename(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"My Quad", "My Quad"},
+		{"  Padded  ", "Padded"},
+		{"a/b\\c", "a_b_c"},
+		{`what?*:"<>|`, "what_______"},
+		{"tab\there", "tab_here"},
+		{".", "_."},
+		{"..", "_.."},
+		{"...", "_..."},
+		{".hidden", "_.hidden"},
+		{"", "_"},
+		{"Quad.v2", "Quad.v2"},
+	}
+	for _, tt := range tests {
+		if got := sanitiseFilename(tt.name); got != tt.want {
+			t.Errorf("sanitiseFilename(%q) is %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSanitisePath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"My Quad/BTFL_4.5.0_DIFF.txt", "My Quad/BTFL_4.5.0_DIFF.txt"},
+		{"/etc/passwd", "etc/passwd"},
+		{"../../outside.txt", "_../_../outside.txt"},
+		{"a/./b", "a/_./b"},
+		{"a//b/", "a/b"},
+		{`C:\quads\diff.txt`, `C__quads_diff.txt`},
+	}
+	for _, tt := range tests {
+		got := sanitisePath(tt.path)
+		if want := filepath.FromSlash(tt.want); got != want {
+			t.Errorf("sanitisePath(%q) is %q, want %q", tt.path, got, want)
+		}
+		if filepath.IsAbs(got) || strings.HasPrefix(got, "..") {
+			t.Errorf("sanitisePath(%q) is %q, outside the output directory", tt.path, got)
+		}
+	}
+}
+
+func TestCraftName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"My Quad", "My Quad"},
+		{"", "unnamed"},
+		{"   ", "unnamed"},
+	}
+	for _, tt := range tests {
+		if got := craftName(&fc.FC{Name: tt.name}); got != tt.want {
+			t.Errorf("craftName(%q) is %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDumpPath(t *testing.T) {
+	fields := dumpFields{
+		CraftName: "My Quad",
+		Variant:   "BTFL",
+		Version:   "4.5.0",
+		BoardName: "SPEEDYBEEF7V3",
+		UID:       "3b0026003133510735363636",
+		Date:      "2023-12-09",
+		Time:      "142501",
+		Kind:      "DIFF",
+	}
+	tests := []struct {
+		name string
+		// want is the path, or what the error contains
+		want string
+		err  bool
+	}{
+		{defaultDumpName, "My Quad/BTFL_4.5.0_DIFF.txt", false},
+		{"{{.BoardName}}/{{.UID}}/{{.Date}}_{{.Time}}_{{.Kind}}.txt", "SPEEDYBEEF7V3/3b0026003133510735363636/2023-12-09_142501_DIFF.txt", false},
+		{"/abs/{{.CraftName}}.txt", "abs/My Quad.txt", false},
+		{"../{{.CraftName}}/../../{{.Kind}}", "_../My Quad/_../_../DIFF", false},
+		{"{{.Date}}", "2023-12-09", false},
+		{"{{.Missing}}.txt", "can't evaluate field Missing", true},
+		{"{{.Date.Format \"2006\"}}", "can't evaluate field Format", true},
+		{"{{.CraftName", "unclosed action", true},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseDumpName(tt.name)
+		var got string
+		if err == nil {
+			got, err = dumpPath(tmpl, fields)
+		}
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s: got %q, %v, want an error with %q", tt.name, got, err, tt.want)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/robhaswell/btflcli/config"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	exportFormat string
+	exportOutput string
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Convert a configuration to JSON or YAML",
+	Long: `Convert a diff or dump to a structured JSON or YAML document, with the board
+identity, features, serial ports, modes, resources and the settings of each
+profile and rate profile. Without a file the configuration is read from the
+connected flight controller.
+
+The import command converts the document back into CLI commands.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  exportConfig,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "json", "output format: json or yaml")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "file to write the document to (default is stdout)")
+}
+
+func exportConfig(cmd *cobra.Command, args []string) {
+	if exportFormat != "json" && exportFormat != "yaml" {
+		log.Fatalf("Unknown format %q", exportFormat)
+	}
+
+	var cfg *config.Config
+	var err error
+	if len(args) == 1 {
+		cfg, err = readConfigFile(args[0])
+	} else {
+		cfg, err = readBoardConfig()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var data []byte
+	if exportFormat == "yaml" {
+		data, err = yaml.Marshal(cfg.Document())
+	} else {
+		data, err = json.MarshalIndent(cfg.Document(), "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if exportOutput == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(exportOutput, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
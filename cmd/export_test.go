@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/robhaswell/btflcli/config"
+)
+
+func TestExportImport(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("..", "config", "testdata", "*_DIFF.txt"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("got fixtures %v, %v", fixtures, err)
+	}
+	for ii := range fixtures {
+		if fixtures[ii], err = filepath.Abs(fixtures[ii]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sim := useSim(t)
+	t.Cleanup(func() {
+		exportFormat, exportOutput = "json", ""
+		importFormat, importOutput = "", ""
+	})
+	if err := sim.Set("p_pitch", "55"); err != nil {
+		t.Fatal(err)
+	}
+	board, err := readBoardConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	boardDiff := writeFile(t, "board.txt", board.String())
+
+	for _, format := range []string{"json", "yaml"} {
+		// Without a file, export reads the connected FC
+		sources := map[string][]string{boardDiff: {"export", "--format", format}}
+		for _, f := range fixtures {
+			sources[f] = []string{"export", f, "--format", format}
+		}
+		for source, args := range sources {
+			doc := "doc." + format
+			run(t, append(args, "--output", doc)...)
+			run(t, "import", doc, "--output", "back.txt")
+
+			want, err := readConfigFile(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := readConfigFile("back.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ch := range config.Compare(want, got) {
+				t.Errorf("%s as %s: %s %s %s: %q, %q", filepath.Base(source), format, ch.Section, ch.Key, ch.Kind, ch.Old, ch.New)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/robhaswell/btflcli/exporter"
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/spf13/cobra"
+	"go.bug.st/serial"
+)
+
+var (
+	exporterListen        string
+	exporterInterval      time.Duration
+	exporterRetryInterval time.Duration
+)
+
+// exporterCmd represents the exporter command
+var exporterCmd = &cobra.Command{
+	Use:   "exporter [port...]",
+	Short: "Serve the state of flight controllers as Prometheus metrics",
+	Long: `Keep a connection open to the flight controller on each port and serve its
+status, battery and attitude as Prometheus metrics on /metrics. Each metric
+is labelled with the craft name, firmware variant and version, MCU ID and
+port.
+
+The ports are given as arguments, or with --port. Without either, every
+flight controller found on the serial ports is exported. Boards which are
+disconnected are reconnected when they come back.`,
+	Run: runExporter,
+}
+
+func init() {
+	rootCmd.AddCommand(exporterCmd)
+
+	exporterCmd.Flags().StringVar(&exporterListen, "listen", ":9110", "address to serve metrics on")
+	exporterCmd.Flags().DurationVar(&exporterInterval, "interval", time.Second, "time between polls of each flight controller")
+	exporterCmd.Flags().DurationVar(&exporterRetryInterval, "retry-interval", 5*time.Second, "time to wait before reconnecting to a flight controller")
+}
+
+func runExporter(cmd *cobra.Command, args []string) {
+	ports := args
+	if len(ports) == 0 && portName != "" {
+		ports = []string{portName}
+	}
+	if len(ports) == 0 {
+		var err error
+		if ports, err = detectPorts(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	e := exporter.New(ports, exporter.Options{
+		BaudRate:      baudRate,
+		PreferMSPV2:   mspV2,
+		Interval:      exporterInterval,
+		RetryInterval: exporterRetryInterval,
+	})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	go e.Run(context.Background())
+
+	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	log.Printf("Serving metrics for %d flight controller(s) on %s/metrics", len(ports), exporterListen)
+	log.Fatal(http.ListenAndServe(exporterListen, nil))
+}
+
+// detectPorts returns the ports of every flight controller found by probing
+// the serial ports.
+func detectPorts() ([]string, error) {
+	ports, err := serial.GetPortsList()
+	if err != nil {
+		return nil, err
+	}
+	var found []string
+	for _, b := range fc.Detect(ports, baudRate, probeTimeout) {
+		log.Printf("Found %s", b)
+		found = append(found, b.PortName)
+	}
+	if len(found) == 0 {
+		return nil, errors.New("no flight controller found, connect one or give its port")
+	}
+	return found, nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/robhaswell/btflcli/cli"
+	"github.com/spf13/cobra"
+)
+
+var getFormat string
+
+// getCmd represents the get command
+var getCmd = &cobra.Command{
+	Use:   "get <name|prefix>...",
+	Short: "Show the value of settings on the flight controller",
+	Long: `Show the value of every setting whose name contains one of the arguments, as
+the CLI get command does.
+
+The plain format prints a "name = value" line per setting. The json format
+adds the profile the value is from and the values the setting accepts.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  get,
+}
+
+func init() {
+	rootCmd.AddCommand(getCmd)
+
+	getCmd.Flags().StringVarP(&getFormat, "format", "f", "plain", "output format: plain or json")
+}
+
+func get(cmd *cobra.Command, args []string) {
+	if getFormat != "plain" && getFormat != "json" {
+		log.Fatalf("Unknown format %q", getFormat)
+	}
+
+	// Connect to the selected or auto-detected flight controller
+	fc, err := connectFC()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Activate the CLI mode
+	session, err := cli.Enter(fc.Port)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	vars := []cli.Variable{}
+	for _, name := range args {
+		found, err := session.Get(name)
+		if err != nil {
+			session.Exit()
+			log.Fatal(err)
+		}
+		vars = append(vars, found...)
+	}
+	session.Exit()
+
+	if getFormat == "json" {
+		printJSON(vars)
+		return
+	}
+	for _, v := range vars {
+		fmt.Printf("%s = %s\n", v.Name, v.Value)
+	}
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatal(err)
+	}
+}
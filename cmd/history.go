@@ -0,0 +1,155 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/robhaswell/btflcli/config"
+	"github.com/robhaswell/btflcli/history"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyRepo string
+	historyFile string
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history [craft]",
+	Short: "List the dumps committed with dump --git",
+	Long: `List the revisions of the configuration history kept by dump --git, newest
+first. Give a craft's directory name to only list the revisions for that craft.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  listHistory,
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show <craft> <rev>",
+	Short: "Print a craft's configuration at a revision",
+	Args:  cobra.ExactArgs(2),
+	Run:   showHistory,
+}
+
+var historyRestoreCmd = &cobra.Command{
+	Use:   "restore <craft> <rev>",
+	Short: "Load a craft's configuration at a revision to the connected flight controller",
+	Long: `Load a craft's configuration at a revision to the connected flight controller,
+in the same way as the load command.`,
+	Args: cobra.ExactArgs(2),
+	Run:  restoreHistory,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyShowCmd)
+	historyCmd.AddCommand(historyRestoreCmd)
+
+	historyCmd.PersistentFlags().StringVar(&historyRepo, "repo", ".", "directory of the history repository")
+	for _, cmd := range []*cobra.Command{historyShowCmd, historyRestoreCmd} {
+		cmd.Flags().StringVar(&historyFile, "file", "", "file in the craft's directory (default is the newest diff)")
+	}
+	addLoadFlags(historyRestoreCmd)
+}
+
+func listHistory(cmd *cobra.Command, args []string) {
+	repo, err := history.Open(historyRepo)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir := ""
+	if len(args) == 1 {
+		dir = args[0]
+	}
+	revs, err := repo.Log(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(revs) == 0 {
+		fmt.Println("No history")
+		return
+	}
+	for _, rev := range revs {
+		fmt.Printf("%s %s %s\n", rev.Short(), rev.Time.Format("2006-01-02 15:04"), rev.Subject())
+	}
+}
+
+func showHistory(cmd *cobra.Command, args []string) {
+	_, data := readHistory(args[0], args[1])
+	os.Stdout.Write(data)
+}
+
+func restoreHistory(cmd *cobra.Command, args []string) {
+	name, data := readHistory(args[0], args[1])
+	loadIntoFC(config.ParseBytes(data), name)
+}
+
+// readHistory returns the name and contents of a craft's file at a revision.
+func readHistory(craft, rev string) (string, []byte) {
+	repo, err := history.Open(historyRepo)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resolved, err := repo.Resolve(rev)
+	if err != nil {
+		log.Fatal(err)
+	}
+	name := path.Join(craft, historyFile)
+	if historyFile == "" {
+		name, err = newestDiff(repo, resolved.Hash, craft)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	data, err := repo.ReadFile(resolved.Hash, name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return fmt.Sprintf("%s at %s", name, resolved.Short()), data
+}
+
+// newestDiff returns the `diff all` of a craft for the newest firmware
+// version at a revision.
+func newestDiff(repo *history.Repo, rev, craft string) (string, error) {
+	files, err := repo.Files(rev, craft)
+	if err != nil {
+		return "", err
+	}
+	newest := ""
+	var newestVersion config.Version
+	for _, f := range files {
+		if !strings.HasSuffix(f, "_DIFF.txt") {
+			continue
+		}
+		data, err := repo.ReadFile(rev, f)
+		if err != nil {
+			return "", err
+		}
+		v := config.ParseBytes(data).Header.Version
+		if newest == "" || v.Compare(newestVersion) >= 0 {
+			newest, newestVersion = f, v
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("no diff of %q at %s", craft, rev[:7])
+	}
+	return newest, nil
+}
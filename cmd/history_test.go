@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/robhaswell/btflcli/history"
+)
+
+// resetHistoryFlags puts back the flags which would otherwise carry over
+// into later tests.
+func resetHistoryFlags(t *testing.T) {
+	t.Cleanup(func() {
+		dumpGit = false
+		dumpSelected = []string{"diff-all", "dump-all"}
+		historyRepo = "."
+		historyFile = ""
+	})
+}
+
+func TestDumpGit(t *testing.T) {
+	sim := useSim(t)
+	resetHistoryFlags(t)
+	dump := []string{"dump", "--git", "--output-dir", "quads", "--sections", "diff-all"}
+	if err := sim.Set("pilot_name", "Bench Pilot"); err != nil {
+		t.Fatal(err)
+	}
+
+	out := run(t, dump...)
+	if !strings.Contains(out, "Committed ") || !strings.Contains(out, "Bench Quad: BTFL 4.5.0 on ") {
+		t.Errorf("first dump printed %q", out)
+	}
+
+	// Nothing is committed when nothing has changed
+	out = run(t, dump...)
+	if !strings.Contains(out, "No changes since ") || !strings.Contains(out, "Unchanged files: ") {
+		t.Errorf("unchanged dump printed %q", out)
+	}
+
+	if err := sim.Set("p_pitch", "55"); err != nil {
+		t.Fatal(err)
+	}
+	out = run(t, dump...)
+	if !strings.Contains(out, "Committed ") {
+		t.Errorf("changed dump printed %q", out)
+	}
+
+	// Text only changed in case is still a change
+	if err := sim.Set("pilot_name", "bench pilot"); err != nil {
+		t.Fatal(err)
+	}
+	out = run(t, dump...)
+	if !strings.Contains(out, "Committed ") {
+		t.Errorf("renamed dump printed %q", out)
+	}
+
+	repo, err := history.Open("quads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revs, err := repo.Log("Bench Quad")
+	if err != nil || len(revs) != 3 {
+		t.Fatalf("got revisions %v, %v", revs, err)
+	}
+	out = run(t, "history", "--repo", "quads", "Bench Quad")
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[0], revs[0].Short()+" ") {
+		t.Errorf("history printed %q", out)
+	}
+	if out := run(t, "history", "--repo", "quads", "Other Quad"); out != "No history\n" {
+		t.Errorf("history of another craft printed %q", out)
+	}
+}
+
+func TestHistoryShowAndRestore(t *testing.T) {
+	sim := useSim(t)
+	resetHistoryFlags(t)
+	dump := []string{"dump", "--git", "--output-dir", "quads", "--sections", "diff-all"}
+	run(t, dump...)
+	if err := sim.Set("p_pitch", "55"); err != nil {
+		t.Fatal(err)
+	}
+	run(t, dump...)
+
+	for _, tt := range []struct {
+		rev  string
+		args []string
+		want bool
+	}{
+		{"HEAD", nil, true},
+		{"HEAD~1", nil, false},
+		{"HEAD", []string{"--file", "BTFL_4.5.0_DIFF.txt"}, true},
+	} {
+		args := append([]string{"history", "show", "Bench Quad", tt.rev, "--repo", "quads"}, tt.args...)
+		out := run(t, args...)
+		if !strings.HasPrefix(out, "# diff all\r\n") {
+			t.Errorf("%s: printed %q", strings.Join(args, " "), out[:min(len(out), 40)])
+		}
+		if got := strings.Contains(out, "set p_pitch = 55\r\n"); got != tt.want {
+			t.Errorf("%s: has p_pitch %v, want %v", strings.Join(args, " "), got, tt.want)
+		}
+		historyFile = ""
+	}
+
+	// Restoring the first revision undoes the change, keeping a snapshot
+	// out of the history's work tree
+	out := run(t, "history", "restore", "Bench Quad", "HEAD~1", "--repo", "quads", "--output-dir", "snapshots")
+	if !strings.Contains(out, "Configuration loaded") {
+		t.Errorf("restore printed %q", out)
+	}
+	checkSetting(t, sim, "p_pitch", "47")
+}
+
+func TestNewestDiff(t *testing.T) {
+	useSim(t)
+	repo, err := history.OpenOrInit(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := func(version string) string {
+		return "# diff all\r\n\r\n# version\r\n# Betaflight / STM32F7X2 (S7X2) " + version + " Dec 14 2022 / 12:07:05 (f0b7de5a2) MSP API: 1.44\r\n"
+	}
+	files := map[string]string{
+		"Quad/BTFL_4.4.2_DIFF.txt":  diff("4.4.2"),
+		"Quad/BTFL_4.10.0_DIFF.txt": diff("4.10.0"),
+		"Quad/BTFL_4.5.0_DIFF.txt":  diff("4.5.0"),
+		"Quad/BTFL_4.11.0_DUMP.txt": diff("4.11.0"),
+		"Dumps/BTFL_4.5.0_DUMP.txt": diff("4.5.0"),
+	}
+	var names []string
+	for name, content := range files {
+		if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, name, content)
+		names = append(names, name)
+	}
+	rev, err := repo.Commit(names, "Quads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Versions are compared by number, not by name, and dumps are skipped
+	if got, err := newestDiff(repo, rev.Hash, "Quad"); err != nil || got != "Quad/BTFL_4.10.0_DIFF.txt" {
+		t.Errorf("newest diff is %q, %v", got, err)
+	}
+	for _, craft := range []string{"Dumps", "Missing"} {
+		if got, err := newestDiff(repo, rev.Hash, craft); err == nil || !strings.Contains(err.Error(), "no diff of") {
+			t.Errorf("%s: got %q, %v", craft, got, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robhaswell/btflcli/config"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	importFormat string
+	importOutput string
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Convert a JSON or YAML document to CLI commands",
+	Long: `Convert a document written by the export command back into Betaflight CLI
+commands, which can be loaded with the load command.
+
+The format is taken from the file extension unless --format is given.`,
+	Args: cobra.ExactArgs(1),
+	Run:  importConfig,
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().StringVarP(&importFormat, "format", "f", "", "input format: json or yaml")
+	importCmd.Flags().StringVarP(&importOutput, "output", "o", "", "file to write the commands to (default is stdout)")
+}
+
+func importConfig(cmd *cobra.Command, args []string) {
+	format := importFormat
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(args[0])) {
+		case ".yaml", ".yml":
+			format = "yaml"
+		default:
+			format = "json"
+		}
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	var doc config.Document
+	switch format {
+	case "json":
+		err = json.Unmarshal(data, &doc)
+	case "yaml":
+		err = yaml.Unmarshal(data, &doc)
+	default:
+		log.Fatalf("Unknown format %q", format)
+	}
+	if err != nil {
+		log.Fatalf("Reading %s: %s", args[0], err)
+	}
+
+	cfg, err := doc.Config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if importOutput == "" {
+		fmt.Print(cfg)
+		return
+	}
+	if err := os.WriteFile(importOutput, []byte(cfg.String()), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
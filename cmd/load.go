@@ -16,128 +16,332 @@ limitations under the License.
 package cmd
 
 import (
-	"bufio"
-	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/robhaswell/btflcli/cli"
+	"github.com/robhaswell/btflcli/config"
 	"github.com/robhaswell/btflcli/fc"
 	"github.com/spf13/cobra"
-	"go.bug.st/serial"
+)
+
+const verifyTimeout = 30 * time.Second
+
+var (
+	continueOnError bool
+	verifyLoad      bool
+	forceLoad       bool
 )
 
 // loadCmd represents the load command
 var loadCmd = &cobra.Command{
 	Use:   "load",
 	Short: "Load the configuration in the specified file to the connected flight controller",
-	Args:  cobra.ExactArgs(1),
-	Run:   loadFile,
+	Long: `Load the configuration in the specified file to the connected flight controller.
+
+Each line is checked for errors reported by the flight controller. If any line
+fails the configuration is not saved, unless --continue-on-error is given.
+
+Files which would reboot or reset the flight controller partway through, with
+bl, dfu, msc or a defaults that saves, are refused. defaults nosave and
+defaults show are allowed.
+
+The file's firmware and board must match the flight controller, unless
+--force is given.
+
+A snapshot of the current configuration is saved before anything is sent,
+which the rollback command can restore.
+
+With --verify the configuration is read back once the flight controller has
+rebooted, and any setting that didn't stick is reported.`,
+	Args: cobra.ExactArgs(1),
+	Run:  loadFile,
 }
 
 func init() {
 	rootCmd.AddCommand(loadCmd)
 
-	// Here you will define your flags and configuration settings.
+	addLoadFlags(loadCmd)
+}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// loadCmd.PersistentFlags().String("foo", "", "A help for foo")
+// addLoadFlags adds the flags which control loading a configuration to a
+// command.
+func addLoadFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&continueOnError, "continue-on-error", false, "save the configuration even if some lines failed")
+	cmd.Flags().BoolVar(&forceLoad, "force", false, "load even if the file is for a different board or firmware")
+	cmd.Flags().BoolVar(&verifyLoad, "verify", false, "read the configuration back after saving and check it was applied")
+	addOutputDirFlag(cmd, "directory of the dumps to keep the snapshot with")
+}
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// loadCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+// loadError is a line of a configuration the FC rejected.
+type loadError struct {
+	Line *config.Line
+	Err  error
 }
 
 func loadFile(cmd *cobra.Command, args []string) {
 	inputFile := args[0]
-	fileContents, err := os.ReadFile(inputFile)
+	cfg, err := readConfigFile(inputFile)
 	if err != nil {
 		log.Fatal(err)
 	}
+	loadIntoFC(cfg, inputFile)
+}
+
+// loadIntoFC loads a configuration into the connected FC as the load command
+// does, checking its identity, taking a snapshot and verifying the result
+// if asked. name is used to refer to the configuration in messages.
+func loadIntoFC(cfg *config.Config, name string) {
+	if err := checkLoadable(cfg); err != nil {
+		log.Fatalf("Not loading %s: %s", name, err)
+	}
 
-	ports, err := serial.GetPortsList()
+	// Connect to the selected or auto-detected flight controller
+	fc, err := connectFC()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	portName := ports[len(ports)-1]
+	checkIdentity(fc, cfg)
 
-	// Create the fc options to connect to the flight controller
-	fcOpts := fc.FCOptions{
-		PortName: portName,
-		BaudRate: baudRate,
+	// Activate the CLI mode
+	session, err := cli.Enter(fc.Port)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Initialise the flight controller connection
-	fc, err := fc.NewFC(fcOpts)
+	// Keep a copy of the current configuration to roll back to
+	snapshot, err := takeSnapshot(fc, session)
 	if err != nil {
 		log.Fatal(err)
 	}
-	p := fc.Port
+	fmt.Printf("Saved snapshot: %s\n", snapshot)
 
-	// Create a reader utility to read from the flight controller
-	scanner := bufio.NewScanner(bufio.NewReader(p))
-	scanner.Split(bufio.ScanLines)
+	loadConfig(session, cfg, name)
 
-	fileScanner := bufio.NewScanner(bytes.NewReader(fileContents))
-	fileScanner.Split(bufio.ScanLines)
+	if verifyLoad {
+		fmt.Println("Waiting for the flight controller to reboot")
+		if err := fc.Reconnect(verifyTimeout); err != nil {
+			log.Fatal(err)
+		}
+		changes, err := verifyConfig(fc, cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(changes) > 0 {
+			fmt.Fprintf(os.Stderr, "\n%d settings did not stick:\n", len(changes))
+			writeHumanDiff(os.Stderr, changes)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration verified")
+	}
+}
 
-	// Activate the CLI mode
-	p.Write([]byte("#\r\n"))
+// checkIdentity exits, explaining why, if a configuration was taken from a
+// different board or firmware than the FC, unless --force was given.
+func checkIdentity(board *fc.FC, cfg *config.Config) {
+	id, err := readIdentity(board)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mismatches := identityMismatches(board, id, cfg.Header)
+	if len(mismatches) == 0 {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "The configuration is for a different flight controller:")
+	for _, m := range mismatches {
+		fmt.Fprintf(os.Stderr, "  %s\n", m)
+	}
+	if !forceLoad {
+		log.Fatal("Not loading the configuration, use --force to load it anyway")
+	}
+	fmt.Fprintln(os.Stderr, "Loading anyway because of --force")
+}
 
-	for scanner.Scan() {
-		// Read a line from the flight controller
-		line := scanner.Text()
-		if strings.Contains(line, "Entering CLI Mode") {
-			// Read one more blank link from the FC.
-			scanner.Scan()
-			break
+// identityMismatches compares the identity recorded in a configuration with
+// the FC. Anything the configuration doesn't record is not compared.
+func identityMismatches(board *fc.FC, id *fc.Identity, h config.Header) []string {
+	var out []string
+	check := func(what, file, fc string) {
+		if file != "" && fc != "" && !strings.EqualFold(file, fc) {
+			out = append(out, fmt.Sprintf("%s is %s in the file but %s on the flight controller", what, file, fc))
 		}
 	}
+	check("firmware variant", h.Variant(), board.Variant)
+	if !h.Version.IsZero() && (h.Version.Major != int(board.VersionMajor) || h.Version.Minor != int(board.VersionMinor)) {
+		check("firmware version", fmt.Sprintf("%d.%d", h.Version.Major, h.Version.Minor),
+			fmt.Sprintf("%d.%d", board.VersionMajor, board.VersionMinor))
+	}
+	check("target", h.Target, id.TargetName)
+	check("board_name", h.BoardName, id.BoardName)
+	check("manufacturer_id", h.ManufacturerID, id.ManufacturerID)
+	check("mcu_id", h.MCUID, id.MCUID)
+	return out
+}
 
-	p.SetReadTimeout(100 * time.Millisecond)
+// interruptingCommands reboot or reset the FC, which would cut a load short
+// and leave the rest of the configuration to fail against a dead port.
+var interruptingCommands = map[string]bool{"bl": true, "defaults": true, "dfu": true, "msc": true, "reboot": true}
 
-	// Send the file contents to the flight controller
-	for fileScanner.Scan() {
-		line := fileScanner.Text()
-		if line == "" {
+// interrupts reports whether a CLI command reboots or resets the FC.
+// `defaults` only does when it saves: `defaults nosave` resets the settings
+// and carries on, and `defaults show` just prints them.
+func interrupts(name string, args []string) bool {
+	name = strings.ToLower(name)
+	if name == "defaults" {
+		for _, a := range args {
+			if strings.EqualFold(a, "nosave") || strings.EqualFold(a, "show") {
+				return false
+			}
+		}
+	}
+	return interruptingCommands[name]
+}
+
+// checkLoadable returns an error listing the lines of a configuration which
+// would reboot or reset the FC partway through loading it. `defaults nosave`
+// is allowed, as `diff all` starts with it.
+func checkLoadable(cfg *config.Config) error {
+	var lines []string
+	for _, l := range cfg.Lines {
+		if l.Command == nil {
 			continue
 		}
-		p.Write([]byte(line + "\r\n"))
-		fmt.Println(line)
+		if interrupts(l.Command.Name, l.Command.Args) {
+			lines = append(lines, fmt.Sprintf("line %d: %s", l.Number, strings.TrimSpace(l.Text)))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return fmt.Errorf("it would reboot or reset the flight controller partway through (%s)", strings.Join(lines, ", "))
+}
 
-		// Read the response from the flight controller
-		for {
-			buf := make([]byte, 1024)
-			n, err := p.Read(buf)
-			if err != nil {
-				break
-			}
-			if n == 0 {
-				break
-			}
+// loadConfig sends a configuration to the FC and saves it, or reports the
+// lines that failed and exits without saving unless --continue-on-error was
+// given.
+func loadConfig(session *cli.Session, cfg *config.Config, name string) {
+	// Send the file contents to the flight controller
+	failed, err := applyConfig(session, cfg, func(l *config.Line, err error) {
+		fmt.Println(strings.TrimSpace(l.Text))
+		var cmdErr *cli.CommandError
+		if errors.As(err, &cmdErr) {
+			fmt.Println(cmdErr.Message)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(failed) > 0 {
+		writeLoadReport(os.Stderr, name, failed)
+		if !continueOnError {
+			session.Exit()
+			log.Fatalf("Configuration not saved because %d lines failed, use --continue-on-error to save anyway", len(failed))
 		}
 	}
 
-	// Send save command just in case
-	p.Write([]byte("save\r\n"))
+	// The flight controller reboots when saving so no need to close the connection
+	if err := session.Save(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("\n\nConfiguration loaded")
+}
 
-	// Read the response from the flight controller
-	for {
-		buf := make([]byte, 1024)
-		n, err := p.Read(buf)
+// applyConfig sends each command of a configuration to the FC, calling
+// progress after each one with the error the FC reported, if any, and
+// returns the ones that failed. Saving is left to the caller.
+func applyConfig(session *cli.Session, cfg *config.Config, progress func(l *config.Line, err error)) ([]loadError, error) {
+	var failed []loadError
+	for _, l := range cfg.Lines {
+		if l.Command == nil {
+			continue
+		}
+		switch l.Command.Name {
+		case "save", "exit":
+			continue
+		}
+		_, err := session.Exec(strings.TrimSpace(l.Text))
+		var cmdErr *cli.CommandError
+		if err != nil && !errors.As(err, &cmdErr) {
+			return failed, err
+		}
+		progress(l, err)
 		if err != nil {
-			break
+			failed = append(failed, loadError{Line: l, Err: err})
 		}
-		if n == 0 {
-			break
+	}
+	return failed, nil
+}
+
+// writeLoadReport lists the lines of a file which failed to load.
+func writeLoadReport(w io.Writer, name string, failed []loadError) {
+	fmt.Fprintf(w, "\n%d lines of %s failed:\n", len(failed), name)
+	for _, f := range failed {
+		msg := f.Err.Error()
+		var cmdErr *cli.CommandError
+		if errors.As(f.Err, &cmdErr) {
+			msg = cmdErr.Message
 		}
+		fmt.Fprintf(w, "  line %d: %s\n    %s\n", f.Line.Number, strings.TrimSpace(f.Line.Text), msg)
 	}
+}
 
-	// The flight controller should reboot so no need to close the connection
-	fmt.Println("\n\nConfiguration loaded")
+// verifyConfig reads the configuration back from the FC and returns the
+// settings in cfg it doesn't have. Old is the value in cfg and New the one
+// on the board.
+func verifyConfig(board *fc.FC, cfg *config.Config) ([]config.Change, error) {
+	session, err := cli.Enter(board.Port)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Exit()
+	diffAll, err := readFcDump(session, "diff all")
+	if err != nil {
+		return nil, err
+	}
 
+	var changes []config.Change
+	missing := false
+	for _, ch := range config.Compare(cfg, config.ParseString(diffAll)) {
+		if ch.Kind != config.Added {
+			changes = append(changes, ch)
+			missing = missing || ch.Kind == config.Removed
+		}
+	}
+	if !missing {
+		return changes, nil
+	}
+
+	// Anything the file sets to its default value isn't in the diff, so
+	// check those against the full dump.
+	dumpAll, err := readFcDump(session, "dump all")
+	if err != nil {
+		return nil, err
+	}
+	type key struct {
+		section config.Section
+		key     string
+	}
+	wrong := make(map[key]config.Change)
+	for _, ch := range config.Compare(cfg, config.ParseString(dumpAll)) {
+		wrong[key{ch.Section, ch.Key}] = ch
+	}
+	var verified []config.Change
+	for _, ch := range changes {
+		if ch.Kind != config.Removed {
+			verified = append(verified, ch)
+			continue
+		}
+		// The dump has every setting, so if it agrees with the file the
+		// setting is at its default, and otherwise it has the real value
+		if dumped, ok := wrong[key{ch.Section, ch.Key}]; ok && dumped.Kind != config.Added {
+			verified = append(verified, dumped)
+		}
+	}
+	return verified, nil
 }
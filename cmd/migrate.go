@@ -0,0 +1,95 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/robhaswell/btflcli/config"
+	"github.com/robhaswell/btflcli/migrate"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateTo     string
+	migrateFrom   string
+	migrateOutput string
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate --to <version> <file>",
+	Short: "Upgrade a configuration file for a newer Betaflight release",
+	Long: `Upgrade a diff or dump for a newer Betaflight release, renaming, rescaling,
+moving and removing settings that changed in the releases in between.
+
+Anything that needs checking by hand is listed on stderr, including a
+warning if --to is newer than the releases there are rules for. The migrated
+configuration is written to stdout unless --output is given.`,
+	Args: cobra.ExactArgs(1),
+	Run:  migrateFile,
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().StringVar(&migrateTo, "to", "", "firmware version to migrate to, e.g. 4.5.0")
+	migrateCmd.Flags().StringVar(&migrateFrom, "from", "", "firmware version of the file (default is the version in the file)")
+	migrateCmd.Flags().StringVarP(&migrateOutput, "output", "o", "", "file to write the migrated configuration to")
+	migrateCmd.MarkFlagRequired("to")
+}
+
+func migrateFile(cmd *cobra.Command, args []string) {
+	to, err := config.ParseVersion(migrateTo)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var from config.Version
+	if migrateFrom != "" {
+		if from, err = config.ParseVersion(migrateFrom); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if !migrate.Covers(to) {
+		newest := migrate.Newest()
+		fmt.Fprintf(os.Stderr, "Warning: there are no rules for releases after %d.%d, check any settings changed by %d.%d by hand\n",
+			newest.Major, newest.Minor, to.Major, to.Minor)
+	}
+
+	cfg, err := readConfigFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	notes, err := migrate.Migrate(cfg, from, to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, n := range notes {
+		fmt.Fprintln(os.Stderr, n)
+	}
+
+	if migrateOutput == "" {
+		fmt.Print(cfg)
+		return
+	}
+	if err := os.WriteFile(migrateOutput, []byte(cfg.String()), 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Written file: %s\n", migrateOutput)
+}
@@ -0,0 +1,281 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/robhaswell/btflcli/msp"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const (
+	enterAltScreen = "\x1b[?1049h\x1b[?25l"
+	leaveAltScreen = "\x1b[?25h\x1b[?1049l"
+	cursorHome     = "\x1b[H"
+	clearToEOL     = "\x1b[K"
+	clearToEOS     = "\x1b[J"
+
+	defaultWidth = 80
+	barWidth     = 20
+	// pwmMin and pwmMax are the range of RC channels and motor outputs
+	pwmMin = 1000
+	pwmMax = 2000
+)
+
+var (
+	monitorInterval time.Duration
+	monitorCount    int
+)
+
+// monitorMessages are polled for the dashboard
+var monitorMessages = []uint16{
+	msp.MspStatusEx,
+	msp.MspAttitude,
+	msp.MspRawIMU,
+	msp.MspRC,
+	msp.MspMotor,
+	msp.MspBatteryState,
+	msp.MspAnalog,
+}
+
+var rcChannelNames = []string{"Roll", "Pitch", "Yaw", "Throttle"}
+
+// monitorCmd represents the monitor command
+var monitorCmd = &cobra.Command{
+	Use:   "monitor",
+	Short: "Show a live dashboard of the flight controller's sensors and outputs",
+	Long: `Show a full screen dashboard of the attitude, raw IMU readings, RC channels,
+motor outputs, battery and arming flags, refreshed continuously. Readings
+the firmware doesn't support are marked as such.
+
+Press q or Ctrl-C to quit.`,
+	Args: cobra.NoArgs,
+	Run:  monitor,
+}
+
+func init() {
+	rootCmd.AddCommand(monitorCmd)
+
+	monitorCmd.Flags().DurationVar(&monitorInterval, "interval", 100*time.Millisecond, "time between refreshes")
+	monitorCmd.Flags().IntVar(&monitorCount, "count", 0, "stop after this many refreshes, 0 to run until quit")
+}
+
+func monitor(cmd *cobra.Command, args []string) {
+	// Connect to the selected or auto-detected flight controller
+	fc, err := connectFC()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := runMonitor(fc, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runMonitor polls the FC and redraws the dashboard until the user quits.
+// The terminal is restored before returning.
+func runMonitor(board *fc.FC, out *os.File) error {
+	quit := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	// Read keys as they are pressed, if there is someone to press them
+	stdin := int(os.Stdin.Fd())
+	if term.IsTerminal(stdin) {
+		old, err := term.MakeRaw(stdin)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(stdin, old)
+		go readQuitKey(os.Stdin, quit)
+	}
+	stdout := int(out.Fd())
+	if term.IsTerminal(stdout) {
+		fmt.Fprint(out, enterAltScreen)
+		defer fmt.Fprint(out, leaveAltScreen)
+	}
+
+	poller := board.NewPoller(monitorMessages...)
+	ticker := time.NewTicker(monitorInterval)
+	defer ticker.Stop()
+	for frame := 1; ; frame++ {
+		t, err := poller.Poll()
+		if err != nil {
+			return err
+		}
+		width, _, err := term.GetSize(stdout)
+		if err != nil || width <= 0 {
+			width = defaultWidth
+		}
+		io.WriteString(out, cursorHome+renderDashboard(board, poller, t, width)+clearToEOS)
+		if monitorCount > 0 && frame >= monitorCount {
+			return nil
+		}
+		select {
+		case <-quit:
+			return nil
+		case <-interrupt:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// readQuitKey closes quit when q, Esc or Ctrl-C is pressed.
+func readQuitKey(r io.Reader, quit chan struct{}) {
+	b := make([]byte, 1)
+	for {
+		if _, err := r.Read(b); err != nil {
+			return
+		}
+		switch b[0] {
+		case 'q', 'Q', 0x1b, 0x03:
+			close(quit)
+			return
+		}
+	}
+}
+
+// renderDashboard returns the dashboard as lines of at most width columns,
+// ending in \r\n as the terminal may be in raw mode.
+func renderDashboard(board *fc.FC, poller *fc.Poller, t *fc.Telemetry, width int) string {
+	var lines []string
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+	unsupported := func(label string) {
+		add("%-10s not supported by %s", label, board.Variant)
+	}
+
+	add("%s %d.%d.%d %s  %s", board.Variant, board.VersionMajor, board.VersionMinor, board.VersionPatch,
+		board.Name, t.Time.Format("15:04:05"))
+	add("")
+	switch {
+	case t.Attitude != nil:
+		add("%-10s roll %6.1f°   pitch %6.1f°   heading %4.0f°", "Attitude", t.Attitude.Roll, t.Attitude.Pitch, t.Attitude.Heading)
+	case poller.Unsupported(msp.MspAttitude):
+		unsupported("Attitude")
+	}
+	switch {
+	case t.RawIMU != nil:
+		g, a := t.RawIMU.Gyro, t.RawIMU.Acc
+		add("%-10s x %5.0f °/s   y %5.0f °/s   z %5.0f °/s", "Gyro", g[0], g[1], g[2])
+		add("%-10s x %5.2f g     y %5.2f g     z %5.2f g", "Acc", a[0], a[1], a[2])
+		if m := t.RawIMU.Mag; m != [3]float64{} {
+			add("%-10s x %5.0f       y %5.0f       z %5.0f", "Mag", m[0], m[1], m[2])
+		}
+	case poller.Unsupported(msp.MspRawIMU):
+		unsupported("IMU")
+	}
+	switch {
+	case t.Battery != nil:
+		b := t.Battery
+		add("%-10s %.2f V   %d cells   %.2f A   %d mAh used   %s", "Battery", b.Voltage, b.Cells, b.Current, b.Drawn, b.State)
+	case t.Analog != nil:
+		a := t.Analog
+		add("%-10s %.2f V   %.2f A   %d mAh used", "Battery", a.Voltage, a.Current, a.Drawn)
+	case poller.Unsupported(msp.MspBatteryState) && poller.Unsupported(msp.MspAnalog):
+		unsupported("Battery")
+	}
+	switch {
+	case t.Status != nil:
+		add("%-10s %s", "Arming", namesOrNone(t.Status.ArmingDisabled))
+		add("%-10s %s", "Modes", namesOrNone(t.Status.FlightModes))
+	case poller.Unsupported(msp.MspStatusEx):
+		unsupported("Status")
+	}
+	add("")
+
+	// RC channels and motors side by side if there is room
+	var rc, motors []string
+	switch {
+	case t.RC != nil:
+		rc = append(rc, "RC channels")
+		for ii, v := range t.RC {
+			name := fmt.Sprintf("AUX %d", ii-len(rcChannelNames)+1)
+			if ii < len(rcChannelNames) {
+				name = rcChannelNames[ii]
+			}
+			rc = append(rc, fmt.Sprintf("%-8s %4d %s", name, v, bar(v)))
+		}
+	case poller.Unsupported(msp.MspRC):
+		rc = append(rc, "RC channels not supported by "+board.Variant)
+	}
+	switch {
+	case t.Motors != nil:
+		motors = append(motors, "Motors")
+		// Trailing zeros are motors the craft doesn't have
+		n := len(t.Motors)
+		for n > 0 && t.Motors[n-1] == 0 {
+			n--
+		}
+		for ii, v := range t.Motors[:n] {
+			motors = append(motors, fmt.Sprintf("%-8s %4d %s", fmt.Sprintf("Motor %d", ii+1), v, bar(v)))
+		}
+	case poller.Unsupported(msp.MspMotor):
+		motors = append(motors, "Motors not supported by "+board.Variant)
+	}
+	column := 0
+	for _, l := range rc {
+		column = max(column, utf8.RuneCountInString(l))
+	}
+	if column > 0 && 2*column+4 <= width {
+		for ii := 0; ii < max(len(rc), len(motors)); ii++ {
+			var left, right string
+			if ii < len(rc) {
+				left = rc[ii]
+			}
+			if ii < len(motors) {
+				right = motors[ii]
+			}
+			add("%-*s    %s", column, left, right)
+		}
+	} else {
+		lines = append(lines, rc...)
+		if len(rc) > 0 && len(motors) > 0 {
+			add("")
+		}
+		lines = append(lines, motors...)
+	}
+	add("")
+	add("q to quit")
+
+	var b strings.Builder
+	for _, l := range lines {
+		if utf8.RuneCountInString(l) > width {
+			l = string([]rune(l)[:width])
+		}
+		b.WriteString(l + clearToEOL + "\r\n")
+	}
+	return b.String()
+}
+
+// bar draws a value from 1000 to 2000 as a horizontal bar.
+func bar(v uint16) string {
+	n := (int(v) - pwmMin) * barWidth / (pwmMax - pwmMin)
+	n = min(max(n, 0), barWidth)
+	return "[" + strings.Repeat("#", n) + strings.Repeat(" ", barWidth-n) + "]"
+}
@@ -0,0 +1,233 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/robhaswell/btflcli/msp"
+	"github.com/robhaswell/btflcli/record"
+	"github.com/spf13/cobra"
+)
+
+// recordRequestTimeout is how long to wait for each reply before counting
+// it as dropped
+const recordRequestTimeout = 200 * time.Millisecond
+
+var (
+	recordRate     string
+	recordMessages string
+	recordOutput   string
+	recordFormat   string
+	recordDuration time.Duration
+)
+
+// recordCmd represents the record command
+var recordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Record telemetry from the flight controller to a file",
+	Long: `Poll MSP messages from the flight controller at a fixed rate and write each
+sample, with its time, to a CSV or columnar binary file. The file starts
+with a header giving the board, firmware and the unit of each column.
+
+Messages are any of ` + strings.Join(messageNames(), ", ") + `.
+
+A reply that is lost or corrupted leaves that sample's values empty rather
+than stopping the recording. Recording stops on Ctrl-C or after --duration.
+
+The format is CSV if the output file name ends in .csv and columnar
+otherwise, unless --format says.`,
+	Args: cobra.NoArgs,
+	Run:  recordTelemetry,
+}
+
+func init() {
+	rootCmd.AddCommand(recordCmd)
+
+	recordCmd.Flags().StringVar(&recordRate, "rate", "50Hz", "samples a second, e.g. 50Hz, or the time between them, e.g. 20ms")
+	recordCmd.Flags().StringVar(&recordMessages, "messages", "attitude,raw_imu,rc,motor,analog", "comma separated messages to record")
+	recordCmd.Flags().StringVarP(&recordOutput, "output", "o", "", "file to record to")
+	recordCmd.Flags().StringVar(&recordFormat, "format", "", "file format: csv or columnar")
+	recordCmd.Flags().DurationVar(&recordDuration, "duration", 0, "stop after this long, 0 to record until interrupted")
+	recordCmd.MarkFlagRequired("output")
+}
+
+func messageNames() []string {
+	var names []string
+	for name := range fc.Messages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseMessages parses a comma separated list of the names in fc.Messages.
+func parseMessages(s string) ([]uint16, error) {
+	var codes []uint16
+	for _, name := range strings.Split(s, ",") {
+		code, ok := fc.Messages[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown message %q, expected one of %s", name, strings.Join(messageNames(), ", "))
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// parseRate parses a rate in Hz, or the interval between samples.
+func parseRate(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, nil
+	}
+	hz, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(s), "hz"), 64)
+	if err != nil || hz <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return time.Duration(float64(time.Second) / hz), nil
+}
+
+func recordTelemetry(cmd *cobra.Command, args []string) {
+	interval, err := parseRate(recordRate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	codes, err := parseMessages(recordMessages)
+	if err != nil {
+		log.Fatal(err)
+	}
+	format := recordFormat
+	if format == "" {
+		format = "columnar"
+		if strings.EqualFold(filepath.Ext(recordOutput), ".csv") {
+			format = "csv"
+		}
+	}
+	if format != "csv" && format != "columnar" {
+		log.Fatalf("Unknown format %q", format)
+	}
+
+	// Connect to the selected or auto-detected flight controller
+	fc, err := connectFC()
+	if err != nil {
+		log.Fatal(err)
+	}
+	id, err := readIdentity(fc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fc.SetRequestTimeout(recordRequestTimeout)
+	poller := fc.NewPoller(codes...)
+
+	// The first sample gives the number of RC channels and motors
+	first, err := firstSample(poller, codes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	header := record.NewHeader(fc, id, float64(time.Second)/float64(interval), codes, first)
+
+	f, err := os.Create(recordOutput)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	var w record.Writer
+	if format == "csv" {
+		w, err = record.NewCSVWriter(f, header)
+	} else {
+		w, err = record.NewColumnarWriter(f, header)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var stop <-chan time.Time
+	if recordDuration > 0 {
+		stop = time.After(recordDuration)
+	}
+	fmt.Fprintf(os.Stderr, "Recording to %s, press Ctrl-C to stop\n", recordOutput)
+
+	samples, dropped := 0, 0
+	t := first
+	for {
+		if err := w.Write(header.Row(t)); err != nil {
+			log.Fatal(err)
+		}
+		samples++
+		dropped += t.Dropped
+
+		select {
+		case <-interrupt:
+		case <-stop:
+		case <-ticker.C:
+			t, err = poller.Poll()
+			if err != nil {
+				w.Close()
+				log.Fatal(err)
+			}
+			continue
+		}
+		break
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Recorded %d samples in %s, %d replies dropped\n",
+		samples, t.Time.Sub(header.Started).Round(time.Millisecond), dropped)
+}
+
+// firstSample polls until the sizes of the RC channels and motors are known,
+// if they are being recorded and the firmware supports them. It fails if
+// they still aren't after a few tries, rather than recording no columns
+// for them.
+func firstSample(poller *fc.Poller, codes []uint16) (*fc.Telemetry, error) {
+	const attempts = 10
+	for ii := 1; ; ii++ {
+		t, err := poller.Poll()
+		if err != nil {
+			return nil, err
+		}
+		var missing []string
+		for _, code := range codes {
+			switch {
+			case poller.Unsupported(code):
+			case code == msp.MspRC && t.RC == nil:
+				missing = append(missing, "rc")
+			case code == msp.MspMotor && t.Motors == nil:
+				missing = append(missing, "motor")
+			}
+		}
+		if len(missing) == 0 {
+			return t, nil
+		}
+		if ii == attempts {
+			return nil, fmt.Errorf("no %s reply in %d tries, so its number of columns isn't known", strings.Join(missing, " or "), attempts)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robhaswell/btflcli/msp"
+)
+
+func TestFirstSample(t *testing.T) {
+	codes := []uint16{msp.MspAttitude, msp.MspRC, msp.MspMotor}
+	tests := []struct {
+		name        string
+		unsupported map[uint16]bool
+		silent      map[uint16]bool
+		rc, motors  int
+		err         string
+	}{
+		{"all answered", nil, nil, 16, 8, ""},
+		{"no motors in the firmware", map[uint16]bool{msp.MspMotor: true}, nil, 16, 0, ""},
+		// A reply that never comes leaves nothing to size the columns by
+		{"rc lost", nil, map[uint16]bool{msp.MspRC: true}, 0, 0, "no rc reply in 10 tries"},
+		{"both lost", nil, map[uint16]bool{msp.MspRC: true, msp.MspMotor: true}, 0, 0, "no rc or motor reply"},
+	}
+	for _, tt := range tests {
+		sim := useSim(t)
+		sim.Unsupported = tt.unsupported
+		sim.Silent = tt.silent
+		board, err := connectFC()
+		if err != nil {
+			t.Fatal(err)
+		}
+		board.SetRequestTimeout(10 * time.Millisecond)
+		first, err := firstSample(board.NewPoller(codes...), codes)
+		board.Close()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %+v, %v, want error %q", tt.name, first, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(first.RC) != tt.rc || len(first.Motors) != tt.motors {
+			t.Errorf("%s: got %d RC channels and %d motors, want %d and %d", tt.name, len(first.RC), len(first.Motors), tt.rc, tt.motors)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/robhaswell/btflcli/cli"
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/spf13/cobra"
+)
+
+const snapshotTimeFormat = "20060102-150405"
+
+// My Quad/BTFL_4.5.0_20231209-142501_DIFF.txt
+var snapshotRe = regexp.MustCompile(`_(\d{8}-\d{6})_DIFF\.txt$`)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [snapshot]",
+	Short: "Restore the configuration saved before the last load",
+	Long: `Restore a snapshot of the configuration taken by the load command.
+
+Without an argument the most recent snapshot in the craft's directory is
+restored. A snapshot can be chosen by its file name or its timestamp, e.g.
+20231209-142501. The flight controller is reset to defaults first so the
+result matches the snapshot exactly.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  rollback,
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+
+	rollbackCmd.Flags().BoolVar(&forceLoad, "force", false, "restore even if the snapshot is for a different board or firmware")
+	addOutputDirFlag(rollbackCmd, "directory of the dumps the snapshots are kept with")
+}
+
+func rollback(cmd *cobra.Command, args []string) {
+	// Connect to the selected or auto-detected flight controller
+	fc, err := connectFC()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var snapshot string
+	if len(args) == 0 {
+		snapshot, err = latestSnapshot(fc)
+	} else {
+		snapshot, err = findSnapshot(fc, args[0])
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg, err := readConfigFile(snapshot)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkLoadable(cfg); err != nil {
+		log.Fatalf("Not restoring %s: %s", snapshot, err)
+	}
+	fmt.Printf("Restoring snapshot: %s\n", snapshot)
+	checkIdentity(fc, cfg)
+
+	// Activate the CLI mode
+	session, err := cli.Enter(fc.Port)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The rollback can be undone like any other load
+	current, err := takeSnapshot(fc, session)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Saved snapshot: %s\n", current)
+
+	if _, err := session.Exec("defaults nosave"); err != nil {
+		log.Fatal(err)
+	}
+	loadConfig(session, cfg, snapshot)
+}
+
+// takeSnapshot saves `diff all` and `dump all` to timestamped files in the
+// craft's directory, returning the name of the diff.
+func takeSnapshot(board *fc.FC, session *cli.Session) (string, error) {
+	// Never overwrite a snapshot, which could be the one being restored
+	t := time.Now()
+	diffFilename := craftFilename(board, t.Format(snapshotTimeFormat)+"_DIFF")
+	for fileExists(diffFilename) {
+		t = t.Add(time.Second)
+		diffFilename = craftFilename(board, t.Format(snapshotTimeFormat)+"_DIFF")
+	}
+	dumpFilename := craftFilename(board, t.Format(snapshotTimeFormat)+"_DUMP")
+
+	diffAll, err := readFcDump(session, "diff all")
+	if err != nil {
+		return "", err
+	}
+	dumpAll, err := readFcDump(session, "dump all")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(diffFilename), os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(diffFilename, []byte(diffAll), 0644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(dumpFilename, []byte(dumpAll), 0644); err != nil {
+		return "", err
+	}
+	return diffFilename, nil
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// snapshots returns the snapshot diffs in the craft's directory by
+// timestamp.
+func snapshots(board *fc.FC) (map[string]string, error) {
+	dir := craftDir(board)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	found := make(map[string]string)
+	for _, e := range entries {
+		if m := snapshotRe.FindStringSubmatch(e.Name()); m != nil {
+			found[m[1]] = filepath.Join(dir, e.Name())
+		}
+	}
+	return found, nil
+}
+
+func latestSnapshot(board *fc.FC) (string, error) {
+	found, err := snapshots(board)
+	if err != nil {
+		return "", err
+	}
+	latest := ""
+	for stamp := range found {
+		if stamp > latest {
+			latest = stamp
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no snapshots found in %q", craftDir(board))
+	}
+	return found[latest], nil
+}
+
+// findSnapshot returns the snapshot named by a file name or timestamp.
+func findSnapshot(board *fc.FC, name string) (string, error) {
+	if fileExists(name) {
+		return name, nil
+	}
+	found, err := snapshots(board)
+	if err != nil {
+		return "", err
+	}
+	if f, ok := found[name]; ok {
+		return f, nil
+	}
+	return "", fmt.Errorf("no snapshot %q found in %q", name, craftDir(board))
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robhaswell/btflcli/cli"
+)
+
+func TestSnapshots(t *testing.T) {
+	useSim(t)
+	dumpOutputDir = "dumps"
+	board, err := connectFC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer board.Close()
+	if _, err := latestSnapshot(board); err == nil {
+		t.Error("found a snapshot before taking any")
+	}
+
+	session, err := cli.Enter(board.Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Exit()
+	first, err := takeSnapshot(board, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Taken within the same second, so the second is named a second later
+	second, err := takeSnapshot(board, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{first, second} {
+		if filepath.Dir(name) != filepath.Join("dumps", "Bench Quad") {
+			t.Errorf("snapshot %s isn't in the craft's directory", name)
+		}
+		if _, err := os.Stat(strings.TrimSuffix(name, "_DIFF.txt") + "_DUMP.txt"); err != nil {
+			t.Error(err)
+		}
+	}
+	if first == second {
+		t.Fatalf("both snapshots are %s", first)
+	}
+
+	latest, err := latestSnapshot(board)
+	if err != nil || latest != second {
+		t.Errorf("latest is %s, %v, want %s", latest, err, second)
+	}
+	stamp := snapshotRe.FindStringSubmatch(first)[1]
+	tests := []struct {
+		name, want string
+	}{
+		{stamp, first},
+		{first, first},
+		{second, second},
+	}
+	for _, tt := range tests {
+		if got, err := findSnapshot(board, tt.name); err != nil || got != tt.want {
+			t.Errorf("%s found %s, %v, want %s", tt.name, got, err, tt.want)
+		}
+	}
+	for _, name := range []string{"20000101-000000", "BTFL_4.5.0_20000101-000000_DIFF.txt"} {
+		if got, err := findSnapshot(board, name); err == nil {
+			t.Errorf("%s found %s", name, got)
+		}
+	}
+}
+
+func TestRollbackFromOtherDirectory(t *testing.T) {
+	sim := useSim(t)
+	dumps, err := filepath.Abs("dumps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := writeFile(t, "cfg.txt", "# diff all\r\nset gyro_lpf1_static_hz = 200\r\n")
+	run(t, "load", name, "--output-dir", dumps)
+	checkSetting(t, sim, "gyro_lpf1_static_hz", "200")
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	run(t, "rollback", "--output-dir", dumps)
+	checkSetting(t, sim, "gyro_lpf1_static_hz", "250")
+}
@@ -35,6 +35,8 @@ My Quad/BTFL_4.4.2_DUMP.txt
 My Quad/BTFL_4.4.2_DIFF.txt
 
 Use the 'load' command and pass a filename to load the contents of a file to the connected flight controller.
+
+The flight controller is found by probing every serial port for a board that answers MSP requests. Use --port to select one explicitly.
 `,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
@@ -56,6 +58,9 @@ func init() {
 	// will be global for your application.
 
 	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.btflcli.yaml)")
+	rootCmd.PersistentFlags().StringVarP(&portName, "port", "p", "", "serial port, tcp:// or udp:// URL, or sim:// for a simulated board (default is to auto-detect)")
+	rootCmd.PersistentFlags().IntVarP(&baudRate, "baud", "b", defaultBaudRate, "baud rate of the serial port")
+	rootCmd.PersistentFlags().BoolVar(&mspV2, "msp-v2", false, "use MSPv2 framing if the flight controller supports it")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -0,0 +1,402 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/robhaswell/btflcli/msp"
+	"github.com/robhaswell/btflcli/rx"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const (
+	// rxRequestTimeout is how long to wait for the FC to acknowledge the
+	// channels before counting them as dropped
+	rxRequestTimeout = 100 * time.Millisecond
+	// rxStatusInterval is the time between updates of the arming flags
+	// and modes
+	rxStatusInterval = 250 * time.Millisecond
+	// rxAuxKeys is the number of AUX channels with a key
+	rxAuxKeys = 10
+)
+
+var (
+	rxRate           string
+	rxJoystick       string
+	rxMapping        string
+	rxJoystickFormat string
+)
+
+// rxCmd represents the rx command
+var rxCmd = &cobra.Command{
+	Use:   "rx",
+	Short: "Control the flight controller from the keyboard as a virtual transmitter",
+	Long: `Send RC channels to the flight controller from the keyboard, for testing modes
+and arming on the bench without a radio. REMOVE THE PROPELLERS FIRST.
+
+  W / S        throttle up / down
+  A / D        yaw left / right
+  arrows       pitch and roll
+  1 to 9, 0    toggle AUX 1 to 10
+  q or Esc     quit
+
+Sticks spring back to the centre, and the throttle drops back to low, when
+their key is released. The channels are sent in the order the flight controller's
+receiver uses, and the live positions, arming flags and active modes are
+shown.
+
+The flight controller only uses the channels if its receiver is MSP, e.g.
+after 'set serialrx_provider' in older firmware or 'set receiver_type = MSP'
+in 4.5. Quitting sends the throttle and every AUX channel low, after which
+the flight controller goes into failsafe.
+
+With --joystick the sticks and AUX channels follow a joystick or gamepad,
+read from /dev/input/js* or /dev/input/event*, as assigned by a --mapping
+file:
+
+  roll:     {axis: 3, expo: 0.3, deadband: 0.05}
+  pitch:    {axis: 4, expo: 0.3, deadband: 0.05, invert: true}
+  yaw:      {axis: 0, expo: 0.2, deadband: 0.05}
+  throttle: {axis: 1, invert: true}
+  aux:
+    1: {button: 0, toggle: true}
+    2: {axis: 5}
+
+Axes and buttons are numbered as by jstest, or by their ABS_ and BTN_ codes
+for event devices, whose axes also need their min and max as shown by evtest.
+Events recorded from a device, e.g. with cat /dev/input/js0 > moves.js, can
+be given instead and are replayed at the speed they were recorded. Event
+devices are read with the layout of this machine, and recordings from a
+64-bit or 32-bit machine with --joystick-format evdev64 or evdev32.`,
+	Args: cobra.NoArgs,
+	Run:  runRX,
+}
+
+func init() {
+	rootCmd.AddCommand(rxCmd)
+
+	rxCmd.Flags().StringVar(&rxRate, "rate", "50Hz", "channel updates a second, e.g. 50Hz, or the time between them, e.g. 20ms")
+	rxCmd.Flags().StringVar(&rxJoystick, "joystick", "", "joystick device, or a recording of its events")
+	rxCmd.Flags().StringVar(&rxMapping, "mapping", "", "file assigning the joystick's axes and buttons to channels")
+	rxCmd.Flags().StringVar(&rxJoystickFormat, "joystick-format", "", "js, evdev, evdev64 or evdev32 (default from the device name)")
+}
+
+func runRX(cmd *cobra.Command, args []string) {
+	interval, err := parseRate(rxRate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var mapping *rx.Mapping
+	var joystick *rx.JoystickReader
+	if rxJoystick != "" {
+		if mapping, joystick, err = openJoystick(); err != nil {
+			log.Fatal(err)
+		}
+	} else if !term.IsTerminal(int(os.Stdin.Fd())) {
+		log.Fatal("btfl rx reads keys from a terminal, or give a --joystick")
+	}
+
+	// Connect to the selected or auto-detected flight controller
+	fc, err := connectFC()
+	if err != nil {
+		log.Fatal(err)
+	}
+	channelMap, err := fc.RXMap()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(channelMap) < 4 {
+		log.Fatalf("Unexpected RX map %v", channelMap)
+	}
+	for _, ch := range channelMap[:4] {
+		if ch >= 4 {
+			log.Fatalf("Unsupported RX map %v, the sticks must be the first four channels", channelMap)
+		}
+	}
+	fc.SetRequestTimeout(rxRequestTimeout)
+
+	if err := runTransmitter(fc, channelMap, interval, mapping, joystick); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// openJoystick reads the --mapping file and opens the --joystick device or
+// recording.
+func openJoystick() (*rx.Mapping, *rx.JoystickReader, error) {
+	if rxMapping == "" {
+		return nil, nil, errors.New("--joystick needs a --mapping file")
+	}
+	format := rx.JSFormat
+	switch rxJoystickFormat {
+	case "":
+		if strings.HasPrefix(filepath.Base(rxJoystick), "event") {
+			format = rx.EvdevFormat
+		}
+	case "js":
+	case "evdev":
+		format = rx.EvdevFormat
+	case "evdev64":
+		format = rx.Evdev64Format
+	case "evdev32":
+		format = rx.Evdev32Format
+	default:
+		return nil, nil, fmt.Errorf("unknown joystick format %q", rxJoystickFormat)
+	}
+
+	f, err := os.Open(rxMapping)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	mapping, err := rx.ReadMapping(f, format)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", rxMapping, err)
+	}
+	dev, err := os.Open(rxJoystick)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := dev.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	j := rx.NewJoystickReader(dev, format)
+	// A recording is replayed at the speed it was made
+	j.Realtime = info.Mode().IsRegular()
+	return mapping, j, nil
+}
+
+// runTransmitter sends the sticks to the FC and redraws them until the user
+// quits or the joystick, if there is one, fails or reaches the end of a
+// recording.
+func runTransmitter(board *fc.FC, channelMap []uint8, interval time.Duration, mapping *rx.Mapping, joystick *rx.JoystickReader) error {
+	var sticks rx.RxSticks
+	sticks.Reset()
+	// stop receives nil when the user quits or a recording ends
+	stop := make(chan error, 2)
+	auxCount := rxAuxKeys
+	source := "keyboard"
+	if joystick != nil {
+		auxCount = max(auxCount, mapping.AUXCount())
+		source = rxJoystick
+		go func() {
+			err := mapping.Drive(joystick, &sticks)
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			stop <- err
+		}()
+	}
+
+	// Read keys as they are pressed, if there is someone to press them
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	stdin := int(os.Stdin.Fd())
+	if term.IsTerminal(stdin) {
+		old, err := term.MakeRaw(stdin)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(stdin, old)
+		go readRXKeys(os.Stdin, &sticks, stop)
+	}
+	out := os.Stdout
+	if term.IsTerminal(int(out.Fd())) {
+		fmt.Fprint(out, enterAltScreen)
+		defer fmt.Fprint(out, leaveAltScreen)
+	}
+
+	var status *fc.Status
+	statusSupported := true
+	var lastStatus time.Time
+	dropped := 0
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-stop:
+			return stopTransmitter(board, &sticks, channelMap, err)
+		case <-interrupt:
+			return stopTransmitter(board, &sticks, channelMap, nil)
+		case <-ticker.C:
+		}
+
+		sticks.Update()
+		err := board.SetRawRC(sticks.ToMSP(channelMap).Channels)
+		switch {
+		case msp.IsReplyError(err):
+			return errors.New("the flight controller refused the RC channels, check its receiver is set to MSP")
+		case errors.Is(err, context.DeadlineExceeded):
+			dropped++
+		case err != nil:
+			return err
+		}
+
+		if statusSupported && time.Since(lastStatus) >= rxStatusInterval {
+			lastStatus = time.Now()
+			st, err := board.Status()
+			switch {
+			case msp.IsReplyError(err):
+				statusSupported = false
+			case err == nil:
+				status = st
+			case !errors.Is(err, context.DeadlineExceeded) && !msp.IsFrameError(err):
+				return err
+			}
+		}
+
+		width, _, err := term.GetSize(int(out.Fd()))
+		if err != nil || width <= 0 {
+			width = defaultWidth
+		}
+		// The sticks in the FC's own order, for display
+		values := sticks.ToMSP([]uint8{0, 1, 2, 3}).Channels
+		io.WriteString(out, cursorHome+renderTransmitter(board, source, values, auxCount, status, dropped, width)+clearToEOS)
+	}
+}
+
+// stopTransmitter sends the throttle and switches low, rather than leaving
+// them where they were, and returns err if it isn't nil.
+func stopTransmitter(board *fc.FC, sticks *rx.RxSticks, channelMap []uint8, err error) error {
+	sticks.Reset()
+	if sendErr := board.SetRawRC(sticks.ToMSP(channelMap).Channels); err == nil {
+		err = sendErr
+	}
+	return err
+}
+
+// readRXKeys feeds the keys pressed to sticks, and sends nil to stop when
+// q, Esc or Ctrl-C is pressed.
+func readRXKeys(r io.Reader, sticks *rx.RxSticks, stop chan<- error) {
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		keys, quit := parseRXKeys(buf[:n])
+		for _, key := range keys {
+			sticks.Keypress(key)
+		}
+		if quit {
+			stop <- nil
+			return
+		}
+	}
+}
+
+// rxLetterKeys are the keys for the left stick.
+var rxLetterKeys = map[byte]rx.RXKey{
+	'w': rx.RXKeyW,
+	'a': rx.RXKeyA,
+	's': rx.RXKeyS,
+	'd': rx.RXKeyD,
+}
+
+// rxArrowKeys are the final bytes of the escape sequences of the arrow
+// keys.
+var rxArrowKeys = map[byte]rx.RXKey{
+	'A': rx.RXKeyUp,
+	'B': rx.RXKeyDown,
+	'C': rx.RXKeyRight,
+	'D': rx.RXKeyLeft,
+}
+
+// parseRXKeys returns the keys in what was read from a raw terminal, and
+// whether the user asked to quit.
+func parseRXKeys(b []byte) (keys []rx.RXKey, quit bool) {
+	for ii := 0; ii < len(b); ii++ {
+		c := b[ii]
+		switch {
+		case c == 0x1b && ii+2 < len(b) && (b[ii+1] == '[' || b[ii+1] == 'O'):
+			// Arrow keys are ESC [ A to D, or ESC O A to D
+			if key, ok := rxArrowKeys[b[ii+2]]; ok {
+				keys = append(keys, key)
+			}
+			ii += 2
+		case c == 0x1b, c == 0x03, c == 'q', c == 'Q':
+			return keys, true
+		case c >= '1' && c <= '9':
+			keys = append(keys, rx.RXKey1+rx.RXKey(c-'1'))
+		case c == '0':
+			keys = append(keys, rx.RXKey0)
+		default:
+			if key, ok := rxLetterKeys[c|0x20]; ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, false
+}
+
+// renderTransmitter returns the stick and the first auxCount AUX positions
+// and the state of the FC as lines of at most width columns.
+func renderTransmitter(board *fc.FC, source string, values []uint16, auxCount int, status *fc.Status, dropped, width int) string {
+	var lines []string
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+	add("%s %d.%d.%d %s  virtual transmitter: %s", board.Variant, board.VersionMajor, board.VersionMinor, board.VersionPatch, board.Name, source)
+	add("")
+	for ii, v := range values {
+		if ii < len(rcChannelNames) {
+			add("%-10s %4d %s", rcChannelNames[ii], v, bar(v))
+			continue
+		}
+		aux := ii - len(rcChannelNames) + 1
+		if aux > auxCount {
+			break
+		}
+		key := ""
+		if aux <= rxAuxKeys {
+			key = fmt.Sprintf("  key %d", aux%10)
+		}
+		add("%-10s %4d %s%s", fmt.Sprintf("AUX %d", aux), v, bar(v), key)
+	}
+	add("")
+	if status != nil {
+		add("%-10s %s", "Arming", namesOrNone(status.ArmingDisabled))
+		add("%-10s %s", "Modes", namesOrNone(status.FlightModes))
+	}
+	if dropped > 0 {
+		add("%-10s %d", "Dropped", dropped)
+	}
+	add("")
+	add("W/S throttle  A/D yaw  arrows pitch and roll  1-0 AUX  q quit")
+
+	var b strings.Builder
+	for _, l := range lines {
+		if utf8.RuneCountInString(l) > width {
+			l = string([]rune(l)[:width])
+		}
+		b.WriteString(l + clearToEOL + "\r\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/robhaswell/btflcli/rx"
+)
+
+func TestParseRXKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		keys []rx.RXKey
+		quit bool
+	}{
+		{"w", []rx.RXKey{rx.RXKeyW}, false},
+		{"WaSd", []rx.RXKey{rx.RXKeyW, rx.RXKeyA, rx.RXKeyS, rx.RXKeyD}, false},
+		{"\x1b[A\x1b[B\x1b[C\x1b[D", []rx.RXKey{rx.RXKeyUp, rx.RXKeyDown, rx.RXKeyRight, rx.RXKeyLeft}, false},
+		{"\x1bOA", []rx.RXKey{rx.RXKeyUp}, false},
+		{"1290", []rx.RXKey{rx.RXKey1, rx.RXKey2, rx.RXKey9, rx.RXKey0}, false},
+		// Other escape sequences and keys are ignored
+		{"\x1b[Hx ", nil, false},
+		{"wq", []rx.RXKey{rx.RXKeyW}, true},
+		{"Q", nil, true},
+		{"\x03", nil, true},
+		{"\x1b", nil, true},
+		{"d\x1b[", []rx.RXKey{rx.RXKeyD}, true},
+	}
+	for _, tt := range tests {
+		keys, quit := parseRXKeys([]byte(tt.in))
+		if !reflect.DeepEqual(keys, tt.keys) || quit != tt.quit {
+			t.Errorf("parseRXKeys(%q) is %v, %v, want %v, %v", tt.in, keys, quit, tt.keys, tt.quit)
+		}
+	}
+}
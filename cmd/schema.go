@@ -0,0 +1,144 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/robhaswell/btflcli/cli"
+	"github.com/robhaswell/btflcli/config"
+	"github.com/robhaswell/btflcli/schema"
+	"github.com/spf13/cobra"
+)
+
+var schemaOutput string
+
+// schemaCmd represents the schema command
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Work with the settings schema of a firmware release",
+}
+
+// schemaDumpCmd represents the schema dump command
+var schemaDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Save the type and range of every setting on the flight controller",
+	Long: `Ask the flight controller about every setting with the CLI get command and save
+the type, range and allowed values of each to a schema file, which the
+validate command uses to check configurations without a board.
+
+The file is named after the firmware, e.g. BTFL_4.5.0_SCHEMA.json, unless
+--output is given.`,
+	Args: cobra.NoArgs,
+	Run:  schemaDump,
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+	schemaCmd.AddCommand(schemaDumpCmd)
+
+	schemaDumpCmd.Flags().StringVarP(&schemaOutput, "output", "o", "", "file to write the schema to")
+}
+
+func schemaDump(cmd *cobra.Command, args []string) {
+	// Connect to the selected or auto-detected flight controller
+	fc, err := connectFC()
+	if err != nil {
+		log.Fatal(err)
+	}
+	id, err := readIdentity(fc)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Activate the CLI mode
+	session, err := cli.Enter(fc.Port)
+	if err != nil {
+		log.Fatal(err)
+	}
+	vars, err := readVariables(session)
+	if err != nil {
+		session.Exit()
+		log.Fatal(err)
+	}
+	if err := session.Exit(); err != nil {
+		log.Fatal(err)
+	}
+
+	version := fmt.Sprintf("%d.%d.%d", fc.VersionMajor, fc.VersionMinor, fc.VersionPatch)
+	s := schema.New(fc.Variant, version, id.TargetName, vars)
+
+	name := schemaOutput
+	if name == "" {
+		name = fmt.Sprintf("%s_%s_SCHEMA.json", sanitiseFilename(fc.Variant), version)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := s.Write(f); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Written file: %s\n", name)
+}
+
+// readVariables reads the type and range of every setting with get, using
+// dump all to find their names.
+func readVariables(session *cli.Session) ([]cli.Variable, error) {
+	// dump all lists every setting, in every profile
+	dumpAll, err := readFcDump(session, "dump all")
+	if err != nil {
+		return nil, err
+	}
+	names := settingNames(config.ParseString(dumpAll))
+	fmt.Fprintf(os.Stderr, "Reading %d settings\n", len(names))
+
+	var vars []cli.Variable
+	for _, name := range names {
+		found, err := session.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		// get matches every setting containing the name
+		for _, v := range found {
+			if v.Name == name {
+				vars = append(vars, v)
+			}
+		}
+	}
+	return vars, nil
+}
+
+// settingNames returns the names of the settings set anywhere in a
+// configuration, sorted.
+func settingNames(c *config.Config) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, section := range c.Sections() {
+		for _, s := range c.Settings(section) {
+			if !seen[s.Name] {
+				seen[s.Name] = true
+				names = append(names, s.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,535 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/robhaswell/btflcli/cli"
+	"github.com/robhaswell/btflcli/config"
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// serveReconnectTimeout is how long to wait for the FC to come back
+	// after it reboots on leaving the CLI
+	serveReconnectTimeout = 30 * time.Second
+	defaultTelemetryRate  = 100 * time.Millisecond
+	// minTelemetryRate is the shortest interval clients may ask for, below
+	// which polls would queue behind each other
+	minTelemetryRate = 10 * time.Millisecond
+)
+
+var (
+	serveListen       string
+	serveAllowOrigins []string
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve an HTTP API for the flight controller",
+	Long: `Serve an HTTP and JSON API for the connected flight controller, for web
+UIs and scripts in other languages:
+
+  GET  /api/identity         the firmware, board and craft
+  GET  /api/diff             diff all, as a file
+  GET  /api/dump             dump all, as a file
+  POST /api/load             load the configuration in the body, streaming
+                             the result of each line as JSON lines
+  GET  /api/settings/<name>  a setting, as get -f json describes it
+  PUT  /api/settings/<name>  set and save a setting from {"value": "..."}
+  GET  /api/telemetry        a WebSocket sending readings as JSON
+
+Requests take turns with the flight controller, so the MSP and CLI traffic
+of clients can't interleave. The flight controller reboots after each use of
+the CLI, and requests wait while it reconnects.
+
+/api/load accepts continue_on_error and force parameters, which work like
+the flags of the load command. /api/telemetry accepts messages, a comma
+separated list of ` + strings.Join(messageNames(), ", ") + `, and
+interval, of at least ` + minTelemetryRate.String() + `.
+
+Browsers may only use the API from pages it serves, or from the origins given
+with --allow-origin. Requests must be addressed to the --listen address or to
+localhost.`,
+	Args: cobra.NoArgs,
+	Run:  serve,
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&serveListen, "listen", "localhost:9111", "address to serve the API on")
+	serveCmd.Flags().StringSliceVar(&serveAllowOrigins, "allow-origin", nil, "origins of web pages allowed to use the API, e.g. http://localhost:3000")
+	addOutputDirFlag(serveCmd, "directory of the dumps to keep snapshots with")
+}
+
+// fcServer serves the API for one flight controller. mu is held for each
+// use of the FC.
+type fcServer struct {
+	mu    sync.Mutex
+	board *fc.FC
+}
+
+func serve(cmd *cobra.Command, args []string) {
+	// Connect to the selected or auto-detected flight controller
+	board, err := connectFC()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := &fcServer{board: board}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/identity", s.identity)
+	mux.HandleFunc("/api/diff", s.dump("diff all", "DIFF"))
+	mux.HandleFunc("/api/dump", s.dump("dump all", "DUMP"))
+	mux.HandleFunc("/api/load", s.load)
+	mux.HandleFunc("/api/settings/", s.setting)
+	mux.HandleFunc("/api/telemetry", s.telemetry)
+
+	log.Printf("Serving the API on http://%s/api/", serveListen)
+	log.Fatal(http.ListenAndServe(serveListen, checkOrigin(mux)))
+}
+
+// checkOrigin refuses requests from web pages on other sites, which could
+// otherwise change the configuration of a board on localhost, unless they
+// were allowed with --allow-origin. Requests for other hosts are refused
+// first, since a site which rebinds its name to localhost has the same
+// origin as the Host it sends.
+func checkOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(r.Host) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %s is not allowed", r.Host))
+			return
+		}
+		origin := r.Header.Get("Origin")
+		if origin == "" || sameOrigin(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !allowedOrigin(origin) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("origin %s is not allowed", origin))
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Vary", "Origin")
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost reports whether host, with any port, is the --listen address
+// or a name for the loopback interface.
+func allowedHost(host string) bool {
+	if strings.EqualFold(host, serveListen) {
+		return true
+	}
+	switch strings.ToLower((&url.URL{Host: host}).Hostname()) {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+func sameOrigin(r *http.Request) bool {
+	u, err := url.Parse(r.Header.Get("Origin"))
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func allowedOrigin(origin string) bool {
+	for _, o := range serveAllowOrigins {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+var upgrader = websocket.Upgrader{
+	// Origins were checked by checkOrigin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// allowMethod answers 405 and returns false if the request doesn't use one
+// of methods.
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+func queryBool(r *http.Request, name string) bool {
+	b, _ := strconv.ParseBool(r.URL.Query().Get(name))
+	return b
+}
+
+// connect reconnects to the FC if the connection was lost. s.mu must be
+// held.
+func (s *fcServer) connect() error {
+	if s.board.MSP() != nil {
+		return nil
+	}
+	return s.board.Reconnect(serveReconnectTimeout)
+}
+
+// withCLI runs fn in CLI mode, then saves if it returns true or discards
+// the changes otherwise, and waits for the FC to come back after it
+// reboots. s.mu must be held.
+func (s *fcServer) withCLI(fn func(session *cli.Session) (bool, error)) error {
+	if err := s.connect(); err != nil {
+		return err
+	}
+	s.board.MSP().StopReader()
+	session, err := cli.Enter(s.board.Port)
+	if err != nil {
+		s.board.Close()
+		return err
+	}
+	save, err := fn(session)
+	var leaveErr error
+	if save && err == nil {
+		leaveErr = session.Save()
+	} else {
+		leaveErr = session.Exit()
+	}
+	if reconnectErr := s.board.Reconnect(serveReconnectTimeout); reconnectErr != nil {
+		return errors.Join(err, reconnectErr)
+	}
+	return errors.Join(err, leaveErr)
+}
+
+// identityResponse is the answer to /api/identity.
+type identityResponse struct {
+	Port       string `json:"port"`
+	Variant    string `json:"variant"`
+	Version    string `json:"version"`
+	APIVersion string `json:"api_version"`
+	CraftName  string `json:"craft_name"`
+	*fc.Identity
+}
+
+func (s *fcServer) identity(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.connect(); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	id, err := readIdentity(s.board)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	b := s.board
+	writeJSON(w, http.StatusOK, identityResponse{
+		Port:       b.MSP().PortName(),
+		Variant:    b.Variant,
+		Version:    fmt.Sprintf("%d.%d.%d", b.VersionMajor, b.VersionMinor, b.VersionPatch),
+		APIVersion: fmt.Sprintf("%d.%d", b.APIMajor, b.APIMinor),
+		CraftName:  b.Name,
+		Identity:   id,
+	})
+}
+
+// dump returns a handler which downloads the output of a diff or dump
+// command, named as the Configurator would name the file.
+func (s *fcServer) dump(command, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var output, filename string
+		err := s.withCLI(func(session *cli.Session) (bool, error) {
+			filename = filepath.Base(craftFilename(s.board, kind))
+			var err error
+			output, err = readFcDump(session, command)
+			return false, err
+		})
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		io.WriteString(w, output)
+	}
+}
+
+// loadProgress is streamed for each line of a configuration as it loads.
+type loadProgress struct {
+	Line    int    `json:"line"`
+	Command string `json:"command"`
+	Error   string `json:"error,omitempty"`
+}
+
+// loadResult ends the stream of a load.
+type loadResult struct {
+	Done bool `json:"done"`
+	// Snapshot is the file the configuration was saved to beforehand
+	Snapshot string `json:"snapshot,omitempty"`
+	Failed   int    `json:"failed"`
+	Saved    bool   `json:"saved"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (s *fcServer) load(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	cfg, err := config.Parse(r.Body)
+	if err == nil {
+		err = checkLoadable(cfg)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.connect(); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	id, err := readIdentity(s.board)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	if mismatches := identityMismatches(s.board, id, cfg.Header); len(mismatches) > 0 && !queryBool(r, "force") {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":      "the configuration is for a different flight controller, use force=true to load it anyway",
+			"mismatches": mismatches,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	send := func(v interface{}) {
+		enc.Encode(v)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	result := loadResult{Done: true}
+	err = s.withCLI(func(session *cli.Session) (bool, error) {
+		var err error
+		if result.Snapshot, err = takeSnapshot(s.board, session); err != nil {
+			return false, err
+		}
+		failed, err := applyConfig(session, cfg, func(l *config.Line, err error) {
+			p := loadProgress{Line: l.Number, Command: strings.TrimSpace(l.Text)}
+			var cmdErr *cli.CommandError
+			if errors.As(err, &cmdErr) {
+				p.Error = cmdErr.Message
+			}
+			send(p)
+		})
+		result.Failed = len(failed)
+		result.Saved = err == nil && (len(failed) == 0 || queryBool(r, "continue_on_error"))
+		return result.Saved, err
+	})
+	if err != nil {
+		result.Saved = false
+		result.Error = err.Error()
+	}
+	send(result)
+}
+
+// setRequest is the body of a PUT to /api/settings/<name>.
+type setRequest struct {
+	Value string `json:"value"`
+}
+
+func (s *fcServer) setting(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+	name := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/api/settings/"))
+	if name == "" || strings.ContainsAny(name, "/ \t\r\n") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("invalid setting name %q", name))
+		return
+	}
+	var req setRequest
+	if r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found *cli.Variable
+	var result *setResult
+	err := s.withCLI(func(session *cli.Session) (bool, error) {
+		vars, err := session.Get(name)
+		var cmdErr *cli.CommandError
+		if errors.As(err, &cmdErr) {
+			// The FC knows no setting by that name
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for ii := range vars {
+			if vars[ii].Name == name {
+				found = &vars[ii]
+			}
+		}
+		if found == nil || r.Method == http.MethodGet {
+			return false, nil
+		}
+		result = &setResult{Name: name, Value: req.Value}
+		stored, err := session.Set(name, req.Value)
+		if errors.As(err, &cmdErr) {
+			result.Error = err.Error()
+			return false, nil
+		}
+		result.Value = stored
+		return err == nil, err
+	})
+	switch {
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+	case found == nil:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown setting %q", name))
+	case result == nil:
+		writeJSON(w, http.StatusOK, found)
+	case result.Error != "":
+		writeJSON(w, http.StatusBadRequest, result)
+	default:
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// telemetry streams readings over a WebSocket until the client goes away.
+func (s *fcServer) telemetry(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	names := strings.Join(messageNames(), ",")
+	if m := r.URL.Query().Get("messages"); m != "" {
+		names = m
+	}
+	codes, err := parseMessages(names)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	interval := defaultTelemetryRate
+	if i := r.URL.Query().Get("interval"); i != "" {
+		if interval, err = time.ParseDuration(i); err != nil || interval <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid interval %q", i))
+			return
+		}
+		if interval < minTelemetryRate {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("interval %s is shorter than %s", interval, minTelemetryRate))
+			return
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered
+		return
+	}
+	defer conn.Close()
+	// Nothing is expected from the client, but reading notices it leaving
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	poller := s.board.NewPoller(codes...)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		t, err := s.poll(poller)
+		if err != nil {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+			return
+		}
+		if err := conn.WriteJSON(t); err != nil {
+			return
+		}
+		select {
+		case <-gone:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll takes a round of readings, dropping the connection if it fails so
+// that the next request reconnects.
+func (s *fcServer) poll(poller *fc.Poller) (*fc.Telemetry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	t, err := poller.Poll()
+	if err != nil {
+		s.board.Close()
+	}
+	return t, err
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	saved := serveListen
+	serveListen = "192.168.1.20:9111"
+	serveAllowOrigins = []string{"http://localhost:3000/"}
+	t.Cleanup(func() {
+		serveListen = saved
+		serveAllowOrigins = nil
+	})
+	handler := checkOrigin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		host, origin string
+		want         int
+	}{
+		{"localhost:9111", "", http.StatusOK},
+		{"localhost:9111", "http://localhost:9111", http.StatusOK},
+		{"127.0.0.1:9111", "http://127.0.0.1:9111", http.StatusOK},
+		{"[::1]:9111", "http://[::1]:9111", http.StatusOK},
+		{"LocalHost:9111", "", http.StatusOK},
+		{"192.168.1.20:9111", "http://192.168.1.20:9111", http.StatusOK},
+		{"localhost:9111", "http://localhost:3000", http.StatusOK},
+		{"localhost:9111", "http://evil.example", http.StatusForbidden},
+		// A rebound name has the same origin as its Host
+		{"evil.example:9111", "http://evil.example:9111", http.StatusForbidden},
+		{"evil.example:9111", "", http.StatusForbidden},
+		{"192.168.1.20:80", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/identity", nil)
+		r.Host = tt.host
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("host %s, origin %q: got %d, want %d", tt.host, tt.origin, w.Code, tt.want)
+		}
+	}
+}
+
+func TestTelemetryInterval(t *testing.T) {
+	s := &fcServer{}
+	for _, tt := range []struct {
+		interval, err string
+	}{
+		{"1ms", "shorter than 10ms"},
+		{"0s", "invalid interval"},
+		{"fast", "invalid interval"},
+	} {
+		w := httptest.NewRecorder()
+		s.telemetry(w, httptest.NewRequest(http.MethodGet, "/api/telemetry?interval="+tt.interval, nil))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.err) {
+			t.Errorf("interval %s: got %d %s, want %q", tt.interval, w.Code, w.Body, tt.err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/robhaswell/btflcli/cli"
+	"github.com/spf13/cobra"
+)
+
+var (
+	setFormat string
+	setSave   bool
+)
+
+// setResult is the outcome of setting one value
+type setResult struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Error string `json:"error,omitempty"`
+}
+
+// setCmd represents the set command
+var setCmd = &cobra.Command{
+	Use:   "set name=value...",
+	Short: "Change settings on the flight controller",
+	Long: `Change one or more settings on the flight controller, as the CLI set command
+does.
+
+The changes are only kept if --save is given; otherwise the values are
+checked and then discarded. If the flight controller rejects any value
+nothing is saved, the values it accepts are reported and the exit status is
+non-zero.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  set,
+}
+
+func init() {
+	rootCmd.AddCommand(setCmd)
+
+	setCmd.Flags().StringVarP(&setFormat, "format", "f", "plain", "output format: plain or json")
+	setCmd.Flags().BoolVar(&setSave, "save", false, "save the changes")
+}
+
+func set(cmd *cobra.Command, args []string) {
+	if setFormat != "plain" && setFormat != "json" {
+		log.Fatalf("Unknown format %q", setFormat)
+	}
+	var results []setResult
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			log.Fatalf("Expected name=value, got %q", arg)
+		}
+		results = append(results, setResult{Name: name, Value: strings.TrimSpace(value)})
+	}
+
+	// Connect to the selected or auto-detected flight controller
+	fc, err := connectFC()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Activate the CLI mode
+	session, err := cli.Enter(fc.Port)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	failed := false
+	for ii := range results {
+		r := &results[ii]
+		stored, err := session.Set(r.Name, r.Value)
+		var cmdErr *cli.CommandError
+		if errors.As(err, &cmdErr) {
+			r.Error = err.Error()
+			failed = true
+			continue
+		}
+		if err != nil {
+			session.Exit()
+			log.Fatal(err)
+		}
+		r.Value = stored
+	}
+
+	if setSave && !failed {
+		err = session.Save()
+	} else {
+		err = session.Exit()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if setFormat == "json" {
+		printJSON(results)
+	} else {
+		for _, r := range results {
+			if r.Error != "" {
+				fmt.Fprintln(os.Stderr, r.Error)
+			} else {
+				fmt.Printf("%s = %s\n", r.Name, r.Value)
+			}
+		}
+	}
+	switch {
+	case failed:
+		fmt.Fprintln(os.Stderr, "Nothing saved")
+		os.Exit(1)
+	case setSave:
+		fmt.Fprintln(os.Stderr, "Saved")
+	default:
+		fmt.Fprintln(os.Stderr, "Not saved, use --save to keep the changes")
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/robhaswell/btflcli/msp"
+	"github.com/spf13/cobra"
+)
+
+// clearScreen moves the cursor home and clears the terminal
+const clearScreen = "\x1b[H\x1b[2J"
+
+var (
+	statusWatch    bool
+	statusInterval time.Duration
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the flight controller",
+	Long: `Show the flight controller's loop time, CPU load, sensors, profiles, active
+flight modes and the reasons it won't arm, along with the battery readings
+and attitude.
+
+With --watch the report is refreshed in place until interrupted.`,
+	Args: cobra.NoArgs,
+	Run:  status,
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().BoolVarP(&statusWatch, "watch", "w", false, "keep refreshing the report")
+	statusCmd.Flags().DurationVar(&statusInterval, "interval", 500*time.Millisecond, "time between refreshes with --watch")
+}
+
+func status(cmd *cobra.Command, args []string) {
+	// Connect to the selected or auto-detected flight controller
+	fc, err := connectFC()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for {
+		var buf bytes.Buffer
+		if err := writeStatus(&buf, fc); err != nil {
+			log.Fatal(err)
+		}
+		if !statusWatch {
+			os.Stdout.Write(buf.Bytes())
+			return
+		}
+		fmt.Print(clearScreen)
+		os.Stdout.Write(buf.Bytes())
+		time.Sleep(statusInterval)
+	}
+}
+
+// writeStatus writes a report of the FC's state. Readings the firmware
+// doesn't support are left out.
+func writeStatus(w io.Writer, board *fc.FC) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	line := func(label, format string, a ...interface{}) {
+		fmt.Fprintf(tw, "%s:\t%s\n", label, fmt.Sprintf(format, a...))
+	}
+
+	st, err := board.Status()
+	if err != nil && !msp.IsReplyError(err) {
+		return err
+	}
+	if st != nil {
+		line("Cycle time", "%d µs", st.CycleTime)
+		line("CPU load", "%d%%", st.CPULoad)
+		line("Sensors", "%s", namesOrNone(st.Sensors))
+		if st.ProfileCount > 0 {
+			line("Profile", "%d, rate profile %d", st.Profile, st.RateProfile)
+		} else {
+			line("Profile", "%d", st.Profile)
+		}
+		line("Flight modes", "%s", namesOrNone(st.FlightModes))
+		if len(st.ArmingDisabled) == 0 {
+			line("Arming disabled", "none, ready to arm")
+		} else {
+			line("Arming disabled", "%s (0x%x)", strings.Join(st.ArmingDisabled, " "), st.ArmingDisableFlags)
+		}
+		if st.RebootRequired {
+			line("Reboot required", "yes")
+		}
+	}
+
+	// Older releases don't report the battery state, but do the voltage
+	battery, err := board.Battery()
+	if err != nil && !msp.IsReplyError(err) {
+		return err
+	}
+	analog, err := board.Analog()
+	if err != nil && !msp.IsReplyError(err) {
+		return err
+	}
+	switch {
+	case battery != nil:
+		line("Battery", "%.2f V, %d cells, %.2f A, %d mAh used, %s",
+			battery.Voltage, battery.Cells, battery.Current, battery.Drawn, battery.State)
+	case analog != nil:
+		line("Battery", "%.2f V, %.2f A, %d mAh used", analog.Voltage, analog.Current, analog.Drawn)
+	}
+	if analog != nil {
+		line("RSSI", "%d%%", int(analog.RSSI)*100/1023)
+	}
+
+	att, err := board.Attitude()
+	if err != nil && !msp.IsReplyError(err) {
+		return err
+	}
+	if att != nil {
+		line("Attitude", "roll %.1f°, pitch %.1f°, heading %.0f°", att.Roll, att.Pitch, att.Heading)
+	}
+	return tw.Flush()
+}
+
+func namesOrNone(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, " ")
+}
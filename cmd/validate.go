@@ -0,0 +1,85 @@
+/*
+Copyright © 2023 Rob Haswell <rob@haswell.co.uk>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/robhaswell/btflcli/config"
+	"github.com/robhaswell/btflcli/schema"
+	"github.com/spf13/cobra"
+)
+
+var schemaFile string
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate <file>",
+	Short: "Check a configuration file against a settings schema",
+	Long: `Check every set command in a diff or dump file against a schema saved by the
+schema dump command, without a board. Unknown settings, settings in the wrong
+section, numbers out of range and values a lookup doesn't allow are reported
+with their line numbers, and the exit status is non-zero if there are any.`,
+	Args: cobra.ExactArgs(1),
+	Run:  validate,
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringVar(&schemaFile, "schema", "", "schema file written by schema dump")
+	validateCmd.MarkFlagRequired("schema")
+}
+
+func validate(cmd *cobra.Command, args []string) {
+	f, err := os.Open(schemaFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := schema.Read(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Reading %s: %v", schemaFile, err)
+	}
+	cfg, err := readConfigFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Ranges and settings change between releases
+	if v, err := config.ParseVersion(s.Version); err == nil && !cfg.Header.Version.IsZero() &&
+		(v.Major != cfg.Header.Version.Major || v.Minor != cfg.Header.Version.Minor) {
+		fmt.Fprintf(os.Stderr, "Warning: the schema is for %s %s, the configuration is for %s\n",
+			s.Variant, s.Version, cfg.Header.Version)
+	}
+
+	problems := s.Validate(cfg)
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", args[0], p)
+	}
+	switch len(problems) {
+	case 0:
+	case 1:
+		fmt.Fprintln(os.Stderr, "1 problem found")
+		os.Exit(1)
+	default:
+		fmt.Fprintf(os.Stderr, "%d problems found\n", len(problems))
+		os.Exit(1)
+	}
+	fmt.Println("No problems found")
+}
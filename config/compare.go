@@ -0,0 +1,155 @@
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+// ChangeKind says how a setting differs between two configurations.
+type ChangeKind string
+
+const (
+	Added   ChangeKind = "added"
+	Removed ChangeKind = "removed"
+	Changed ChangeKind = "changed"
+)
+
+// Change is one difference between two configurations. For `set` commands
+// Old and New are the setting values, otherwise they are the whole command.
+// Old is empty for added settings and New for removed ones, which in a diff
+// means the setting is back to its default.
+type Change struct {
+	Section Section    `json:"section"`
+	Key     string     `json:"key"`
+	Kind    ChangeKind `json:"kind"`
+	Old     string     `json:"old,omitempty"`
+	New     string     `json:"new,omitempty"`
+	// OldLine and NewLine are the source line numbers
+	OldLine int `json:"old_line,omitempty"`
+	NewLine int `json:"new_line,omitempty"`
+}
+
+// ignoredCommands don't configure anything themselves.
+var ignoredCommands = map[string]bool{
+	"profile":     true,
+	"rateprofile": true,
+	"batch":       true,
+	"defaults":    true,
+	"save":        true,
+	"diff":        true,
+	"dump":        true,
+	"exit":        true,
+}
+
+// MarshalText formats a Section as in its String method.
+func (s Section) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type entry struct {
+	value string
+	line  int
+}
+
+// entries returns what each command in a section configures, keyed by the
+// command's Key, along with the order the keys first appear in.
+func (c *Config) entries(section Section) (map[string]entry, []string) {
+	m := make(map[string]entry)
+	var order []string
+	for _, l := range c.Lines {
+		if l.Section != section || l.Command == nil || ignoredCommands[l.Command.Name] {
+			continue
+		}
+		key := l.Command.Key()
+		value := l.Command.String()
+		if l.Command.Name == "set" {
+			if len(l.Command.Args) != 2 {
+				continue
+			}
+			value = l.Command.Args[1]
+		}
+		if _, ok := m[key]; !ok {
+			order = append(order, key)
+		}
+		m[key] = entry{value: value, line: l.Number}
+	}
+	return m, order
+}
+
+// Compare returns the settings that differ between a and b, section by
+// section. Ordering, comments and repeated commands are ignored, only the
+// final effect of each section is compared.
+func Compare(a, b *Config) []Change {
+	var changes []Change
+	for _, section := range mergeSections(a.Sections(), b.Sections()) {
+		am, aOrder := a.entries(section)
+		bm, bOrder := b.entries(section)
+		seen := make(map[string]bool)
+		for _, key := range append(aOrder, bOrder...) {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			ae, inA := am[key]
+			be, inB := bm[key]
+			ch := Change{Section: section, Key: key, Old: ae.value, New: be.value, OldLine: ae.line, NewLine: be.line}
+			switch {
+			case inA && !inB:
+				ch.Kind = Removed
+			case !inA && inB:
+				ch.Kind = Added
+			case !sameValue(ae.value, be.value):
+				ch.Kind = Changed
+			default:
+				continue
+			}
+			changes = append(changes, ch)
+		}
+	}
+	return changes
+}
+
+// sameValue compares values word by word, ignoring the space around them.
+// The FC reads enum values and feature names in either case, so words in
+// one case throughout may differ in case, but text in mixed case such as a
+// craft name has to match exactly.
+func sameValue(a, b string) bool {
+	aw, bw := strings.Fields(a), strings.Fields(b)
+	if len(aw) != len(bw) {
+		return false
+	}
+	for ii := range aw {
+		if aw[ii] == bw[ii] {
+			continue
+		}
+		if !oneCase(aw[ii]) || !oneCase(bw[ii]) || !strings.EqualFold(aw[ii], bw[ii]) {
+			return false
+		}
+	}
+	return true
+}
+
+// oneCase reports whether s has no letters in both upper and lower case.
+func oneCase(s string) bool {
+	return s == strings.ToUpper(s) || s == strings.ToLower(s)
+}
+
+// mergeSections returns the sections in either list, master first, then the
+// profiles and rate profiles by index.
+func mergeSections(a, b []Section) []Section {
+	seen := make(map[Section]bool)
+	var out []Section
+	for _, s := range append(a, b...) {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind < out[j].Kind
+		}
+		return out[i].Index < out[j].Index
+	})
+	return out
+}
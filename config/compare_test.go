@@ -0,0 +1,105 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	profile1 := Section{Kind: Profile, Index: 1}
+	rateProfile0 := Section{Kind: RateProfile, Index: 0}
+	tests := []struct {
+		name string
+		a, b string
+		want []Change
+	}{
+		{
+			"identical",
+			"set p_pitch = 50\r\n",
+			"set p_pitch = 50\r\n",
+			nil,
+		},
+		{
+			"comments, blank lines, case and spacing",
+			"# diff all\r\n\r\nbatch start\r\nset gyro_lpf1_type = BIQUAD\r\nfeature GPS\r\nsave\r\n",
+			"# version\r\n# Betaflight / STM32F7X2\r\n\r\nset GYRO_LPF1_TYPE = biquad\r\n\r\n  feature   gps  \r\n",
+			nil,
+		},
+		{
+			"renamed in another case",
+			"set craft_name = Bench Quad\r\nset pilot_name = ab\r\nset osd_warn_bitmask = 1\r\nfeature gps\r\n",
+			"set craft_name = bench quad\r\nset pilot_name = Ab\r\nset OSD_WARN_BITMASK = 1\r\nfeature GPS\r\n",
+			[]Change{
+				{Section: MasterSection, Key: "set craft_name", Kind: Changed, Old: "Bench Quad", New: "bench quad"},
+				{Section: MasterSection, Key: "set pilot_name", Kind: Changed, Old: "ab", New: "Ab"},
+			},
+		},
+		{
+			"repeated commands",
+			"set p_pitch = 40\r\nset p_pitch = 50\r\n",
+			"set p_pitch = 50\r\n",
+			nil,
+		},
+		{
+			"added, removed and changed",
+			"set p_pitch = 50\r\nset p_roll = 45\r\n",
+			"set p_roll = 46\r\nset d_roll = 30\r\n",
+			[]Change{
+				{Section: MasterSection, Key: "set p_pitch", Kind: Removed, Old: "50"},
+				{Section: MasterSection, Key: "set p_roll", Kind: Changed, Old: "45", New: "46"},
+				{Section: MasterSection, Key: "set d_roll", Kind: Added, New: "30"},
+			},
+		},
+		{
+			"feature on and off",
+			"feature -AIRMODE\r\nfeature GPS\r\n",
+			"feature AIRMODE\r\nfeature -GPS\r\n",
+			[]Change{
+				{Section: MasterSection, Key: "feature AIRMODE", Kind: Changed, Old: "feature -AIRMODE", New: "feature AIRMODE"},
+				{Section: MasterSection, Key: "feature GPS", Kind: Changed, Old: "feature GPS", New: "feature -GPS"},
+			},
+		},
+		{
+			"sections in order",
+			"rateprofile 0\r\nset roll_rc_rate = 7\r\nprofile 1\r\nset p_pitch = 50\r\n",
+			"profile 1\r\nset p_pitch = 52\r\nrateprofile 0\r\nset roll_rc_rate = 8\r\nprofile 0\r\n\r\n# master\r\n",
+			[]Change{
+				{Section: profile1, Key: "set p_pitch", Kind: Changed, Old: "50", New: "52"},
+				{Section: rateProfile0, Key: "set roll_rc_rate", Kind: Changed, Old: "7", New: "8"},
+			},
+		},
+		{
+			"same setting in different sections",
+			"set name = A\r\nprofile 1\r\nset p_pitch = 50\r\n",
+			"set name = B\r\nset p_pitch = 50\r\n",
+			[]Change{
+				{Section: MasterSection, Key: "set name", Kind: Changed, Old: "A", New: "B"},
+				{Section: MasterSection, Key: "set p_pitch", Kind: Added, New: "50"},
+				{Section: profile1, Key: "set p_pitch", Kind: Removed, Old: "50"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		changes := Compare(ParseString(tt.a), ParseString(tt.b))
+		for ii := range changes {
+			changes[ii].OldLine, changes[ii].NewLine = 0, 0
+		}
+		if !reflect.DeepEqual(changes, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, changes, tt.want)
+		}
+	}
+}
+
+func TestCompareLines(t *testing.T) {
+	changes := Compare(
+		ParseString("# diff\r\n\r\nset p_pitch = 50\r\n"),
+		ParseString("set p_roll = 1\r\nset p_pitch = 51\r\n"),
+	)
+	want := []Change{
+		{Section: MasterSection, Key: "set p_pitch", Kind: Changed, Old: "50", New: "51", OldLine: 3, NewLine: 2},
+		{Section: MasterSection, Key: "set p_roll", Kind: Added, New: "1", NewLine: 1},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("got %+v, want %+v", changes, want)
+	}
+}
@@ -0,0 +1,380 @@
+// Package config parses the output of the Betaflight CLI `diff` and `dump`
+// commands into a typed model that can be queried, edited and written back
+// out as CLI text.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SectionKind is the kind of section of a configuration a line is in.
+type SectionKind int
+
+const (
+	Master SectionKind = iota
+	Profile
+	RateProfile
+)
+
+// Section identifies the master section or one of the profile or rate
+// profile sections.
+type Section struct {
+	Kind  SectionKind
+	Index int
+}
+
+// MasterSection is the section holding everything outside the profiles.
+var MasterSection = Section{Kind: Master}
+
+func (s Section) String() string {
+	switch s.Kind {
+	case Profile:
+		return fmt.Sprintf("profile %d", s.Index)
+	case RateProfile:
+		return fmt.Sprintf("rateprofile %d", s.Index)
+	}
+	return "master"
+}
+
+// Command is a parsed CLI command. For `set` commands Args holds the setting
+// name and value.
+type Command struct {
+	Name string
+	Args []string
+}
+
+func (c *Command) String() string {
+	if c.Name == "set" && len(c.Args) == 2 {
+		return fmt.Sprintf("set %s = %s", c.Args[0], c.Args[1])
+	}
+	return strings.TrimSpace(c.Name + " " + strings.Join(c.Args, " "))
+}
+
+// keyArgs is the number of leading arguments that identify a line of
+// commands which can appear several times, e.g. 1 for `serial 0 ...`.
+var keyArgs = map[string]int{
+	"resource":   2,
+	"timer":      1,
+	"dma":        2,
+	"serial":     1,
+	"aux":        1,
+	"adjrange":   1,
+	"rxrange":    1,
+	"mmix":       1,
+	"smix":       1,
+	"led":        1,
+	"color":      1,
+	"mode_color": 2,
+	"rxfail":     1,
+	"vtxtable":   1,
+	"feature":    1,
+	"beacon":     1,
+	"beeper":     1,
+}
+
+// Key identifies what a command configures, so that two commands with the
+// same key in the same section set the same thing. For example the key of
+// `serial 0 64 115200 57600 0 115200` is "serial 0" and the key of
+// `feature -GPS` is "feature GPS".
+func (c *Command) Key() string {
+	if c.Name == "set" && len(c.Args) > 0 {
+		return "set " + strings.ToLower(c.Args[0])
+	}
+	n := keyArgs[c.Name]
+	if c.Name == "vtxtable" && len(c.Args) > 0 && c.Args[0] == "band" {
+		n = 2
+	}
+	if n > len(c.Args) {
+		n = len(c.Args)
+	}
+	key := []string{c.Name}
+	for _, a := range c.Args[:n] {
+		switch c.Name {
+		case "feature", "beacon", "beeper":
+			a = strings.TrimPrefix(a, "-")
+		}
+		key = append(key, strings.ToUpper(a))
+	}
+	return strings.Join(key, " ")
+}
+
+// Line is a line of the configuration, including blank and comment lines so
+// that the text can be reproduced exactly.
+type Line struct {
+	Text string
+	// Number is the 1-based line number in the parsed text, or 0 for lines
+	// added since.
+	Number  int
+	Section Section
+	// Command is nil for blank lines and comments
+	Command *Command
+}
+
+// Setting is the value given to a setting by a `set` command.
+type Setting struct {
+	Name  string
+	Value string
+	Line  *Line
+}
+
+// Config is a parsed diff or dump.
+type Config struct {
+	Header Header
+	Lines  []*Line
+
+	newline         string
+	trailingNewline bool
+}
+
+// Parse reads a diff or dump, as printed by the CLI or saved by the
+// Configurator.
+func Parse(r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBytes(data), nil
+}
+
+// ParseString parses a diff or dump held in a string.
+func ParseString(s string) *Config {
+	return ParseBytes([]byte(s))
+}
+
+// ParseBytes parses a diff or dump held in a byte slice.
+func ParseBytes(data []byte) *Config {
+	c := &Config{newline: "\n"}
+	if bytes.Contains(data, []byte("\r\n")) {
+		c.newline = "\r\n"
+	}
+	text := string(data)
+	if strings.HasSuffix(text, "\n") {
+		c.trailingNewline = true
+		text = strings.TrimSuffix(text, "\n")
+	}
+	if text == "" && !c.trailingNewline {
+		return c
+	}
+	section := MasterSection
+	for ii, raw := range strings.Split(text, "\n") {
+		raw = strings.TrimSuffix(raw, "\r")
+		l := &Line{Text: raw, Number: ii + 1}
+		l.Command = ParseCommand(raw)
+		if l.Command != nil {
+			switch l.Command.Name {
+			case "profile", "rateprofile":
+				if len(l.Command.Args) == 1 {
+					if n, err := strconv.Atoi(l.Command.Args[0]); err == nil {
+						section = Section{Kind: Profile, Index: n}
+						if l.Command.Name == "rateprofile" {
+							section.Kind = RateProfile
+						}
+					}
+				}
+			}
+		}
+		l.Section = section
+		c.Lines = append(c.Lines, l)
+	}
+	c.Header = parseHeader(c)
+	return c
+}
+
+// ParseCommand parses one line of CLI text. It returns nil for blank lines
+// and comments.
+func ParseCommand(line string) *Command {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	name, rest, _ := strings.Cut(line, " ")
+	name = strings.ToLower(name)
+	rest = strings.TrimSpace(rest)
+	if name == "set" {
+		settingName, value, found := strings.Cut(rest, "=")
+		if !found {
+			return &Command{Name: name, Args: strings.Fields(rest)}
+		}
+		return &Command{
+			Name: name,
+			Args: []string{strings.TrimSpace(settingName), strings.TrimSpace(value)},
+		}
+	}
+	return &Command{Name: name, Args: strings.Fields(rest)}
+}
+
+// String returns the configuration as CLI text. A parsed configuration that
+// hasn't been edited is returned exactly as it was read.
+func (c *Config) String() string {
+	var b strings.Builder
+	for ii, l := range c.Lines {
+		if ii > 0 {
+			b.WriteString(c.newline)
+		}
+		b.WriteString(l.Text)
+	}
+	if c.trailingNewline {
+		b.WriteString(c.newline)
+	}
+	return b.String()
+}
+
+// Commands returns the commands in a section, in order.
+func (c *Config) Commands(section Section) []*Command {
+	var cmds []*Command
+	for _, l := range c.Lines {
+		if l.Command != nil && l.Section == section {
+			cmds = append(cmds, l.Command)
+		}
+	}
+	return cmds
+}
+
+// commandsNamed returns the commands called name in the master section.
+func (c *Config) commandsNamed(name string) []*Command {
+	var cmds []*Command
+	for _, cmd := range c.Commands(MasterSection) {
+		if cmd.Name == name {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+// Sections returns every section that has any commands, in the order they
+// first appear.
+func (c *Config) Sections() []Section {
+	seen := make(map[Section]bool)
+	var sections []Section
+	for _, l := range c.Lines {
+		if l.Command == nil || seen[l.Section] {
+			continue
+		}
+		seen[l.Section] = true
+		sections = append(sections, l.Section)
+	}
+	return sections
+}
+
+// Settings returns the settings set in a section, in the order they are
+// first set. A setting set more than once has the last value given.
+func (c *Config) Settings(section Section) []Setting {
+	idx := make(map[string]int)
+	var settings []Setting
+	for _, l := range c.Lines {
+		if l.Section != section || l.Command == nil || l.Command.Name != "set" || len(l.Command.Args) != 2 {
+			continue
+		}
+		s := Setting{Name: strings.ToLower(l.Command.Args[0]), Value: l.Command.Args[1], Line: l}
+		if ii, ok := idx[s.Name]; ok {
+			settings[ii] = s
+			continue
+		}
+		idx[s.Name] = len(settings)
+		settings = append(settings, s)
+	}
+	return settings
+}
+
+// Get returns the value of a setting in a section.
+func (c *Config) Get(section Section, name string) (string, bool) {
+	l := c.findSetting(section, name)
+	if l == nil {
+		return "", false
+	}
+	return l.Command.Args[1], true
+}
+
+func (c *Config) findSetting(section Section, name string) *Line {
+	name = strings.ToLower(name)
+	for ii := len(c.Lines) - 1; ii >= 0; ii-- {
+		l := c.Lines[ii]
+		if l.Section == section && l.Command != nil && l.Command.Name == "set" &&
+			len(l.Command.Args) == 2 && strings.ToLower(l.Command.Args[0]) == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// Set changes the value of a setting in a section, adding a `set` command
+// after the section's other settings if it isn't set already.
+func (c *Config) Set(section Section, name, value string) {
+	if l := c.findSetting(section, name); l != nil {
+		l.Command.Args[1] = value
+		l.Text = l.Command.String()
+		return
+	}
+	cmd := &Command{Name: "set", Args: []string{name, value}}
+	c.insert(section, &Line{Text: cmd.String(), Section: section, Command: cmd})
+}
+
+// Unset removes every `set` command for a setting from a section.
+func (c *Config) Unset(section Section, name string) {
+	name = strings.ToLower(name)
+	lines := c.Lines[:0]
+	for _, l := range c.Lines {
+		if l.Section == section && l.Command != nil && l.Command.Name == "set" &&
+			len(l.Command.Args) == 2 && strings.ToLower(l.Command.Args[0]) == name {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	c.Lines = lines
+}
+
+// Rename changes the name of a setting wherever it is set in a section.
+func (c *Config) Rename(section Section, from, to string) {
+	from = strings.ToLower(from)
+	for _, l := range c.Lines {
+		if l.Section == section && l.Command != nil && l.Command.Name == "set" &&
+			len(l.Command.Args) == 2 && strings.ToLower(l.Command.Args[0]) == from {
+			l.Command.Args[0] = to
+			l.Text = l.Command.String()
+		}
+	}
+}
+
+// insert adds a line after the last command of its section, or at the end
+// of the section selection if the section has no commands yet. A section
+// which doesn't exist at all is added before the closing `save`.
+func (c *Config) insert(section Section, nl *Line) {
+	pos := -1
+	for ii, l := range c.Lines {
+		if l.Section != section || l.Command == nil {
+			continue
+		}
+		if l.Command.Name == "set" || pos == -1 {
+			pos = ii
+		}
+	}
+	if pos == -1 {
+		pos = len(c.Lines) - 1
+		for ii, l := range c.Lines {
+			if l.Command != nil && l.Command.Name == "save" {
+				pos = ii - 1
+				break
+			}
+		}
+		if section.Kind != Master {
+			sel := &Command{Name: "profile", Args: []string{strconv.Itoa(section.Index)}}
+			if section.Kind == RateProfile {
+				sel.Name = "rateprofile"
+			}
+			c.Lines = insertLine(c.Lines, pos+1, &Line{Text: sel.String(), Section: section, Command: sel})
+			pos++
+		}
+	}
+	c.Lines = insertLine(c.Lines, pos+1, nl)
+}
+
+func insertLine(lines []*Line, pos int, l *Line) []*Line {
+	lines = append(lines, nil)
+	copy(lines[pos+1:], lines[pos:])
+	lines[pos] = l
+	return lines
+}
@@ -0,0 +1,275 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+var fixtures = []string{
+	"BTFL_4.2.11_DIFF.txt",
+	"BTFL_4.3.2_DIFF.txt",
+	"BTFL_4.4.2_DUMP.txt",
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			data := string(readFixture(t, name))
+			lf := strings.ReplaceAll(data, "\r\n", "\n")
+			for _, text := range []string{
+				data,
+				lf,
+				strings.ReplaceAll(lf, "\n", "\r\n"),
+				strings.TrimSuffix(lf, "\n"),
+			} {
+				if got := ParseString(text).String(); got != text {
+					t.Errorf("got %q, want %q", head(got), head(text))
+				}
+			}
+		})
+	}
+}
+
+// head returns the start of long text for error messages.
+func head(s string) string {
+	if len(s) > 200 {
+		return s[:200] + "..."
+	}
+	return s
+}
+
+func TestHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		want Header
+	}{
+		{"BTFL_4.2.11_DIFF.txt", Header{
+			Firmware:        "Betaflight",
+			Target:          "STM32F405",
+			BoardIdentifier: "S405",
+			Version:         Version{4, 2, 11},
+			BuildDate:       "Nov  9 2021",
+			BuildTime:       "06:26:55",
+			GitRevision:     "948ba6339",
+			APIVersion:      "1.43",
+			BoardName:       "MATEKF405",
+			ManufacturerID:  "MTKS",
+			CraftName:       "Mob7",
+		}},
+		{"BTFL_4.3.2_DIFF.txt", Header{
+			Firmware:        "Betaflight",
+			Target:          "STM32F7X2",
+			BoardIdentifier: "S7X2",
+			Version:         Version{4, 3, 2},
+			BuildDate:       "Dec 14 2022",
+			BuildTime:       "12:07:05",
+			GitRevision:     "f0b7de5a2",
+			APIVersion:      "1.44",
+			BoardName:       "SPEEDYBEEF7V3",
+			ManufacturerID:  "SPBE",
+			MCUID:           "0026003b3136510735363636",
+			CraftName:       "Pavo 20",
+		}},
+		{"BTFL_4.4.2_DUMP.txt", Header{
+			Firmware:        "Betaflight",
+			Target:          "STM32F411",
+			BoardIdentifier: "S411",
+			Version:         Version{4, 4, 2},
+			BuildDate:       "Jun  9 2023",
+			BuildTime:       "02:04:19",
+			GitRevision:     "4c4d9a36c",
+			APIVersion:      "1.45",
+			BoardName:       "CRAZYBEEF4ELRS",
+			ManufacturerID:  "HAMO",
+			MCUID:           "0039001c3331510d38353530",
+			CraftName:       "Tiny Whoop",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := ParseBytes(readFixture(t, tt.name)).Header
+			if h != tt.want {
+				t.Errorf("got %+v, want %+v", h, tt.want)
+			}
+			if h.Variant() != "BTFL" {
+				t.Errorf("got variant %q", h.Variant())
+			}
+		})
+	}
+}
+
+func TestSections(t *testing.T) {
+	p := func(n int) Section { return Section{Profile, n} }
+	r := func(n int) Section { return Section{RateProfile, n} }
+	tests := []struct {
+		name     string
+		sections []Section
+	}{
+		{"BTFL_4.2.11_DIFF.txt", []Section{MasterSection, p(0), p(1), r(0), r(1)}},
+		{"BTFL_4.3.2_DIFF.txt", []Section{MasterSection, p(0), p(2), r(0)}},
+		{"BTFL_4.4.2_DUMP.txt", []Section{MasterSection, p(0), p(1), p(2), r(0), r(1), r(2), r(3)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ParseBytes(readFixture(t, tt.name))
+			if got := c.Sections(); !reflect.DeepEqual(got, tt.sections) {
+				t.Errorf("got sections %v, want %v", got, tt.sections)
+			}
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	p := func(n int) Section { return Section{Profile, n} }
+	r := func(n int) Section { return Section{RateProfile, n} }
+	tests := []struct {
+		file    string
+		section Section
+		name    string
+		want    string
+		found   bool
+	}{
+		{"BTFL_4.2.11_DIFF.txt", MasterSection, "dshot_bidir", "ON", true},
+		{"BTFL_4.2.11_DIFF.txt", MasterSection, "name", "Mob7", true},
+		{"BTFL_4.2.11_DIFF.txt", p(0), "p_pitch", "52", true},
+		{"BTFL_4.2.11_DIFF.txt", p(1), "P_PITCH", "60", true},
+		{"BTFL_4.2.11_DIFF.txt", p(1), "p_roll", "", false},
+		{"BTFL_4.2.11_DIFF.txt", MasterSection, "p_pitch", "", false},
+		{"BTFL_4.2.11_DIFF.txt", r(1), "roll_rc_rate", "10", true},
+		{"BTFL_4.2.11_DIFF.txt", r(0), "roll_rc_rate", "7", true},
+		{"BTFL_4.3.2_DIFF.txt", MasterSection, "craft_name", "Pavo 20", true},
+		{"BTFL_4.3.2_DIFF.txt", p(2), "simplified_pids_mode", "OFF", true},
+		{"BTFL_4.3.2_DIFF.txt", p(0), "rates_type", "", false},
+		{"BTFL_4.3.2_DIFF.txt", r(0), "throttle_limit_percent", "80", true},
+		{"BTFL_4.4.2_DUMP.txt", MasterSection, "acc_calibration", "-12,3,-40,1", true},
+		{"BTFL_4.4.2_DUMP.txt", p(0), "p_pitch", "70", true},
+		{"BTFL_4.4.2_DUMP.txt", p(1), "p_pitch", "47", true},
+		{"BTFL_4.4.2_DUMP.txt", r(1), "roll_srate", "80", true},
+		{"BTFL_4.4.2_DUMP.txt", r(3), "roll_srate", "67", true},
+		{"BTFL_4.4.2_DUMP.txt", r(3), "p_pitch", "", false},
+	}
+	for _, tt := range tests {
+		c := ParseBytes(readFixture(t, tt.file))
+		got, found := c.Get(tt.section, tt.name)
+		if got != tt.want || found != tt.found {
+			t.Errorf("%s: %s in %s is %q, %v, want %q, %v", tt.file, tt.name, tt.section, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestSelectionLinesBelongToTheirSection(t *testing.T) {
+	c := ParseBytes(readFixture(t, "BTFL_4.4.2_DUMP.txt"))
+	var selected []Section
+	for _, l := range c.Lines {
+		if l.Command == nil {
+			continue
+		}
+		switch l.Command.Name {
+		case "profile", "rateprofile":
+			if l.Section.String() != l.Command.String() {
+				t.Errorf("line %d %q is in %s", l.Number, l.Text, l.Section)
+			}
+			selected = append(selected, l.Section)
+		}
+	}
+	// Each profile is selected in turn, then the original selection is
+	// restored
+	p := func(n int) Section { return Section{Profile, n} }
+	r := func(n int) Section { return Section{RateProfile, n} }
+	want := []Section{p(0), p(1), p(2), p(0), r(0), r(1), r(2), r(3), r(1)}
+	if !reflect.DeepEqual(selected, want) {
+		t.Errorf("got selections %v, want %v", selected, want)
+	}
+	if got := c.Commands(MasterSection)[0]; got.Name != "batch" {
+		t.Errorf("first master command is %q", got)
+	}
+	if l := c.Lines[len(c.Lines)-1]; l.Command == nil || l.Command.Name != "save" || l.Section != (Section{RateProfile, 1}) {
+		t.Errorf("last line %q is in %s", l.Text, l.Section)
+	}
+}
+
+func TestTypedSections(t *testing.T) {
+	c42 := ParseBytes(readFixture(t, "BTFL_4.2.11_DIFF.txt"))
+	wantSerial := []SerialPort{
+		{Identifier: 1, FunctionMask: 64, MSPBaud: 115200, GPSBaud: 57600, TelemetryBaud: 0, BlackboxBaud: 115200},
+		{Identifier: 5, FunctionMask: 1, MSPBaud: 115200, GPSBaud: 57600, TelemetryBaud: 0, BlackboxBaud: 115200},
+	}
+	if got := c42.SerialPorts(); !reflect.DeepEqual(got, wantSerial) {
+		t.Errorf("got serial ports %+v", got)
+	}
+	wantFeatures := []Feature{
+		{"RX_PARALLEL_PWM", false}, {"SOFTSERIAL", true}, {"TELEMETRY", true}, {"LED_STRIP", true}, {"OSD", true},
+	}
+	if got := c42.Features(); !reflect.DeepEqual(got, wantFeatures) {
+		t.Errorf("got features %+v", got)
+	}
+
+	c43 := ParseBytes(readFixture(t, "BTFL_4.3.2_DIFF.txt"))
+	if got := c43.Aux(); len(got) != 5 || got[4] != (ModeRange{Index: 4, ModeID: 35, Channel: 2, Start: 1800, End: 2100}) {
+		t.Errorf("got aux %+v", got)
+	}
+	if got := c43.Timers(); !reflect.DeepEqual(got, []Timer{{"A08", "AF1"}}) {
+		t.Errorf("got timers %+v", got)
+	}
+	if got := len(c43.VtxTable()); got != 10 {
+		t.Errorf("got %d vtxtable lines", got)
+	}
+
+	c44 := ParseBytes(readFixture(t, "BTFL_4.4.2_DUMP.txt"))
+	if got := c44.Resources(); len(got) != 22 || got[1] != (Resource{"MOTOR", 1, "B10"}) {
+		t.Errorf("got resources %+v", got)
+	}
+	if got := c44.DMA(); len(got) != 5 || got[0] != (DMA{"ADC", "1", "0"}) {
+		t.Errorf("got DMA %+v", got)
+	}
+	if got := c44.Map(); got != "AETR1234" {
+		t.Errorf("got map %q", got)
+	}
+	if got, want := c44.Profiles(), []int{0, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got profiles %v", got)
+	}
+	if got, want := c44.RateProfiles(), []int{0, 1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got rate profiles %v", got)
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		line string
+		key  string
+	}{
+		{"set GYRO_LPF1_STATIC_HZ = 250", "set gyro_lpf1_static_hz"},
+		{"set acc_calibration = -12,3,-40,1", "set acc_calibration"},
+		{"serial 0 64 115200 57600 0 115200", "serial 0"},
+		{"feature -GPS", "feature GPS"},
+		{"feature GPS", "feature GPS"},
+		{"beacon -RX_LOST", "beacon RX_LOST"},
+		{"resource MOTOR 1 B04", "resource MOTOR 1"},
+		{"resource motor 1 none", "resource MOTOR 1"},
+		{"timer B04 AF2", "timer B04"},
+		{"dma pin B04 0", "dma PIN B04"},
+		{"aux 3 13 3 1800 2100 0 0", "aux 3"},
+		{"mode_color 6 1 10", "mode_color 6 1"},
+		{"vtxtable band 1 BOSCAM_A A FACTORY 5865 5845", "vtxtable BAND 1"},
+		{"vtxtable powerlevels 4", "vtxtable POWERLEVELS"},
+		{"mixer QUADX", "mixer"},
+		{"map AETR1234", "map"},
+	}
+	for _, tt := range tests {
+		if got := ParseCommand(tt.line).Key(); got != tt.key {
+			t.Errorf("key of %q is %q, want %q", tt.line, got, tt.key)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Document is a configuration in a structured form for other tools, which
+// can be encoded as JSON or YAML. Setting values are numbers where they are
+// numeric and strings otherwise.
+type Document struct {
+	Identity Identity `json:"identity" yaml:"identity"`
+	// Defaults is true if the configuration resets the FC to its defaults
+	// before applying the rest, as `diff all` does
+	Defaults    bool         `json:"defaults" yaml:"defaults"`
+	Features    []Feature    `json:"features,omitempty" yaml:"features,omitempty"`
+	SerialPorts []SerialPort `json:"serial_ports,omitempty" yaml:"serial_ports,omitempty"`
+	Modes       []ModeRange  `json:"modes,omitempty" yaml:"modes,omitempty"`
+	Resources   []Resource   `json:"resources,omitempty" yaml:"resources,omitempty"`
+	// Commands are the other master section commands, as CLI text
+	Commands     []string               `json:"commands,omitempty" yaml:"commands,omitempty"`
+	Settings     map[string]interface{} `json:"settings,omitempty" yaml:"settings,omitempty"`
+	Profiles     []ProfileDocument      `json:"profiles,omitempty" yaml:"profiles,omitempty"`
+	RateProfiles []ProfileDocument      `json:"rate_profiles,omitempty" yaml:"rate_profiles,omitempty"`
+	// ActiveProfile and ActiveRateProfile are the profiles selected at the
+	// end of the configuration, if any
+	ActiveProfile     *int `json:"active_profile,omitempty" yaml:"active_profile,omitempty"`
+	ActiveRateProfile *int `json:"active_rate_profile,omitempty" yaml:"active_rate_profile,omitempty"`
+}
+
+// Identity is the board and firmware a Document was taken from.
+type Identity struct {
+	Firmware        string `json:"firmware,omitempty" yaml:"firmware,omitempty"`
+	Variant         string `json:"variant,omitempty" yaml:"variant,omitempty"`
+	Version         string `json:"version,omitempty" yaml:"version,omitempty"`
+	Target          string `json:"target,omitempty" yaml:"target,omitempty"`
+	BoardIdentifier string `json:"board_identifier,omitempty" yaml:"board_identifier,omitempty"`
+	BuildDate       string `json:"build_date,omitempty" yaml:"build_date,omitempty"`
+	BuildTime       string `json:"build_time,omitempty" yaml:"build_time,omitempty"`
+	GitRevision     string `json:"git_revision,omitempty" yaml:"git_revision,omitempty"`
+	APIVersion      string `json:"api_version,omitempty" yaml:"api_version,omitempty"`
+	BoardName       string `json:"board_name,omitempty" yaml:"board_name,omitempty"`
+	ManufacturerID  string `json:"manufacturer_id,omitempty" yaml:"manufacturer_id,omitempty"`
+	MCUID           string `json:"mcu_id,omitempty" yaml:"mcu_id,omitempty"`
+	Signature       string `json:"signature,omitempty" yaml:"signature,omitempty"`
+	CraftName       string `json:"craft_name,omitempty" yaml:"craft_name,omitempty"`
+}
+
+// ProfileDocument holds the settings of a profile or rate profile.
+type ProfileDocument struct {
+	Index    int                    `json:"index" yaml:"index"`
+	Settings map[string]interface{} `json:"settings" yaml:"settings"`
+}
+
+// typedCommands are the commands a Document has fields for, and commands
+// which are implied by its structure, with a check of whether a line fits
+// them. Lines which don't fit are kept in Commands rather than dropped.
+var typedCommands = map[string]func(cmd *Command) bool{
+	"feature":         func(cmd *Command) bool { _, ok := parseToggle(cmd); return ok },
+	"serial":          func(cmd *Command) bool { _, ok := parseSerialPort(cmd); return ok },
+	"aux":             func(cmd *Command) bool { _, ok := parseModeRange(cmd); return ok },
+	"resource":        func(cmd *Command) bool { _, ok := parseResource(cmd); return ok },
+	"set":             func(cmd *Command) bool { return len(cmd.Args) == 2 },
+	"board_name":      always,
+	"manufacturer_id": always,
+	"mcu_id":          always,
+	"signature":       always,
+}
+
+func always(*Command) bool { return true }
+
+// Document returns the configuration in structured form.
+func (c *Config) Document() *Document {
+	h := c.Header
+	d := &Document{
+		Identity: Identity{
+			Firmware:        h.Firmware,
+			Variant:         h.Variant(),
+			Target:          h.Target,
+			BoardIdentifier: h.BoardIdentifier,
+			BuildDate:       h.BuildDate,
+			BuildTime:       h.BuildTime,
+			GitRevision:     h.GitRevision,
+			APIVersion:      h.APIVersion,
+			BoardName:       h.BoardName,
+			ManufacturerID:  h.ManufacturerID,
+			MCUID:           h.MCUID,
+			Signature:       h.Signature,
+			CraftName:       h.CraftName,
+		},
+		Features:    c.Features(),
+		SerialPorts: c.SerialPorts(),
+		Modes:       c.Aux(),
+		Resources:   c.Resources(),
+		Settings:    typedSettings(c.Settings(MasterSection)),
+	}
+	if !h.Version.IsZero() {
+		d.Identity.Version = h.Version.String()
+	}
+	for _, cmd := range c.Commands(MasterSection) {
+		switch {
+		case cmd.Name == "defaults":
+			d.Defaults = true
+		case ignoredCommands[cmd.Name]:
+		default:
+			if fits, ok := typedCommands[cmd.Name]; ok && fits(cmd) {
+				continue
+			}
+			d.Commands = append(d.Commands, cmd.String())
+		}
+	}
+	for _, s := range c.Sections() {
+		p := ProfileDocument{Index: s.Index, Settings: typedSettings(c.Settings(s))}
+		switch s.Kind {
+		case Profile:
+			d.Profiles = append(d.Profiles, p)
+		case RateProfile:
+			d.RateProfiles = append(d.RateProfiles, p)
+		}
+	}
+	// The selection at the end of a diff restores the active profiles
+	for _, l := range c.Lines {
+		if l.Command == nil || len(l.Command.Args) != 1 {
+			continue
+		}
+		n, err := strconv.Atoi(l.Command.Args[0])
+		if err != nil {
+			continue
+		}
+		switch l.Command.Name {
+		case "profile":
+			d.ActiveProfile = &n
+		case "rateprofile":
+			d.ActiveRateProfile = &n
+		}
+	}
+	return d
+}
+
+func typedSettings(settings []Setting) map[string]interface{} {
+	if len(settings) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{}, len(settings))
+	for _, s := range settings {
+		m[s.Name] = typedValue(s.Value)
+	}
+	return m
+}
+
+// typedValue returns a setting value as a number if it is one and the number
+// formats back to exactly the same text, so that values such as `007` or
+// `1.50` are kept as they are.
+func typedValue(v string) interface{} {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return v
+	}
+	// JSON decoders give numbers back as float64, so that is what has to
+	// format the same
+	if s, _ := formatValue(f); s != v {
+		return v
+	}
+	if f == math.Trunc(f) {
+		return int64(f)
+	}
+	return f
+}
+
+// formatValue formats a setting value decoded from JSON or YAML for the CLI.
+func formatValue(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case bool:
+		if x {
+			return "ON", nil
+		}
+		return "OFF", nil
+	case int:
+		return strconv.Itoa(x), nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case uint64:
+		return strconv.FormatUint(x, 10), nil
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1e15 {
+			return strconv.FormatInt(int64(x), 10), nil
+		}
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value %v of type %T", v, v)
+}
+
+// Config converts a Document back into CLI commands, in the order of a
+// `diff all`.
+func (d *Document) Config() (*Config, error) {
+	var lines []string
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+	addSettings := func(settings map[string]interface{}) error {
+		names := make([]string, 0, len(settings))
+		for name := range settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			v, err := formatValue(settings[name])
+			if err != nil {
+				return fmt.Errorf("setting %s: %w", name, err)
+			}
+			add("set %s = %s", name, v)
+		}
+		return nil
+	}
+
+	id := d.Identity
+	add("# version")
+	if id.Firmware != "" && id.Version != "" {
+		line := fmt.Sprintf("# %s / %s (%s) %s %s / %s (%s)", id.Firmware, id.Target, id.BoardIdentifier,
+			id.Version, id.BuildDate, id.BuildTime, id.GitRevision)
+		if id.APIVersion != "" {
+			line += " MSP API: " + id.APIVersion
+		}
+		add("%s", line)
+	}
+	if d.Defaults {
+		add("")
+		add("batch start")
+		add("defaults nosave")
+	}
+	add("")
+	if id.BoardName != "" {
+		add("board_name %s", id.BoardName)
+		add("manufacturer_id %s", id.ManufacturerID)
+		// As printed by diff all in the releases which have them, signature
+		// included even when empty
+		if id.MCUID != "" {
+			add("mcu_id %s", id.MCUID)
+			add("signature %s", id.Signature)
+		}
+	}
+	if id.CraftName != "" {
+		add("")
+		add("# name: %s", id.CraftName)
+	}
+	for _, r := range d.Resources {
+		add("resource %s %d %s", r.Name, r.Index, r.Pin)
+	}
+	for _, f := range d.Features {
+		if f.Enabled {
+			add("feature %s", f.Name)
+		} else {
+			add("feature -%s", f.Name)
+		}
+	}
+	for _, s := range d.SerialPorts {
+		add("serial %d %d %d %d %d %d", s.Identifier, s.FunctionMask, s.MSPBaud, s.GPSBaud, s.TelemetryBaud, s.BlackboxBaud)
+	}
+	for _, m := range d.Modes {
+		add("aux %d %d %d %d %d %d %d", m.Index, m.ModeID, m.Channel, m.Start, m.End, m.Logic, m.LinkedTo)
+	}
+	for _, cmd := range d.Commands {
+		add("%s", cmd)
+	}
+	add("")
+	add("# master")
+	if err := addSettings(d.Settings); err != nil {
+		return nil, err
+	}
+	for _, profiles := range []struct {
+		name string
+		list []ProfileDocument
+	}{{"profile", d.Profiles}, {"rateprofile", d.RateProfiles}} {
+		for _, p := range profiles.list {
+			add("")
+			add("%s %d", profiles.name, p.Index)
+			add("")
+			add("# %s %d", profiles.name, p.Index)
+			if err := addSettings(p.Settings); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if d.ActiveProfile != nil || d.ActiveRateProfile != nil {
+		add("")
+		add("# restore original profile selection")
+		if d.ActiveProfile != nil {
+			add("profile %d", *d.ActiveProfile)
+		}
+		if d.ActiveRateProfile != nil {
+			add("rateprofile %d", *d.ActiveRateProfile)
+		}
+	}
+	if d.Defaults {
+		// Ends the batch started above, so the FC applies it before saving
+		add("")
+		add("# end the command batch")
+		add("batch end")
+	}
+	add("")
+	add("# save configuration")
+	add("save")
+	return ParseString(strings.Join(lines, "\r\n") + "\r\n"), nil
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// oddLines are a configuration with values that look like numbers but
+// aren't written as Go would write them, and lines that don't fit the typed
+// fields of a Document.
+const oddLines = "# version\r\n" +
+	"# Betaflight / STM32F405 (S405) 4.5.0 Apr  1 2024 / 10:00:00 (c155f5830) MSP API: 1.46\r\n" +
+	"aux 0 0 0 1800 2100 0\r\n" +
+	"aux 1 1 1 1300 1700 0 0\r\n" +
+	"serial UART1 64 115200 57600 0 115200\r\n" +
+	"serial 5 1 115200 57600 0 115200\r\n" +
+	"feature\r\n" +
+	"feature -TELEMETRY\r\n" +
+	"resource MOTOR one B04\r\n" +
+	"set craft_name = 007\r\n" +
+	"set pilot_name = 1.50\r\n" +
+	"set acc_trim_roll = -0\r\n" +
+	"set osd_warn_bitmask = 1e3\r\n" +
+	"set gyro_lpf1_static_hz = 250\r\n" +
+	"profile 0\r\n" +
+	"set thr_expo = 0.25\r\n" +
+	"set p_pitch = 0050\r\n"
+
+func TestTypedValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  interface{}
+	}{
+		{"250", int64(250)},
+		{"-12", int64(-12)},
+		{"0.25", 0.25},
+		{"007", "007"},
+		{"1.50", "1.50"},
+		{"1.0", "1.0"},
+		{"-0", "-0"},
+		{"+5", "+5"},
+		{"1e3", "1e3"},
+		{"NaN", "NaN"},
+		{"Inf", "Inf"},
+		{"ON", "ON"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := typedValue(tt.value); got != tt.want {
+			t.Errorf("typedValue(%q) is %#v, want %#v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDocumentKeepsUntypedLines(t *testing.T) {
+	d := ParseString(oddLines).Document()
+	want := []string{
+		"aux 0 0 0 1800 2100 0",
+		"serial UART1 64 115200 57600 0 115200",
+		"feature",
+		"resource MOTOR one B04",
+	}
+	if !reflect.DeepEqual(d.Commands, want) {
+		t.Errorf("got commands %q, want %q", d.Commands, want)
+	}
+	if len(d.Modes) != 1 || len(d.SerialPorts) != 1 || len(d.Features) != 1 || len(d.Resources) != 0 {
+		t.Errorf("got modes %+v, serial ports %+v, features %+v and resources %+v", d.Modes, d.SerialPorts, d.Features, d.Resources)
+	}
+}
+
+func TestDocumentRoundTrip(t *testing.T) {
+	codecs := []struct {
+		name      string
+		marshal   func(interface{}) ([]byte, error)
+		unmarshal func([]byte, interface{}) error
+	}{
+		{"json", json.Marshal, json.Unmarshal},
+		{"yaml", yaml.Marshal, yaml.Unmarshal},
+	}
+	inputs := map[string]string{"odd lines": oddLines}
+	for _, name := range fixtures {
+		inputs[name] = string(readFixture(t, name))
+	}
+	for name, text := range inputs {
+		for _, codec := range codecs {
+			t.Run(name+"/"+codec.name, func(t *testing.T) {
+				c := ParseString(text)
+				data, err := codec.marshal(c.Document())
+				if err != nil {
+					t.Fatal(err)
+				}
+				var d Document
+				if err := codec.unmarshal(data, &d); err != nil {
+					t.Fatal(err)
+				}
+				back, err := d.Config()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, ch := range Compare(c, back) {
+					t.Errorf("%s %s %s: %q, %q", ch.Section, ch.Key, ch.Kind, ch.Old, ch.New)
+				}
+				if back.Header != c.Header {
+					t.Errorf("got header %+v, want %+v", back.Header, c.Header)
+				}
+			})
+		}
+	}
+}
+
+func TestDocumentBatch(t *testing.T) {
+	tests := []struct {
+		defaults bool
+		want     []string
+	}{
+		{false, []string{"save"}},
+		{true, []string{"batch start", "defaults nosave", "batch end", "save"}},
+	}
+	for _, tt := range tests {
+		c, err := (&Document{Defaults: tt.defaults}).Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, l := range c.Lines {
+			if l.Command != nil {
+				got = append(got, l.Command.String())
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("defaults %v: got commands %q, want %q", tt.defaults, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is a firmware version such as 4.4.2.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion parses a version of the form major.minor[.patch].
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	nums := make([]int, 3)
+	for ii, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		nums[ii] = n
+	}
+	return Version{nums[0], nums[1], nums[2]}, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// IsZero returns true if the version is unknown.
+func (v Version) IsZero() bool {
+	return v == Version{}
+}
+
+// Compare returns -1, 0 or 1 if v is older than, the same as or newer than o.
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Header is the identity of the board and firmware a configuration was
+// taken from.
+type Header struct {
+	// Firmware is the name in the version line, e.g. Betaflight
+	Firmware        string
+	Target          string
+	BoardIdentifier string
+	Version         Version
+	BuildDate       string
+	BuildTime       string
+	GitRevision     string
+	APIVersion      string
+	BoardName       string
+	ManufacturerID  string
+	MCUID           string
+	Signature       string
+	CraftName       string
+}
+
+// Variant returns the MSP FC variant identifier of the firmware, e.g. BTFL.
+func (h *Header) Variant() string {
+	switch strings.ToLower(h.Firmware) {
+	case "betaflight":
+		return "BTFL"
+	case "inav":
+		return "INAV"
+	case "cleanflight":
+		return "CLFL"
+	case "emuflight":
+		return "EMUF"
+	}
+	return ""
+}
+
+// # Betaflight / STM32F7X2 (S7X2) 4.4.2 Jun  9 2023 / 02:04:19 (4c4d9a36c) MSP API: 1.45
+var versionLineRe = regexp.MustCompile(`^#\s*(\S+) / (\S+) \((\S+)\) (\d+\.\d+\.\d+) (.+?) / (\S+) \((\w+)\)(?: MSP API: (\d+\.\d+))?`)
+
+// IsVersionLine returns true if line is the firmware version line printed by
+// the `version` command and at the top of a diff or dump.
+func IsVersionLine(line string) bool {
+	return versionLineRe.MatchString(strings.TrimSpace(line))
+}
+
+// ParseVersionLine parses the firmware version line into a Header.
+func ParseVersionLine(line string) (Header, bool) {
+	var h Header
+	m := versionLineRe.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return h, false
+	}
+	h.Firmware = m[1]
+	h.Target = m[2]
+	h.BoardIdentifier = m[3]
+	h.Version, _ = ParseVersion(m[4])
+	h.BuildDate = m[5]
+	h.BuildTime = m[6]
+	h.GitRevision = m[7]
+	h.APIVersion = m[8]
+	return h, true
+}
+
+func parseHeader(c *Config) Header {
+	var h Header
+	for _, l := range c.Lines {
+		text := strings.TrimSpace(l.Text)
+		if vh, ok := ParseVersionLine(text); ok && h.Firmware == "" {
+			h.Firmware = vh.Firmware
+			h.Target = vh.Target
+			h.BoardIdentifier = vh.BoardIdentifier
+			h.Version = vh.Version
+			h.BuildDate = vh.BuildDate
+			h.BuildTime = vh.BuildTime
+			h.GitRevision = vh.GitRevision
+			h.APIVersion = vh.APIVersion
+			continue
+		}
+		if name, ok := strings.CutPrefix(text, "# name:"); ok {
+			h.CraftName = strings.TrimSpace(name)
+			continue
+		}
+		if l.Command == nil || l.Section != MasterSection {
+			continue
+		}
+		arg := strings.Join(l.Command.Args, " ")
+		switch l.Command.Name {
+		case "board_name":
+			h.BoardName = arg
+		case "manufacturer_id":
+			h.ManufacturerID = arg
+		case "mcu_id":
+			h.MCUID = arg
+		case "signature":
+			h.Signature = arg
+		case "set":
+			if len(l.Command.Args) == 2 && strings.EqualFold(l.Command.Args[0], "craft_name") {
+				h.CraftName = l.Command.Args[1]
+			}
+		}
+	}
+	return h
+}
+
+// SetVersion changes the firmware version in the version line, e.g. after
+// migrating the configuration to a new release.
+func (c *Config) SetVersion(v Version) {
+	for _, l := range c.Lines {
+		m := versionLineRe.FindStringSubmatchIndex(strings.TrimSpace(l.Text))
+		if m == nil {
+			continue
+		}
+		// Offset of the trimmed text in the line
+		off := strings.Index(l.Text, strings.TrimSpace(l.Text))
+		l.Text = l.Text[:off+m[8]] + v.String() + l.Text[off+m[9]:]
+	}
+	c.Header.Version = v
+}
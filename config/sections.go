@@ -0,0 +1,435 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Resource assigns a pin to a peripheral, e.g. `resource MOTOR 1 B04`.
+type Resource struct {
+	Name  string `json:"name" yaml:"name"`
+	Index int    `json:"index" yaml:"index"`
+	Pin   string `json:"pin" yaml:"pin"`
+}
+
+// Timer selects the timer for a pin, e.g. `timer B04 AF2`.
+type Timer struct {
+	Pin      string `json:"pin" yaml:"pin"`
+	Function string `json:"function" yaml:"function"`
+}
+
+// DMA assigns a DMA stream, e.g. `dma ADC 1 1` or `dma pin B04 0`.
+type DMA struct {
+	Device string `json:"device" yaml:"device"`
+	Index  string `json:"index" yaml:"index"`
+	Option string `json:"option" yaml:"option"`
+}
+
+// Feature is a `feature` or `beacon` toggle.
+type Feature struct {
+	Name    string `json:"name" yaml:"name"`
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+}
+
+// SerialPort configures a UART, e.g. `serial 0 64 115200 57600 0 115200`.
+type SerialPort struct {
+	Identifier    int    `json:"identifier" yaml:"identifier"`
+	FunctionMask  uint32 `json:"function_mask" yaml:"function_mask"`
+	MSPBaud       int    `json:"msp_baud" yaml:"msp_baud"`
+	GPSBaud       int    `json:"gps_baud" yaml:"gps_baud"`
+	TelemetryBaud int    `json:"telemetry_baud" yaml:"telemetry_baud"`
+	BlackboxBaud  int    `json:"blackbox_baud" yaml:"blackbox_baud"`
+}
+
+// ModeRange activates a flight mode from an AUX channel range, e.g.
+// `aux 0 0 0 1800 2100 0 0`.
+type ModeRange struct {
+	Index    int `json:"index" yaml:"index"`
+	ModeID   int `json:"mode_id" yaml:"mode_id"`
+	Channel  int `json:"channel" yaml:"channel"`
+	Start    int `json:"start" yaml:"start"`
+	End      int `json:"end" yaml:"end"`
+	Logic    int `json:"logic" yaml:"logic"`
+	LinkedTo int `json:"linked_to" yaml:"linked_to"`
+}
+
+// AdjustmentRange adjusts a setting in flight from an AUX channel, e.g.
+// `adjrange 0 0 1 900 2100 12 1 0 0`.
+type AdjustmentRange struct {
+	Index         int `json:"index" yaml:"index"`
+	Channel       int `json:"channel" yaml:"channel"`
+	Start         int `json:"start" yaml:"start"`
+	End           int `json:"end" yaml:"end"`
+	Function      int `json:"function" yaml:"function"`
+	SwitchChannel int `json:"switch_channel" yaml:"switch_channel"`
+	Center        int `json:"center" yaml:"center"`
+	Scale         int `json:"scale" yaml:"scale"`
+}
+
+// RxRange calibrates the range of an RC channel, e.g. `rxrange 0 1000 2000`.
+type RxRange struct {
+	Index int `json:"index" yaml:"index"`
+	Min   int `json:"min" yaml:"min"`
+	Max   int `json:"max" yaml:"max"`
+}
+
+// MotorMix is a custom motor mixer rule, e.g.
+// `mmix 0 1.000 -1.000 1.000 -1.000`.
+type MotorMix struct {
+	Index    int     `json:"index" yaml:"index"`
+	Throttle float64 `json:"throttle" yaml:"throttle"`
+	Roll     float64 `json:"roll" yaml:"roll"`
+	Pitch    float64 `json:"pitch" yaml:"pitch"`
+	Yaw      float64 `json:"yaw" yaml:"yaw"`
+}
+
+// ServoMix is a custom servo mixer rule, e.g. `smix 0 3 2 100 0 0 100 0`.
+type ServoMix struct {
+	Index  int `json:"index" yaml:"index"`
+	Target int `json:"target" yaml:"target"`
+	Input  int `json:"input" yaml:"input"`
+	Rate   int `json:"rate" yaml:"rate"`
+	Speed  int `json:"speed" yaml:"speed"`
+	Min    int `json:"min" yaml:"min"`
+	Max    int `json:"max" yaml:"max"`
+	Box    int `json:"box" yaml:"box"`
+}
+
+// LED configures one LED of the strip, e.g. `led 0 0,0::C:0`.
+type LED struct {
+	Index  int    `json:"index" yaml:"index"`
+	Config string `json:"config" yaml:"config"`
+}
+
+// Color is an entry of the LED strip color table, e.g. `color 1 0,255,255`.
+type Color struct {
+	Index      int `json:"index" yaml:"index"`
+	Hue        int `json:"hue" yaml:"hue"`
+	Saturation int `json:"saturation" yaml:"saturation"`
+	Value      int `json:"value" yaml:"value"`
+}
+
+// ModeColor assigns a color to a flight mode direction, e.g.
+// `mode_color 0 0 1`.
+type ModeColor struct {
+	Mode     int `json:"mode" yaml:"mode"`
+	Function int `json:"function" yaml:"function"`
+	Color    int `json:"color" yaml:"color"`
+}
+
+// ints parses args as integers, returning false if there are fewer than n
+// or any of the first n aren't integers.
+func ints(args []string, n int) ([]int, bool) {
+	if len(args) < n {
+		return nil, false
+	}
+	out := make([]int, n)
+	for ii := range out {
+		v, err := strconv.Atoi(args[ii])
+		if err != nil {
+			return nil, false
+		}
+		out[ii] = v
+	}
+	return out, true
+}
+
+// Resources returns the `resource` assignments. Malformed lines are skipped
+// by this and the other section accessors.
+func (c *Config) Resources() []Resource {
+	var out []Resource
+	for _, cmd := range c.commandsNamed("resource") {
+		if r, ok := parseResource(cmd); ok {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func parseResource(cmd *Command) (Resource, bool) {
+	if len(cmd.Args) != 3 {
+		return Resource{}, false
+	}
+	idx, err := strconv.Atoi(cmd.Args[1])
+	if err != nil {
+		return Resource{}, false
+	}
+	return Resource{Name: cmd.Args[0], Index: idx, Pin: cmd.Args[2]}, true
+}
+
+// Timers returns the `timer` assignments.
+func (c *Config) Timers() []Timer {
+	var out []Timer
+	for _, cmd := range c.commandsNamed("timer") {
+		if len(cmd.Args) < 2 {
+			continue
+		}
+		out = append(out, Timer{Pin: cmd.Args[0], Function: strings.Join(cmd.Args[1:], " ")})
+	}
+	return out
+}
+
+// DMA returns the `dma` assignments.
+func (c *Config) DMA() []DMA {
+	var out []DMA
+	for _, cmd := range c.commandsNamed("dma") {
+		if len(cmd.Args) != 3 {
+			continue
+		}
+		out = append(out, DMA{Device: cmd.Args[0], Index: cmd.Args[1], Option: cmd.Args[2]})
+	}
+	return out
+}
+
+func toggles(cmds []*Command) []Feature {
+	var out []Feature
+	for _, cmd := range cmds {
+		if f, ok := parseToggle(cmd); ok {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func parseToggle(cmd *Command) (Feature, bool) {
+	if len(cmd.Args) != 1 {
+		return Feature{}, false
+	}
+	name := cmd.Args[0]
+	return Feature{
+		Name:    strings.TrimPrefix(name, "-"),
+		Enabled: !strings.HasPrefix(name, "-"),
+	}, true
+}
+
+// Features returns the `feature` lines, in order.
+func (c *Config) Features() []Feature {
+	return toggles(c.commandsNamed("feature"))
+}
+
+// Beacons returns the `beacon` lines, in order.
+func (c *Config) Beacons() []Feature {
+	return toggles(c.commandsNamed("beacon"))
+}
+
+// SerialPorts returns the `serial` port configurations.
+func (c *Config) SerialPorts() []SerialPort {
+	var out []SerialPort
+	for _, cmd := range c.commandsNamed("serial") {
+		if p, ok := parseSerialPort(cmd); ok {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func parseSerialPort(cmd *Command) (SerialPort, bool) {
+	v, ok := ints(cmd.Args, 6)
+	if !ok || len(cmd.Args) != 6 {
+		return SerialPort{}, false
+	}
+	return SerialPort{
+		Identifier:    v[0],
+		FunctionMask:  uint32(v[1]),
+		MSPBaud:       v[2],
+		GPSBaud:       v[3],
+		TelemetryBaud: v[4],
+		BlackboxBaud:  v[5],
+	}, true
+}
+
+// Aux returns the `aux` mode ranges.
+func (c *Config) Aux() []ModeRange {
+	var out []ModeRange
+	for _, cmd := range c.commandsNamed("aux") {
+		if m, ok := parseModeRange(cmd); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func parseModeRange(cmd *Command) (ModeRange, bool) {
+	v, ok := ints(cmd.Args, 7)
+	if !ok || len(cmd.Args) != 7 {
+		return ModeRange{}, false
+	}
+	return ModeRange{
+		Index:    v[0],
+		ModeID:   v[1],
+		Channel:  v[2],
+		Start:    v[3],
+		End:      v[4],
+		Logic:    v[5],
+		LinkedTo: v[6],
+	}, true
+}
+
+// AdjustmentRanges returns the `adjrange` lines.
+func (c *Config) AdjustmentRanges() []AdjustmentRange {
+	var out []AdjustmentRange
+	for _, cmd := range c.commandsNamed("adjrange") {
+		v, ok := ints(cmd.Args, 9)
+		if !ok {
+			continue
+		}
+		// v[1] is the unused slot index
+		out = append(out, AdjustmentRange{
+			Index:         v[0],
+			Channel:       v[2],
+			Start:         v[3],
+			End:           v[4],
+			Function:      v[5],
+			SwitchChannel: v[6],
+			Center:        v[7],
+			Scale:         v[8],
+		})
+	}
+	return out
+}
+
+// RxRanges returns the `rxrange` lines.
+func (c *Config) RxRanges() []RxRange {
+	var out []RxRange
+	for _, cmd := range c.commandsNamed("rxrange") {
+		v, ok := ints(cmd.Args, 3)
+		if !ok {
+			continue
+		}
+		out = append(out, RxRange{Index: v[0], Min: v[1], Max: v[2]})
+	}
+	return out
+}
+
+// VtxTable returns the `vtxtable` commands, which vary too much in shape to
+// be worth a type of their own.
+func (c *Config) VtxTable() []*Command {
+	return c.commandsNamed("vtxtable")
+}
+
+// MotorMixer returns the `mmix` rules.
+func (c *Config) MotorMixer() []MotorMix {
+	var out []MotorMix
+	for _, cmd := range c.commandsNamed("mmix") {
+		if len(cmd.Args) != 5 {
+			continue
+		}
+		idx, err := strconv.Atoi(cmd.Args[0])
+		if err != nil {
+			continue
+		}
+		var f [4]float64
+		valid := true
+		for ii := range f {
+			if f[ii], err = strconv.ParseFloat(cmd.Args[ii+1], 64); err != nil {
+				valid = false
+			}
+		}
+		if valid {
+			out = append(out, MotorMix{Index: idx, Throttle: f[0], Roll: f[1], Pitch: f[2], Yaw: f[3]})
+		}
+	}
+	return out
+}
+
+// ServoMixer returns the `smix` rules.
+func (c *Config) ServoMixer() []ServoMix {
+	var out []ServoMix
+	for _, cmd := range c.commandsNamed("smix") {
+		v, ok := ints(cmd.Args, 8)
+		if !ok {
+			continue
+		}
+		out = append(out, ServoMix{
+			Index:  v[0],
+			Target: v[1],
+			Input:  v[2],
+			Rate:   v[3],
+			Speed:  v[4],
+			Min:    v[5],
+			Max:    v[6],
+			Box:    v[7],
+		})
+	}
+	return out
+}
+
+// Map returns the RC channel order set with `map`, or "" if there isn't one.
+func (c *Config) Map() string {
+	m := ""
+	for _, cmd := range c.commandsNamed("map") {
+		if len(cmd.Args) == 1 {
+			m = cmd.Args[0]
+		}
+	}
+	return m
+}
+
+// LEDs returns the `led` strip configuration.
+func (c *Config) LEDs() []LED {
+	var out []LED
+	for _, cmd := range c.commandsNamed("led") {
+		if len(cmd.Args) != 2 {
+			continue
+		}
+		idx, err := strconv.Atoi(cmd.Args[0])
+		if err != nil {
+			continue
+		}
+		out = append(out, LED{Index: idx, Config: cmd.Args[1]})
+	}
+	return out
+}
+
+// Colors returns the `color` table.
+func (c *Config) Colors() []Color {
+	var out []Color
+	for _, cmd := range c.commandsNamed("color") {
+		if len(cmd.Args) != 2 {
+			continue
+		}
+		idx, err := strconv.Atoi(cmd.Args[0])
+		if err != nil {
+			continue
+		}
+		hsv, ok := ints(strings.Split(cmd.Args[1], ","), 3)
+		if !ok {
+			continue
+		}
+		out = append(out, Color{Index: idx, Hue: hsv[0], Saturation: hsv[1], Value: hsv[2]})
+	}
+	return out
+}
+
+// ModeColors returns the `mode_color` assignments.
+func (c *Config) ModeColors() []ModeColor {
+	var out []ModeColor
+	for _, cmd := range c.commandsNamed("mode_color") {
+		v, ok := ints(cmd.Args, 3)
+		if !ok {
+			continue
+		}
+		out = append(out, ModeColor{Mode: v[0], Function: v[1], Color: v[2]})
+	}
+	return out
+}
+
+// Profiles returns the indexes of the profile sections, in order.
+func (c *Config) Profiles() []int {
+	return c.sectionIndexes(Profile)
+}
+
+// RateProfiles returns the indexes of the rate profile sections, in order.
+func (c *Config) RateProfiles() []int {
+	return c.sectionIndexes(RateProfile)
+}
+
+func (c *Config) sectionIndexes(kind SectionKind) []int {
+	var out []int
+	for _, s := range c.Sections() {
+		if s.Kind == kind {
+			out = append(out, s.Index)
+		}
+	}
+	return out
+}
@@ -0,0 +1,223 @@
+// Package exporter keeps flight controllers connected, polls their state and
+// exposes it as Prometheus metrics.
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/robhaswell/btflcli/msp"
+)
+
+const (
+	// requestTimeout is how long to wait for each reply before counting it
+	// as dropped
+	requestTimeout = 500 * time.Millisecond
+	// maxSilentPolls is the number of polls in a row without any reply
+	// after which the board is reconnected
+	maxSilentPolls = 3
+)
+
+// messages are polled from every board.
+var messages = []uint16{msp.MspStatusEx, msp.MspAnalog, msp.MspAttitude, msp.MspBatteryState}
+
+// Options configure an Exporter.
+type Options struct {
+	BaudRate    int
+	PreferMSPV2 bool
+	// Interval is the time between polls of each board
+	Interval time.Duration
+	// RetryInterval is the time to wait before reconnecting to a board
+	RetryInterval time.Duration
+	// Logger reports connections and errors. It defaults to the standard
+	// logger.
+	Logger *log.Logger
+}
+
+// Exporter polls the flight controllers on a set of ports. It implements
+// prometheus.Collector.
+type Exporter struct {
+	opts    Options
+	targets []*target
+}
+
+// target is the state of the board on one port.
+type target struct {
+	port string
+
+	mu        sync.Mutex
+	up        bool
+	telemetry *fc.Telemetry
+	// m is the current connection, whose frame errors are added to the
+	// totals of the earlier ones
+	m           *msp.MSP
+	frameErrors msp.FrameErrors
+	dropped     uint64
+	connections uint64
+	// labels identify the board last connected, and are kept while it is
+	// disconnected
+	labels []string
+}
+
+// New returns an exporter for the boards on the given ports.
+func New(ports []string, opts Options) *Exporter {
+	if opts.Logger == nil {
+		opts.Logger = log.Default()
+	}
+	e := &Exporter{opts: opts}
+	for _, port := range ports {
+		e.targets = append(e.targets, &target{
+			port:   port,
+			labels: []string{"", "", "", "", port},
+		})
+	}
+	return e
+}
+
+// Run polls every board from its own goroutine until ctx is done,
+// reconnecting to boards which stop answering.
+func (e *Exporter) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range e.targets {
+		wg.Add(1)
+		go func(t *target) {
+			defer wg.Done()
+			e.watch(ctx, t)
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (e *Exporter) watch(ctx context.Context, t *target) {
+	for {
+		err := e.poll(ctx, t)
+		t.disconnected()
+		if ctx.Err() != nil {
+			return
+		}
+		e.opts.Logger.Printf("%s: %v, retrying in %s", t.port, err, e.opts.RetryInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.opts.RetryInterval):
+		}
+	}
+}
+
+// poll connects to the board on t's port and polls it until the connection
+// fails or ctx is done.
+func (e *Exporter) poll(ctx context.Context, t *target) error {
+	board, err := fc.NewFC(fc.FCOptions{
+		PortName:    t.port,
+		BaudRate:    e.opts.BaudRate,
+		PreferMSPV2: e.opts.PreferMSPV2,
+		Stdout:      io.Discard,
+	})
+	if err != nil {
+		return err
+	}
+	defer board.Close()
+	id, err := board.Identity()
+	if err != nil && !msp.IsReplyError(err) {
+		return err
+	}
+	board.SetRequestTimeout(requestTimeout)
+	t.connected(board, id)
+	e.opts.Logger.Printf("%s: connected to %s %d.%d.%d (%s)", t.port,
+		board.Variant, board.VersionMajor, board.VersionMinor, board.VersionPatch, board.Name)
+
+	poller := board.NewPoller(messages...)
+	ticker := time.NewTicker(e.opts.Interval)
+	defer ticker.Stop()
+	silent := 0
+	for {
+		tm, err := poller.Poll()
+		if err != nil {
+			return err
+		}
+		if tm.Dropped > 0 && tm.Status == nil && tm.Analog == nil && tm.Attitude == nil && tm.Battery == nil {
+			silent++
+		} else {
+			silent = 0
+		}
+		if silent == maxSilentPolls {
+			return errors.New("flight controller stopped answering")
+		}
+		t.update(tm)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *target) connected(board *fc.FC, id *fc.Identity) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.up = true
+	t.m = board.MSP()
+	t.connections++
+	var uid string
+	if id != nil {
+		uid = id.MCUID
+	}
+	version := fmt.Sprintf("%d.%d.%d", board.VersionMajor, board.VersionMinor, board.VersionPatch)
+	t.labels = []string{board.Name, board.Variant, version, uid, t.port}
+}
+
+func (t *target) update(tm *fc.Telemetry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.telemetry = tm
+	t.dropped += uint64(tm.Dropped)
+}
+
+func (t *target) disconnected() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.m != nil {
+		errs := t.m.FrameErrors()
+		t.frameErrors.Checksum += errs.Checksum
+		t.frameErrors.OutOfBand += errs.OutOfBand
+	}
+	t.up = false
+	t.m = nil
+	t.telemetry = nil
+}
+
+// snapshot is a copy of a target's state to build metrics from.
+type snapshot struct {
+	up          bool
+	labels      []string
+	telemetry   *fc.Telemetry
+	frameErrors msp.FrameErrors
+	dropped     uint64
+	connections uint64
+}
+
+func (t *target) snapshot() snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := snapshot{
+		up:          t.up,
+		labels:      t.labels,
+		telemetry:   t.telemetry,
+		frameErrors: t.frameErrors,
+		dropped:     t.dropped,
+		connections: t.connections,
+	}
+	if t.m != nil {
+		errs := t.m.FrameErrors()
+		s.frameErrors.Checksum += errs.Checksum
+		s.frameErrors.OutOfBand += errs.OutOfBand
+	}
+	return s
+}
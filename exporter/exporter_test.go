@@ -0,0 +1,203 @@
+package exporter_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/robhaswell/btflcli/exporter"
+	"github.com/robhaswell/btflcli/fcsim"
+	"github.com/robhaswell/btflcli/msp"
+)
+
+// testSim is the simulator that exptest:// ports connect to.
+var testSim *fcsim.Sim
+
+// serverConns receives the simulator's end of each connection, so that a
+// test can break it.
+var serverConns chan net.Conn
+
+// noise is sent ahead of the simulator's replies on every connection: four
+// bytes outside of a frame and a frame with a bad checksum.
+var noise = func() []byte {
+	frame := msp.EncodeReply(msp.MspStatus, []byte{7}, false)
+	frame[len(frame)-1] ^= 0xff
+	return append([]byte("junk"), frame...)
+}()
+
+func init() {
+	msp.RegisterScheme("exptest", func(u *url.URL, baudRate int) (msp.Transport, error) {
+		client, server := net.Pipe()
+		serverConns <- server
+		go func() {
+			defer server.Close()
+			if _, err := server.Write(noise); err != nil {
+				return
+			}
+			testSim.Serve(server)
+		}()
+		return msp.NewTransport(client), nil
+	})
+}
+
+// metrics are gathered metrics by name.
+type metrics map[string][]*dto.Metric
+
+func gather(t *testing.T, reg *prometheus.Registry) metrics {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := metrics{}
+	for _, f := range families {
+		m[f.GetName()] = f.GetMetric()
+	}
+	return m
+}
+
+// value returns the value of the metric with the given extra label values,
+// and whether there is one.
+func (m metrics) value(name string, labels ...string) (float64, bool) {
+	for _, metric := range m[name] {
+		if !hasLabels(metric, labels) {
+			continue
+		}
+		switch {
+		case metric.Gauge != nil:
+			return metric.Gauge.GetValue(), true
+		case metric.Counter != nil:
+			return metric.Counter.GetValue(), true
+		}
+	}
+	return 0, false
+}
+
+// hasLabels reports whether a metric has the given name=value labels.
+func hasLabels(metric *dto.Metric, labels []string) bool {
+	for _, l := range labels {
+		name, value, _ := strings.Cut(l, "=")
+		found := false
+		for _, p := range metric.GetLabel() {
+			found = found || p.GetName() == name && p.GetValue() == value
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// waitFor gathers metrics until cond is true of them.
+func waitFor(t *testing.T, reg *prometheus.Registry, what string, cond func(metrics) bool) metrics {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m := gather(t, reg)
+		if cond(m) {
+			return m
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExporter(t *testing.T) {
+	testSim = fcsim.New()
+	serverConns = make(chan net.Conn, 10)
+	if err := testSim.Set("craft_name", "Bench Quad"); err != nil {
+		t.Fatal(err)
+	}
+	e := exporter.New([]string{"exptest://quad"}, exporter.Options{
+		Interval:      10 * time.Millisecond,
+		RetryInterval: 200 * time.Millisecond,
+		Logger:        log.New(io.Discard, "", 0),
+	})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	up := func(want float64) func(metrics) bool {
+		return func(m metrics) bool {
+			v, ok := m.value("btfl_up")
+			_, polled := m.value("btfl_attitude_degrees", "axis=roll")
+			return ok && v == want && polled == (want == 1)
+		}
+	}
+	m := waitFor(t, reg, "the first poll", up(1))
+	board := []string{
+		"craft_name=Bench Quad", "variant=BTFL", "version=4.5.0",
+		"uid=" + testSim.MCUID(), "port=exptest://quad",
+	}
+	for _, tt := range []struct {
+		name   string
+		labels []string
+		want   float64
+	}{
+		{"btfl_up", board, 1},
+		{"btfl_connections_total", board, 1},
+		{"btfl_frame_out_of_band_bytes_total", board, 4},
+		{"btfl_frame_checksum_errors_total", board, 1},
+		{"btfl_replies_dropped_total", board, 0},
+		{"btfl_profile", board, 0},
+		{"btfl_battery_cells", board, 4},
+	} {
+		if got, ok := m.value(tt.name, tt.labels...); !ok || got != tt.want {
+			t.Errorf("%s%v is %v, %v, want %v", tt.name, tt.labels, got, ok, tt.want)
+		}
+	}
+	for _, name := range []string{"btfl_cycle_time_seconds", "btfl_cpu_load_ratio", "btfl_battery_voltage_volts", "btfl_rssi_ratio"} {
+		if _, ok := m.value(name, board...); !ok {
+			t.Errorf("no %s", name)
+		}
+	}
+	for _, axis := range []string{"roll", "pitch", "heading"} {
+		if _, ok := m.value("btfl_attitude_degrees", append(board, "axis="+axis)...); !ok {
+			t.Errorf("no %s attitude", axis)
+		}
+	}
+
+	// A broken connection takes the board down, leaving out its readings
+	// but keeping its labels
+	(<-serverConns).Close()
+	m = waitFor(t, reg, "the board to go down", up(0))
+	if _, ok := m.value("btfl_up", board...); !ok {
+		t.Error("the board's labels weren't kept")
+	}
+	if _, ok := m.value("btfl_battery_voltage_volts"); ok {
+		t.Error("readings are left from before the disconnection")
+	}
+
+	// Frame errors carry over into the next connection
+	m = waitFor(t, reg, "the board to reconnect", up(1))
+	for _, tt := range []struct {
+		name string
+		want float64
+	}{
+		{"btfl_connections_total", 2},
+		{"btfl_frame_out_of_band_bytes_total", 8},
+		{"btfl_frame_checksum_errors_total", 2},
+	} {
+		if got, ok := m.value(tt.name, board...); !ok || got != tt.want {
+			t.Errorf("%s is %v, %v, want %v", tt.name, got, ok, tt.want)
+		}
+	}
+}
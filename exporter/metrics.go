@@ -0,0 +1,120 @@
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// boardLabels identify the board on each port.
+var boardLabels = []string{"craft_name", "variant", "version", "uid", "port"}
+
+func newDesc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc("btfl_"+name, help, append(boardLabels[:len(boardLabels):len(boardLabels)], labels...), nil)
+}
+
+var (
+	upDesc             = newDesc("up", "Whether the flight controller is connected.")
+	connectionsDesc    = newDesc("connections_total", "Connections made to the flight controller.")
+	checksumErrorsDesc = newDesc("frame_checksum_errors_total", "MSP frames received with a bad checksum.")
+	outOfBandDesc      = newDesc("frame_out_of_band_bytes_total", "Bytes received outside of an MSP frame.")
+	droppedDesc        = newDesc("replies_dropped_total", "MSP replies lost or corrupted.")
+	cycleTimeDesc      = newDesc("cycle_time_seconds", "Time taken by the PID loop.")
+	cpuLoadDesc        = newDesc("cpu_load_ratio", "Average system load.")
+	i2cErrorsDesc      = newDesc("i2c_errors_total", "I2C errors since the flight controller started.")
+	profileDesc        = newDesc("profile", "Selected PID profile.")
+	rateProfileDesc    = newDesc("rate_profile", "Selected rate profile.")
+	rebootRequiredDesc = newDesc("reboot_required", "Whether the flight controller must reboot to apply changes.")
+	armingDisabledDesc = newDesc("arming_disabled", "Reasons the flight controller won't arm.", "reason")
+	flightModeDesc     = newDesc("flight_mode", "Active flight modes.", "mode")
+	attitudeDesc       = newDesc("attitude_degrees", "Estimated attitude of the craft.", "axis")
+	voltageDesc        = newDesc("battery_voltage_volts", "Battery voltage.")
+	currentDesc        = newDesc("battery_current_amperes", "Current drawn from the battery.")
+	drawnDesc          = newDesc("battery_drawn_milliamphours", "Charge drawn from the battery.")
+	cellsDesc          = newDesc("battery_cells", "Number of cells detected in the battery.")
+	capacityDesc       = newDesc("battery_capacity_milliamphours", "Configured battery capacity.")
+	batteryStateDesc   = newDesc("battery_state", "State of the battery.", "state")
+	rssiDesc           = newDesc("rssi_ratio", "Received signal strength.")
+	allDescs           = []*prometheus.Desc{
+		upDesc, connectionsDesc, checksumErrorsDesc, outOfBandDesc, droppedDesc,
+		cycleTimeDesc, cpuLoadDesc, i2cErrorsDesc, profileDesc, rateProfileDesc, rebootRequiredDesc,
+		armingDisabledDesc, flightModeDesc, attitudeDesc,
+		voltageDesc, currentDesc, drawnDesc, cellsDesc, capacityDesc, batteryStateDesc, rssiDesc,
+	}
+)
+
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range allDescs {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector. Readings are the latest polled
+// from each board, and are left out while it is disconnected.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range e.targets {
+		s := t.snapshot()
+		metric := func(desc *prometheus.Desc, kind prometheus.ValueType, v float64, labels ...string) {
+			ch <- prometheus.MustNewConstMetric(desc, kind, v, append(s.labels[:len(s.labels):len(s.labels)], labels...)...)
+		}
+		gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+			metric(desc, prometheus.GaugeValue, v, labels...)
+		}
+
+		gauge(upDesc, boolValue(s.up))
+		metric(connectionsDesc, prometheus.CounterValue, float64(s.connections))
+		metric(checksumErrorsDesc, prometheus.CounterValue, float64(s.frameErrors.Checksum))
+		metric(outOfBandDesc, prometheus.CounterValue, float64(s.frameErrors.OutOfBand))
+		metric(droppedDesc, prometheus.CounterValue, float64(s.dropped))
+
+		tm := s.telemetry
+		if tm == nil {
+			continue
+		}
+		if st := tm.Status; st != nil {
+			gauge(cycleTimeDesc, float64(st.CycleTime)/1e6)
+			gauge(cpuLoadDesc, float64(st.CPULoad)/100)
+			metric(i2cErrorsDesc, prometheus.CounterValue, float64(st.I2CErrors))
+			gauge(profileDesc, float64(st.Profile))
+			gauge(rateProfileDesc, float64(st.RateProfile))
+			gauge(rebootRequiredDesc, boolValue(st.RebootRequired))
+			for _, reason := range st.ArmingDisabled {
+				gauge(armingDisabledDesc, 1, reason)
+			}
+			for _, mode := range st.FlightModes {
+				gauge(flightModeDesc, 1, mode)
+			}
+		}
+		if a := tm.Attitude; a != nil {
+			gauge(attitudeDesc, a.Roll, "roll")
+			gauge(attitudeDesc, a.Pitch, "pitch")
+			gauge(attitudeDesc, a.Heading, "heading")
+		}
+		// The battery state repeats the analog readings, which older
+		// releases only report in MSP_ANALOG
+		switch {
+		case tm.Analog != nil:
+			gauge(voltageDesc, tm.Analog.Voltage)
+			gauge(currentDesc, tm.Analog.Current)
+			gauge(drawnDesc, float64(tm.Analog.Drawn))
+		case tm.Battery != nil:
+			gauge(voltageDesc, tm.Battery.Voltage)
+			gauge(currentDesc, tm.Battery.Current)
+			gauge(drawnDesc, float64(tm.Battery.Drawn))
+		}
+		if a := tm.Analog; a != nil {
+			gauge(rssiDesc, float64(a.RSSI)/1023)
+		}
+		if b := tm.Battery; b != nil {
+			gauge(cellsDesc, float64(b.Cells))
+			gauge(capacityDesc, float64(b.Capacity))
+			gauge(batteryStateDesc, 1, b.State)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
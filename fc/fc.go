@@ -24,19 +24,22 @@ SOFTWARE.
 package fc
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"time"
 
 	"github.com/robhaswell/btflcli/msp"
-	"go.bug.st/serial"
 )
 
 const (
 	dfuDevicePrefix     = "Found DFU: "
 	internalFlashMarker = "@Internal Flash  /"
+
+	requestTimeout    = 2 * time.Second
+	reconnectInterval = 500 * time.Millisecond
 )
 
 // FC represents a connection to the flight controller, which can
@@ -45,17 +48,28 @@ const (
 type FC struct {
 	opts         FCOptions
 	msp          *msp.MSP
+	APIMajor     byte
+	APIMinor     byte
 	Variant      string
 	VersionMajor byte
 	VersionMinor byte
 	VersionPatch byte
 	Name         string
-	Port         serial.Port
+	Port         msp.Transport
+	boxNames     []string
+	timeout      time.Duration
 }
 
 type FCOptions struct {
-	PortName         string
-	BaudRate         int
+	// PortName is a serial device or a URL accepted by msp.Open,
+	// e.g. tcp://localhost:5761 for Betaflight SITL.
+	PortName string
+	BaudRate int
+	// Transport, if not nil, is used instead of opening PortName.
+	Transport io.ReadWriteCloser
+	// PreferMSPV2 switches the connection to MSPv2 framing once the
+	// FC reports an API version that supports it.
+	PreferMSPV2      bool
 	Stdout           io.Writer
 	EnableDebugTrace bool
 }
@@ -64,44 +78,123 @@ func (f *FCOptions) stderr() io.Writer {
 	return f.Stdout
 }
 
+func (f *FCOptions) openMSP() (*msp.MSP, error) {
+	if f.Transport != nil {
+		return msp.NewWithTransport(f.PortName, f.Transport), nil
+	}
+	return msp.New(f.PortName, f.BaudRate)
+}
+
 // NewFC returns a new FC using the given port and baud rate. stdout is
 // optional and will default to os.Stdout if nil
 func NewFC(opts FCOptions) (*FC, error) {
-	m, err := msp.New(opts.PortName, opts.BaudRate)
-	if err != nil {
-		return nil, err
-	}
 	if opts.Stdout == nil {
 		opts.Stdout = os.Stdout
 	}
 	fc := &FC{
-		opts: opts,
-		msp:  m,
-		Port: m.Port,
+		opts:    opts,
+		timeout: requestTimeout,
+	}
+	if err := fc.connect(); err != nil {
+		return nil, err
 	}
-	fc.reset()
-	fc.updateInfo()
 	return fc, nil
 }
 
-func (f *FC) updateInfo() {
-	// Send commands to print FC info
-	f.msp.WriteCmd(msp.MspAPIVersion)
-	f.msp.WriteCmd(msp.MspFCVariant)
-	f.msp.WriteCmd(msp.MspFCVersion)
-	f.msp.WriteCmd(msp.MspName)
+// connect opens the port and reads the FC info.
+func (f *FC) connect() error {
+	m, err := f.opts.openMSP()
+	if err != nil {
+		return err
+	}
+	f.msp = m
+	f.Port = m.Port
+	f.reset()
+	if err := f.updateInfo(); err != nil {
+		m.Close()
+		f.msp = nil
+		return err
+	}
+	// Leave the port free for CLI traffic until the next request
+	m.StopReader()
+	if f.opts.PreferMSPV2 && f.SupportsMSPV2() {
+		m.SetV2(true)
+	}
+	return nil
+}
 
-	// Read 4 frames and handle the responses
-	m := f.msp
-	for ii := 0; ii < 4; ii++ {
-		frame, err := m.ReadFrame()
+// Reconnect closes the connection and opens it again once the FC answers,
+// e.g. after it reboots on leaving the CLI. It gives up after timeout.
+func (f *FC) Reconnect(timeout time.Duration) error {
+	if f.opts.Transport != nil {
+		return errors.New("can't reconnect to a transport given in FCOptions")
+	}
+	if f.msp != nil {
+		f.msp.Close()
+		f.msp = nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		// Give the FC time to reboot and the port time to disappear
+		// before trying to open it again
+		time.Sleep(reconnectInterval)
+		err := f.connect()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("flight controller did not come back: %w", err)
+		}
+	}
+}
+
+// Close closes the connection to the FC.
+func (f *FC) Close() error {
+	if f.msp == nil {
+		return nil
+	}
+	err := f.msp.Close()
+	f.msp = nil
+	return err
+}
+
+func (f *FC) updateInfo() error {
+	// Request the FC info and handle the responses
+	for _, code := range []uint16{msp.MspAPIVersion, msp.MspFCVariant, msp.MspFCVersion, msp.MspName} {
+		frame, err := f.Request(code)
 		if err != nil {
+			if msp.IsReplyError(err) {
+				return err
+			}
+			// The FC may be stuck in CLI mode, leave it so the
+			// next attempt can talk MSP.
 			f.Port.Write([]byte("exit\r\n"))
-			log.Println(err)
-			log.Fatal("MSP communication error, attempting to reset the FC")
+			return fmt.Errorf("MSP communication error, attempting to reset the FC: %w", err)
+		}
+		if err := f.handleFrame(frame); err != nil {
+			return err
 		}
-		f.handleFrame(frame)
 	}
+	return nil
+}
+
+// Request sends an MSP command to the FC and waits for its reply, giving up
+// after a couple of seconds or the time set with SetRequestTimeout.
+func (f *FC) Request(code uint16, args ...interface{}) (*msp.MSPFrame, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	return f.msp.Request(ctx, code, args...)
+}
+
+// SetRequestTimeout sets how long Request waits for a reply, for callers
+// polling at a rate where a lost frame is better given up on quickly.
+func (f *FC) SetRequestTimeout(t time.Duration) {
+	f.timeout = t
+}
+
+// MSP returns the MSP connection to the FC.
+func (f *FC) MSP() *msp.MSP {
+	return f.msp
 }
 
 func (f *FC) printf(format string, a ...interface{}) (int, error) {
@@ -112,9 +205,21 @@ func (f *FC) printInfo() {
 	f.printf("Connected to %s %d.%d.%d (%s)\n", f.Variant, f.VersionMajor, f.VersionMinor, f.VersionPatch, f.Name)
 }
 
+// infoPayloadSizes are the shortest valid replies to the info requests
+// whose payload handleFrame reads by position.
+var infoPayloadSizes = map[uint16]int{
+	msp.MspAPIVersion: 3,
+	msp.MspFCVersion:  3,
+}
+
 func (f *FC) handleFrame(fr *msp.MSPFrame) error {
+	if len(fr.Payload) < infoPayloadSizes[fr.Code] {
+		return fmt.Errorf("short reply to MSP cmd %d: got %d bytes, want %d", fr.Code, len(fr.Payload), infoPayloadSizes[fr.Code])
+	}
 	switch fr.Code {
 	case msp.MspAPIVersion:
+		f.APIMajor = fr.Byte(1)
+		f.APIMinor = fr.Byte(2)
 		f.printf("MSP API version %d.%d (protocol %d)\n", fr.Byte(1), fr.Byte(2), fr.Byte(0))
 	case msp.MspFCVariant:
 		f.Variant = string(fr.Payload)
@@ -136,6 +241,12 @@ func (f *FC) versionGte(major, minor, patch byte) bool {
 		(f.VersionMajor == major && f.VersionMinor == minor && f.VersionPatch >= patch)
 }
 
+// SupportsMSPV2 returns true if the FC's MSP API version understands MSPv2
+// frames, which arrived with API 1.40 (Betaflight 4.0).
+func (f *FC) SupportsMSPV2() bool {
+	return f.APIMajor > 1 || (f.APIMajor == 1 && f.APIMinor >= 40)
+}
+
 func (f *FC) shouldEnableDebugTrace() bool {
 	// Only INAV 1.9+ supports DEBUG_TRACE for now
 	return f.opts.EnableDebugTrace && f.Variant == "INAV" && f.versionGte(1, 9, 0)
@@ -150,7 +261,7 @@ func (f *FC) prepareToReboot(fn func(m *msp.MSP) error) error {
 	f.msp = nil
 	m.Close()
 	time.Sleep(time.Second)
-	mm, err := msp.New(f.opts.PortName, f.opts.BaudRate)
+	mm, err := f.opts.openMSP()
 	if err != nil {
 		return err
 	}
@@ -160,8 +271,11 @@ func (f *FC) prepareToReboot(fn func(m *msp.MSP) error) error {
 }
 
 func (f *FC) reset() {
+	f.APIMajor = 0
+	f.APIMinor = 0
 	f.Variant = ""
 	f.VersionMajor = 0
 	f.VersionMinor = 0
 	f.VersionPatch = 0
+	f.boxNames = nil
 }
@@ -0,0 +1,106 @@
+package fc_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/robhaswell/btflcli/fcsim"
+	"github.com/robhaswell/btflcli/msp"
+)
+
+func connect(t *testing.T, sim *fcsim.Sim, preferV2 bool) (*fc.FC, error) {
+	t.Helper()
+	board, err := fc.NewFC(fc.FCOptions{
+		PortName:    "sim",
+		Transport:   sim.Dial(),
+		PreferMSPV2: preferV2,
+		Stdout:      io.Discard,
+	})
+	if err == nil {
+		t.Cleanup(func() { board.Close() })
+	}
+	return board, err
+}
+
+func TestNewFC(t *testing.T) {
+	sim := fcsim.New()
+	if err := sim.Set("craft_name", "Bench Quad"); err != nil {
+		t.Fatal(err)
+	}
+	board, err := connect(t, sim, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if board.APIMajor != sim.APIMajor || board.APIMinor != sim.APIMinor {
+		t.Errorf("got API %d.%d, want %d.%d", board.APIMajor, board.APIMinor, sim.APIMajor, sim.APIMinor)
+	}
+	if board.Variant != "BTFL" {
+		t.Errorf("got variant %q", board.Variant)
+	}
+	if board.VersionMajor != 4 || board.VersionMinor != 5 || board.VersionPatch != 0 {
+		t.Errorf("got version %d.%d.%d", board.VersionMajor, board.VersionMinor, board.VersionPatch)
+	}
+	if board.Name != "Bench Quad" {
+		t.Errorf("got name %q", board.Name)
+	}
+	if board.MSP().V2() {
+		t.Error("MSPv2 is on without PreferMSPV2")
+	}
+
+	id, err := board.Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fc.Identity{
+		BoardIdentifier: sim.BoardIdentifier,
+		TargetName:      sim.TargetName,
+		BoardName:       sim.BoardName,
+		ManufacturerID:  sim.ManufacturerID,
+		BuildDate:       sim.BuildDate,
+		BuildTime:       sim.BuildTime,
+		GitRevision:     sim.GitRevision,
+		MCUID:           sim.MCUID(),
+	}
+	if *id != want {
+		t.Errorf("got identity %+v, want %+v", *id, want)
+	}
+}
+
+func TestNewFCPrefersMSPV2(t *testing.T) {
+	board, err := connect(t, fcsim.New(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !board.MSP().V2() {
+		t.Fatal("MSPv2 is off")
+	}
+	if _, err := board.Status(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewFCReplyError(t *testing.T) {
+	sim := fcsim.New()
+	sim.Unsupported = map[uint16]bool{msp.MspFCVariant: true}
+	if _, err := connect(t, sim, false); !msp.IsReplyError(err) {
+		t.Errorf("got error %v, want an error reply", err)
+	}
+}
+
+func TestBoxNamesUnsupported(t *testing.T) {
+	sim := fcsim.New()
+	sim.Unsupported = map[uint16]bool{msp.MspBoxNames: true}
+	board, err := connect(t, sim, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ii := 0; ii < 3; ii++ {
+		if _, err := board.Status(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := sim.Requests(msp.MspBoxNames); n != 1 {
+		t.Errorf("mode names were requested %d times", n)
+	}
+}
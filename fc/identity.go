@@ -0,0 +1,89 @@
+package fc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robhaswell/btflcli/msp"
+)
+
+// Identity is the hardware and build of a flight controller, as reported by
+// MSP_BOARD_INFO, MSP_BUILD_INFO and MSP_UID. Fields the firmware doesn't
+// report are left empty.
+type Identity struct {
+	// BoardIdentifier is the short target identifier, e.g. S7X2
+	BoardIdentifier  string `json:"board_identifier"`
+	HardwareRevision uint16 `json:"hardware_revision"`
+	// TargetName is the firmware target, e.g. STM32F7X2
+	TargetName     string `json:"target_name"`
+	BoardName      string `json:"board_name"`
+	ManufacturerID string `json:"manufacturer_id"`
+	BuildDate      string `json:"build_date"`
+	BuildTime      string `json:"build_time"`
+	GitRevision    string `json:"git_revision"`
+	// MCUID is the unique ID of the MCU, formatted as by the CLI mcu_id
+	// command
+	MCUID string `json:"mcu_id"`
+}
+
+// Identity requests the board, build and MCU information from the FC.
+func (f *FC) Identity() (*Identity, error) {
+	// Leave the port free for CLI traffic again afterwards
+	defer f.msp.StopReader()
+
+	id := &Identity{}
+	fr, err := f.Request(msp.MspBoardInfo)
+	if err != nil {
+		return nil, fmt.Errorf("requesting board info: %w", err)
+	}
+	id.readBoardInfo(fr)
+
+	fr, err = f.Request(msp.MspBuildInfo)
+	if err != nil {
+		return nil, fmt.Errorf("requesting build info: %w", err)
+	}
+	// Date (11 chars), time (8 chars) and revision (7 chars), followed by
+	// build options in newer firmware
+	if p := fr.Payload; len(p) >= 26 {
+		id.BuildDate = string(p[0:11])
+		id.BuildTime = string(p[11:19])
+		id.GitRevision = string(p[19:26])
+	}
+
+	fr, err = f.Request(msp.MspUID)
+	if err != nil && !msp.IsReplyError(err) {
+		return nil, fmt.Errorf("requesting MCU ID: %w", err)
+	}
+	if err == nil {
+		var uid [3]uint32
+		if fr.Read(uid[:]) == nil {
+			id.MCUID = fmt.Sprintf("%08x%08x%08x", uid[0], uid[1], uid[2])
+		}
+	}
+	return id, nil
+}
+
+func (id *Identity) readBoardInfo(fr *msp.MSPFrame) {
+	if len(fr.Payload) < 4 {
+		return
+	}
+	id.BoardIdentifier = string(fr.Payload[:4])
+	fr.Payload = fr.Payload[4:]
+	if fr.Read(&id.HardwareRevision) != nil {
+		return
+	}
+	// Board type and target capabilities
+	var skip [2]uint8
+	if fr.Read(skip[:]) != nil {
+		return
+	}
+	for _, s := range []*string{&id.TargetName, &id.BoardName, &id.ManufacturerID} {
+		var n uint8
+		if fr.Read(&n) != nil || fr.BytesRemaining() < int(n) {
+			return
+		}
+		b := make([]byte, n)
+		fr.Read(b)
+		*s = strings.TrimRight(string(b), "\x00")
+	}
+}
@@ -0,0 +1,104 @@
+package fc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/robhaswell/btflcli/msp"
+)
+
+// Telemetry is one round of readings taken by a Poller. Readings which
+// weren't polled, or which the firmware doesn't support, are nil.
+type Telemetry struct {
+	Time     time.Time `json:"time"`
+	Status   *Status   `json:"status,omitempty"`
+	Attitude *Attitude `json:"attitude,omitempty"`
+	RawIMU   *RawIMU   `json:"raw_imu,omitempty"`
+	RC       []uint16  `json:"rc,omitempty"`
+	Motors   []uint16  `json:"motors,omitempty"`
+	Analog   *Analog   `json:"analog,omitempty"`
+	Battery  *Battery  `json:"battery,omitempty"`
+	// Dropped is the number of messages whose reply was lost or corrupted
+	Dropped int `json:"dropped"`
+}
+
+// Messages are the messages a Poller can poll, by the names used on the
+// command line.
+var Messages = map[string]uint16{
+	"status":   msp.MspStatusEx,
+	"attitude": msp.MspAttitude,
+	"raw_imu":  msp.MspRawIMU,
+	"rc":       msp.MspRC,
+	"motor":    msp.MspMotor,
+	"analog":   msp.MspAnalog,
+	"battery":  msp.MspBatteryState,
+}
+
+// Poller requests a set of readings from the FC in turn, over one MSP
+// connection. A message the firmware answers with an error is assumed to be
+// unsupported and isn't requested again. A reply which is lost or corrupted
+// is left out of that round's readings.
+type Poller struct {
+	fc          *FC
+	codes       []uint16
+	unsupported map[uint16]bool
+}
+
+// NewPoller returns a poller for the given MSP messages, which may be any
+// of MspStatusEx, MspAttitude, MspRawIMU, MspRC, MspMotor, MspAnalog and
+// MspBatteryState.
+func (f *FC) NewPoller(codes ...uint16) *Poller {
+	return &Poller{
+		fc:          f,
+		codes:       codes,
+		unsupported: make(map[uint16]bool),
+	}
+}
+
+// Poll requests every supported message once. It only fails if the
+// connection does.
+func (p *Poller) Poll() (*Telemetry, error) {
+	t := &Telemetry{Time: time.Now()}
+	for _, code := range p.codes {
+		if p.unsupported[code] {
+			continue
+		}
+		var err error
+		switch code {
+		case msp.MspStatusEx:
+			t.Status, err = p.fc.Status()
+		case msp.MspAttitude:
+			t.Attitude, err = p.fc.Attitude()
+		case msp.MspRawIMU:
+			t.RawIMU, err = p.fc.RawIMU()
+		case msp.MspRC:
+			t.RC, err = p.fc.RC()
+		case msp.MspMotor:
+			t.Motors, err = p.fc.Motors()
+		case msp.MspAnalog:
+			t.Analog, err = p.fc.Analog()
+		case msp.MspBatteryState:
+			t.Battery, err = p.fc.Battery()
+		default:
+			p.unsupported[code] = true
+		}
+		if msp.IsReplyError(err) {
+			p.unsupported[code] = true
+			continue
+		}
+		if errors.Is(err, context.DeadlineExceeded) || msp.IsFrameError(err) {
+			t.Dropped++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Unsupported returns true if the firmware doesn't answer code.
+func (p *Poller) Unsupported(code uint16) bool {
+	return p.unsupported[code]
+}
@@ -0,0 +1,52 @@
+package fc_test
+
+import (
+	"testing"
+
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/robhaswell/btflcli/fcsim"
+	"github.com/robhaswell/btflcli/msp"
+)
+
+func TestPoll(t *testing.T) {
+	sim := fcsim.New()
+	sim.Unsupported = map[uint16]bool{msp.MspRawIMU: true}
+	board, err := connect(t, sim, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes []uint16
+	for _, code := range fc.Messages {
+		codes = append(codes, code)
+	}
+	poller := board.NewPoller(codes...)
+
+	for ii := 0; ii < 2; ii++ {
+		tm, err := poller.Poll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tm.RawIMU != nil {
+			t.Errorf("got IMU readings %+v", tm.RawIMU)
+		}
+		if tm.Status == nil || tm.Attitude == nil || tm.RC == nil || tm.Motors == nil || tm.Analog == nil || tm.Battery == nil {
+			t.Errorf("poll %d is missing readings: %+v", ii, tm)
+		}
+		if tm.Dropped != 0 {
+			t.Errorf("poll %d dropped %d replies", ii, tm.Dropped)
+		}
+	}
+	if !poller.Unsupported(msp.MspRawIMU) {
+		t.Error("the IMU isn't unsupported")
+	}
+	if poller.Unsupported(msp.MspAttitude) {
+		t.Error("attitude is unsupported")
+	}
+	// An unsupported message is only requested once
+	if n := sim.Requests(msp.MspRawIMU); n != 1 {
+		t.Errorf("the IMU was requested %d times", n)
+	}
+	if n := sim.Requests(msp.MspAttitude); n != 2 {
+		t.Errorf("attitude was requested %d times", n)
+	}
+}
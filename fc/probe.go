@@ -0,0 +1,90 @@
+package fc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robhaswell/btflcli/msp"
+)
+
+// Board describes a flight controller discovered by Probe.
+type Board struct {
+	PortName     string
+	APIMajor     byte
+	APIMinor     byte
+	Variant      string
+	VersionMajor byte
+	VersionMinor byte
+	VersionPatch byte
+	Name         string
+}
+
+func (b *Board) String() string {
+	return fmt.Sprintf("%s %d.%d.%d (%s) on %s", b.Variant, b.VersionMajor, b.VersionMinor, b.VersionPatch, b.Name, b.PortName)
+}
+
+// Probe opens the given port and checks whether a flight controller answers
+// MSP requests on it. The port is only accepted if it replies to
+// MSP_API_VERSION with a valid frame within the timeout, after which the
+// variant, version and craft name are requested too.
+func Probe(portName string, baudRate int, timeout time.Duration) (*Board, error) {
+	m, err := msp.New(portName, baudRate)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	board := &Board{PortName: portName}
+	for _, code := range []uint16{msp.MspAPIVersion, msp.MspFCVariant, msp.MspFCVersion, msp.MspName} {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		fr, err := m.Request(ctx, code)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("no MSP reply from %s: %w", portName, err)
+		}
+		switch code {
+		case msp.MspAPIVersion:
+			if len(fr.Payload) < 3 {
+				return nil, fmt.Errorf("short MSP_API_VERSION reply from %s", portName)
+			}
+			board.APIMajor = fr.Byte(1)
+			board.APIMinor = fr.Byte(2)
+		case msp.MspFCVariant:
+			board.Variant = string(fr.Payload)
+		case msp.MspFCVersion:
+			if len(fr.Payload) < 3 {
+				return nil, fmt.Errorf("short MSP_FC_VERSION reply from %s", portName)
+			}
+			board.VersionMajor = fr.Byte(0)
+			board.VersionMinor = fr.Byte(1)
+			board.VersionPatch = fr.Byte(2)
+		case msp.MspName:
+			board.Name = string(fr.Payload)
+		}
+	}
+	return board, nil
+}
+
+// Detect probes every port concurrently and returns the boards that answered,
+// in the same order as ports.
+func Detect(ports []string, baudRate int, timeout time.Duration) []*Board {
+	results := make([]*Board, len(ports))
+	done := make(chan struct{})
+	for ii, portName := range ports {
+		go func(ii int, portName string) {
+			results[ii], _ = Probe(portName, baudRate, timeout)
+			done <- struct{}{}
+		}(ii, portName)
+	}
+	for range ports {
+		<-done
+	}
+	var boards []*Board
+	for _, b := range results {
+		if b != nil {
+			boards = append(boards, b)
+		}
+	}
+	return boards
+}
@@ -0,0 +1,115 @@
+package fc_test
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/robhaswell/btflcli/fcsim"
+	"github.com/robhaswell/btflcli/msp"
+)
+
+const probeTimeout = 100 * time.Millisecond
+
+// probeSim is the simulator that probetest://sim connects to.
+var probeSim *fcsim.Sim
+
+func init() {
+	msp.RegisterScheme("probetest", func(u *url.URL, baudRate int) (msp.Transport, error) {
+		client, server := net.Pipe()
+		switch u.Host {
+		case "sim":
+			server.Close()
+			return probeSim.Dial(), nil
+		case "short":
+			// Answers every request, but not with an API version
+			go func() {
+				defer server.Close()
+				for {
+					fr, err := msp.DecodeFrame(server)
+					if err != nil {
+						return
+					}
+					server.Write(msp.EncodeReply(fr.Code, []byte{1}, false))
+				}
+			}()
+		default:
+			// Never answers
+			go func() {
+				io.Copy(io.Discard, server)
+				server.Close()
+			}()
+		}
+		return msp.NewTransport(client), nil
+	})
+}
+
+func TestProbe(t *testing.T) {
+	probeSim = fcsim.New()
+	if err := probeSim.Set("craft_name", "Bench Quad"); err != nil {
+		t.Fatal(err)
+	}
+	board, err := fc.Probe("probetest://sim", 115200, probeTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fc.Board{
+		PortName:     "probetest://sim",
+		APIMajor:     1,
+		APIMinor:     46,
+		Variant:      "BTFL",
+		VersionMajor: 4,
+		VersionMinor: 5,
+		VersionPatch: 0,
+		Name:         "Bench Quad",
+	}
+	if *board != want {
+		t.Errorf("got %+v, want %+v", *board, want)
+	}
+}
+
+func TestProbeFails(t *testing.T) {
+	probeSim = fcsim.New()
+	probeSim.Unsupported = map[uint16]bool{msp.MspAPIVersion: true}
+	tests := []struct {
+		port string
+		err  string
+	}{
+		{"probetest://sim", "no MSP reply"},
+		{"probetest://short", "short MSP_API_VERSION reply"},
+		{"probetest://silent", "context deadline exceeded"},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		board, err := fc.Probe(tt.port, 115200, probeTimeout)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %+v, %v, want error %q", tt.port, board, err, tt.err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*probeTimeout {
+			t.Errorf("%s: took %v", tt.port, elapsed)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	probeSim = fcsim.New()
+	boards := fc.Detect([]string{"probetest://silent", "probetest://sim", "probetest://short"}, 115200, probeTimeout)
+	if len(boards) != 1 || boards[0].PortName != "probetest://sim" {
+		t.Fatalf("got %v", boards)
+	}
+}
+
+func TestNewFCShortReply(t *testing.T) {
+	board, err := fc.NewFC(fc.FCOptions{PortName: "probetest://short", Stdout: io.Discard})
+	if err == nil {
+		board.Close()
+		t.Fatal("connected")
+	}
+	if !strings.Contains(err.Error(), "short reply to MSP cmd 1") {
+		t.Errorf("got error %v", err)
+	}
+}
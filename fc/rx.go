@@ -0,0 +1,26 @@
+package fc
+
+import (
+	"fmt"
+
+	"github.com/robhaswell/btflcli/msp"
+)
+
+// RXMap requests the order of the channels sent by the receiver, as the
+// channel of roll, pitch, yaw, throttle and the first AUX channels.
+func (f *FC) RXMap() ([]uint8, error) {
+	fr, err := f.Request(msp.MspRXMap)
+	if err != nil {
+		return nil, fmt.Errorf("requesting RX map: %w", err)
+	}
+	return fr.Payload, nil
+}
+
+// SetRawRC sends the value of each RC channel, in the order of RXMap, as an
+// MSP receiver. The FC ignores them unless its receiver is set to MSP.
+func (f *FC) SetRawRC(channels []uint16) error {
+	if _, err := f.Request(msp.MspSetRawRC, channels); err != nil {
+		return fmt.Errorf("sending RC channels: %w", err)
+	}
+	return nil
+}
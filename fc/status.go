@@ -0,0 +1,183 @@
+package fc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/robhaswell/btflcli/msp"
+)
+
+// sensorNames are the sensors in the MSP_STATUS_EX sensor mask, by bit.
+var sensorNames = []string{"ACC", "BARO", "MAG", "GPS", "RANGEFINDER", "GYRO"}
+
+// armingDisableNames are the arming disable flags by bit, named as the CLI
+// status command names them. The firmware's last flag is always ARMSWITCH,
+// whichever bit that is in the release.
+var armingDisableNames = []string{
+	"NOGYRO",
+	"FAILSAFE",
+	"RXLOSS",
+	"NOT_DISARMED",
+	"BOXFAILSAFE",
+	"RUNAWAY",
+	"CRASH",
+	"THROTTLE",
+	"ANGLE",
+	"BOOTGRACE",
+	"NOPREARM",
+	"LOAD",
+	"CALIB",
+	"CLI",
+	"CMS",
+	"BST",
+	"MSP",
+	"PARALYZE",
+	"GPS",
+	"RESCUE_SW",
+	"RPMFILTER",
+	"REBOOT_REQD",
+	"DSHOT_BBANG",
+	"NO_ACC_CAL",
+	"MOTOR_PROTO",
+}
+
+// Status is the state of the flight controller, as reported by
+// MSP_STATUS_EX.
+type Status struct {
+	// CycleTime is the time taken by the PID loop in µs
+	CycleTime uint16 `json:"cycle_time"`
+	I2CErrors uint16 `json:"i2c_errors"`
+	// Sensors are the names of the sensors detected
+	Sensors []string `json:"sensors"`
+	// FlightModes are the names of the active modes
+	FlightModes  []string `json:"flight_modes"`
+	Profile      int      `json:"profile"`
+	ProfileCount int      `json:"profile_count"`
+	RateProfile  int      `json:"rate_profile"`
+	// CPULoad is the average system load in percent
+	CPULoad int `json:"cpu_load"`
+	// ArmingDisableFlags is the bitmask of reasons the FC won't arm, named
+	// by ArmingDisabled
+	ArmingDisableFlags uint32   `json:"arming_disable_flags"`
+	ArmingDisabled     []string `json:"arming_disabled"`
+	RebootRequired     bool     `json:"reboot_required"`
+}
+
+// Status requests the state of the FC.
+func (f *FC) Status() (*Status, error) {
+	boxNames, err := f.BoxNames()
+	if err != nil {
+		return nil, err
+	}
+	fr, err := f.Request(msp.MspStatusEx)
+	if err != nil {
+		return nil, fmt.Errorf("requesting status: %w", err)
+	}
+	st := &Status{}
+	if err := st.read(fr, boxNames); err != nil {
+		return nil, fmt.Errorf("decoding status: %w", err)
+	}
+	return st, nil
+}
+
+func (st *Status) read(fr *msp.MSPFrame, boxNames []string) error {
+	var sensors, load uint16
+	var modeFlags uint32
+	var profile, profileCount, rateProfile, extraCount uint8
+	for _, v := range []interface{}{&st.CycleTime, &st.I2CErrors, &sensors, &modeFlags, &profile, &load} {
+		if err := fr.Read(v); err != nil {
+			return err
+		}
+	}
+	st.Sensors = bitNames(uint32(sensors), len(sensorNames), func(bit int) string { return sensorNames[bit] })
+	st.Profile = int(profile)
+	st.CPULoad = int(load)
+	modes := binary.LittleEndian.AppendUint32(nil, modeFlags)
+
+	// Older releases stop here
+	if fr.Read(&profileCount) != nil || fr.Read(&rateProfile) != nil {
+		st.FlightModes = modeNames(modes, boxNames)
+		return nil
+	}
+	st.ProfileCount = int(profileCount)
+	st.RateProfile = int(rateProfile)
+
+	// Modes beyond the first 32 follow, as many bytes as the count says
+	if fr.Read(&extraCount) == nil {
+		extra := make([]byte, extraCount)
+		if fr.Read(extra) == nil {
+			modes = append(modes, extra...)
+		}
+	}
+	st.FlightModes = modeNames(modes, boxNames)
+
+	var flagCount, configState uint8
+	if fr.Read(&flagCount) != nil || fr.Read(&st.ArmingDisableFlags) != nil {
+		return nil
+	}
+	st.ArmingDisabled = bitNames(st.ArmingDisableFlags, 32, func(bit int) string {
+		switch {
+		case flagCount > 0 && bit == int(flagCount)-1:
+			return "ARMSWITCH"
+		case bit < len(armingDisableNames):
+			return armingDisableNames[bit]
+		}
+		return fmt.Sprintf("FLAG_%d", bit)
+	})
+	if fr.Read(&configState) == nil {
+		st.RebootRequired = configState&1 != 0
+	}
+	return nil
+}
+
+// bitNames returns the names of the bits set in mask.
+func bitNames(mask uint32, bits int, name func(bit int) string) []string {
+	var names []string
+	for bit := 0; bit < bits; bit++ {
+		if mask&(1<<bit) != 0 {
+			names = append(names, name(bit))
+		}
+	}
+	return names
+}
+
+// modeNames returns the names of the modes set in a flight mode bitmask,
+// whose bits are the modes in the order of MSP_BOXNAMES.
+func modeNames(flags []byte, boxNames []string) []string {
+	var names []string
+	for bit := 0; bit < len(flags)*8; bit++ {
+		if flags[bit/8]&(1<<(bit%8)) == 0 {
+			continue
+		}
+		if bit < len(boxNames) {
+			names = append(names, boxNames[bit])
+		} else {
+			names = append(names, fmt.Sprintf("MODE_%d", bit))
+		}
+	}
+	return names
+}
+
+// BoxNames returns the names of the modes the FC supports, in the order of
+// the flight mode flags. It is nil if the FC won't name them. The names, or
+// the refusal, are only requested once per connection.
+func (f *FC) BoxNames() ([]string, error) {
+	if f.boxNames != nil {
+		if len(f.boxNames) == 0 {
+			return nil, nil
+		}
+		return f.boxNames, nil
+	}
+	fr, err := f.Request(msp.MspBoxNames)
+	if msp.IsReplyError(err) {
+		// Empty rather than nil, so the FC isn't asked again
+		f.boxNames = []string{}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("requesting mode names: %w", err)
+	}
+	f.boxNames = strings.Split(strings.TrimSuffix(string(fr.Payload), ";"), ";")
+	return f.boxNames, nil
+}
@@ -0,0 +1,171 @@
+package fc
+
+import (
+	"fmt"
+
+	"github.com/robhaswell/btflcli/msp"
+)
+
+// batteryStates are the battery states of MSP_BATTERY_STATE.
+var batteryStates = []string{"OK", "WARNING", "CRITICAL", "NOT PRESENT", "INIT"}
+
+// Analog is the MSP_ANALOG reply.
+type Analog struct {
+	// Voltage is the battery voltage in volts
+	Voltage float64 `json:"voltage"`
+	// Drawn is the charge used in mAh
+	Drawn uint16 `json:"drawn"`
+	// RSSI is from 0 to 1023
+	RSSI uint16 `json:"rssi"`
+	// Current is in amps
+	Current float64 `json:"current"`
+}
+
+// Analog requests the battery and RSSI readings.
+func (f *FC) Analog() (*Analog, error) {
+	fr, err := f.Request(msp.MspAnalog)
+	if err != nil {
+		return nil, fmt.Errorf("requesting analog readings: %w", err)
+	}
+	var legacyVoltage uint8
+	var current, voltage uint16
+	a := &Analog{}
+	for _, v := range []interface{}{&legacyVoltage, &a.Drawn, &a.RSSI, &current} {
+		if err := fr.Read(v); err != nil {
+			return nil, fmt.Errorf("decoding analog readings: %w", err)
+		}
+	}
+	a.Current = float64(int16(current)) / 100
+	// Older releases only report the voltage in tenths of a volt
+	if fr.Read(&voltage) == nil {
+		a.Voltage = float64(voltage) / 100
+	} else {
+		a.Voltage = float64(legacyVoltage) / 10
+	}
+	return a, nil
+}
+
+// Battery is the MSP_BATTERY_STATE reply.
+type Battery struct {
+	Cells uint8 `json:"cells"`
+	// Capacity is the configured capacity in mAh
+	Capacity uint16 `json:"capacity"`
+	// Voltage is in volts
+	Voltage float64 `json:"voltage"`
+	// Drawn is the charge used in mAh
+	Drawn uint16 `json:"drawn"`
+	// Current is in amps
+	Current float64 `json:"current"`
+	State   string  `json:"state"`
+}
+
+// Battery requests the state of the battery.
+func (f *FC) Battery() (*Battery, error) {
+	fr, err := f.Request(msp.MspBatteryState)
+	if err != nil {
+		return nil, fmt.Errorf("requesting battery state: %w", err)
+	}
+	var legacyVoltage, state uint8
+	var current, voltage uint16
+	b := &Battery{}
+	for _, v := range []interface{}{&b.Cells, &b.Capacity, &legacyVoltage, &b.Drawn, &current, &state} {
+		if err := fr.Read(v); err != nil {
+			return nil, fmt.Errorf("decoding battery state: %w", err)
+		}
+	}
+	b.Current = float64(int16(current)) / 100
+	if fr.Read(&voltage) == nil {
+		b.Voltage = float64(voltage) / 100
+	} else {
+		b.Voltage = float64(legacyVoltage) / 10
+	}
+	if int(state) < len(batteryStates) {
+		b.State = batteryStates[state]
+	} else {
+		b.State = fmt.Sprintf("STATE_%d", state)
+	}
+	return b, nil
+}
+
+// Attitude is the MSP_ATTITUDE reply, in degrees.
+type Attitude struct {
+	Roll    float64 `json:"roll"`
+	Pitch   float64 `json:"pitch"`
+	Heading float64 `json:"heading"`
+}
+
+// Attitude requests the estimated attitude of the craft.
+func (f *FC) Attitude() (*Attitude, error) {
+	fr, err := f.Request(msp.MspAttitude)
+	if err != nil {
+		return nil, fmt.Errorf("requesting attitude: %w", err)
+	}
+	var v [3]uint16
+	if err := fr.Read(v[:]); err != nil {
+		return nil, fmt.Errorf("decoding attitude: %w", err)
+	}
+	// Roll and pitch are in tenths of a degree
+	return &Attitude{
+		Roll:    float64(int16(v[0])) / 10,
+		Pitch:   float64(int16(v[1])) / 10,
+		Heading: float64(int16(v[2])),
+	}, nil
+}
+
+// RawIMU is the MSP_RAW_IMU reply.
+type RawIMU struct {
+	// Acc is in g
+	Acc [3]float64 `json:"acc"`
+	// Gyro is in degrees per second
+	Gyro [3]float64 `json:"gyro"`
+	// Mag is in the magnetometer's own units
+	Mag [3]float64 `json:"mag"`
+}
+
+// accScale is the reading of the accelerometer at 1g in MSP_RAW_IMU
+const accScale = 512
+
+// RawIMU requests the accelerometer, gyro and magnetometer readings.
+func (f *FC) RawIMU() (*RawIMU, error) {
+	fr, err := f.Request(msp.MspRawIMU)
+	if err != nil {
+		return nil, fmt.Errorf("requesting IMU readings: %w", err)
+	}
+	var v [9]uint16
+	if err := fr.Read(v[:]); err != nil {
+		return nil, fmt.Errorf("decoding IMU readings: %w", err)
+	}
+	imu := &RawIMU{}
+	for ii := 0; ii < 3; ii++ {
+		imu.Acc[ii] = float64(int16(v[ii])) / accScale
+		imu.Gyro[ii] = float64(int16(v[3+ii]))
+		imu.Mag[ii] = float64(int16(v[6+ii]))
+	}
+	return imu, nil
+}
+
+// RC requests the value of each RC channel, in µs, in the FC's channel
+// order: roll, pitch, yaw, throttle and then the aux channels.
+func (f *FC) RC() ([]uint16, error) {
+	fr, err := f.Request(msp.MspRC)
+	if err != nil {
+		return nil, fmt.Errorf("requesting RC channels: %w", err)
+	}
+	return readUint16s(fr), nil
+}
+
+// Motors requests the output to each motor, from 1000 to 2000. Motors the
+// craft doesn't have are 0.
+func (f *FC) Motors() ([]uint16, error) {
+	fr, err := f.Request(msp.MspMotor)
+	if err != nil {
+		return nil, fmt.Errorf("requesting motor outputs: %w", err)
+	}
+	return readUint16s(fr), nil
+}
+
+func readUint16s(fr *msp.MSPFrame) []uint16 {
+	v := make([]uint16, fr.BytesRemaining()/2)
+	fr.Read(v)
+	return v
+}
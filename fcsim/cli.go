@@ -0,0 +1,490 @@
+package fcsim
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const prompt = "\r\n# "
+
+var commandHelp = []struct {
+	name, description, args string
+}{
+	{"adjrange", "configure adjustment ranges", ""},
+	{"aux", "configure modes", "<index> <mode> <aux> <start> <end> <logic>"},
+	{"batch", "start or end a batch of commands", "start | end"},
+	{"beacon", "enable/disable beacon for one or more reasons", "list | [-]<reason>"},
+	{"beeper", "enable/disable beeper for one or more conditions", "list | [-]<condition>"},
+	{"board_name", "get / set the name of the board model", "[board name]"},
+	{"color", "configure colors", ""},
+	{"defaults", "reset to defaults and reboot", "[nosave]"},
+	{"diff", "list configuration changes from default", "[master|profile|rates|hardware|all]"},
+	{"dma", "show/set DMA assignments", "<> | <device> <index> list | <device> <index> [<option>|none]"},
+	{"dump", "dump configuration", "[master|profile|rates|hardware|all]"},
+	{"exit", "", ""},
+	{"feature", "configure features", "list | <->[name]"},
+	{"get", "get variable value", "[name]"},
+	{"help", "display command help", "[search string]"},
+	{"led", "configure leds", ""},
+	{"manufacturer_id", "get / set the id of the board manufacturer", "[manufacturer id]"},
+	{"map", "configure rc channel order", "[<map>]"},
+	{"mcu_id", "id of the microcontroller", ""},
+	{"mixer", "configure mixer", "list | <name>"},
+	{"mmix", "custom motor mixer", ""},
+	{"mode_color", "configure mode and special colors", ""},
+	{"profile", "change profile", "[<index>]"},
+	{"rateprofile", "change rate profile", "[<index>]"},
+	{"resource", "show/set resources", "<> | <resource name> <index> [<pin>|none] | show [all]"},
+	{"rxfail", "show/set rx failsafe settings", ""},
+	{"rxrange", "configure rx channel ranges", ""},
+	{"save", "save and reboot", ""},
+	{"serial", "configure serial ports", ""},
+	{"set", "change setting", "[<name>=<value>]"},
+	{"signature", "get / set the board type signature", "[signature]"},
+	{"smix", "servo mixer", ""},
+	{"timer", "show/set timers", "<> | <pin> list | <pin> [af<alternate function>|none|<option(deprecated)>] | list | show"},
+	{"version", "show version", ""},
+	{"vtxtable", "vtx frequency table", ""},
+}
+
+// serveCLI runs CLI mode until the client exits or saves. Like the real FC
+// it echoes what is typed and runs a command when a line ending arrives.
+func (c *conn) serveCLI() error {
+	c.sim.mu.Lock()
+	cli := &cli{sim: c.sim, st: c.sim.saved.clone()}
+	c.sim.mu.Unlock()
+
+	if _, err := c.w.Write([]byte("\r\nEntering CLI Mode, type 'exit' to return, or 'help'\r\n" + prompt)); err != nil {
+		return err
+	}
+	var line []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		var out string
+		switch b {
+		case '\r', '\n':
+			if len(line) == 0 {
+				continue
+			}
+			result, done := cli.execute(string(line))
+			line = line[:0]
+			if done {
+				_, err := c.w.Write([]byte("\r\n" + result))
+				return err
+			}
+			out = "\r\n" + result + prompt
+		case '\b', 0x7f:
+			if len(line) == 0 {
+				continue
+			}
+			line = line[:len(line)-1]
+			out = "\b \b"
+		default:
+			line = append(line, b)
+			out = string(b)
+		}
+		if _, err := c.w.Write([]byte(out)); err != nil {
+			return err
+		}
+	}
+}
+
+// cli runs commands against a working copy of the configuration, which only
+// replaces the saved one on `save`.
+type cli struct {
+	sim *Sim
+	st  *state
+}
+
+func errorLine(cmd, format string, a ...interface{}) string {
+	return fmt.Sprintf("###ERROR IN %s: %s###\r\n", cmd, fmt.Sprintf(format, a...))
+}
+
+// execute runs one command line and returns its output. done is true if the
+// FC left CLI mode.
+func (c *cli) execute(line string) (out string, done bool) {
+	// Strip comments
+	line, _, _ = strings.Cut(line, "#")
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", false
+	}
+	cmd, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+	args := strings.Fields(rest)
+	cmd = strings.ToLower(cmd)
+
+	switch cmd {
+	case "help":
+		return c.help(rest), false
+	case "version":
+		return c.versionLine() + "\r\n", false
+	case "get":
+		return c.get(rest), false
+	case "set":
+		return c.set(rest), false
+	case "dump":
+		return c.printConfig(args, false), false
+	case "diff":
+		return c.printConfig(args, true), false
+	case "feature":
+		return c.feature(args), false
+	case "profile", "rateprofile":
+		return c.profile(cmd, args), false
+	case "board_name":
+		return c.identity(cmd, "BOARD NAME", c.sim.BoardName, rest), false
+	case "manufacturer_id":
+		return c.identity(cmd, "MANUFACTURER ID", c.sim.ManufacturerID, rest), false
+	case "mcu_id":
+		return c.sim.MCUID() + "\r\n", false
+	case "signature":
+		return "", false
+	case "batch":
+		if len(args) != 1 || (args[0] != "start" && args[0] != "end") {
+			return errorLine(cmd, "PARSE ERROR"), false
+		}
+		return "", false
+	case "defaults":
+		c.st = c.sim.defaultState()
+		if len(args) > 0 && args[0] == "nosave" {
+			return "", false
+		}
+		c.save()
+		return "\r\n# Resetting to defaults\r\nRebooting\r\n", true
+	case "save":
+		c.save()
+		return "\r\n# saving\r\nRebooting\r\n", true
+	case "exit":
+		return "\r\n# leaving CLI mode, unsaved changes lost\r\nRebooting\r\n", true
+	}
+
+	if lc := findLineCommand(cmd); lc != nil {
+		if len(args) == 0 {
+			return c.listLines(lc), false
+		}
+		if !c.st.setLine(line) {
+			return errorLine(cmd, "PARSE ERROR"), false
+		}
+		return "", false
+	}
+	return errorLine(cmd, "UNKNOWN COMMAND, TRY 'HELP'"), false
+}
+
+func (c *cli) save() {
+	c.sim.mu.Lock()
+	defer c.sim.mu.Unlock()
+	c.sim.saved = c.st.clone()
+}
+
+func (c *cli) help(filter string) string {
+	var b strings.Builder
+	for _, h := range commandHelp {
+		if filter != "" && !strings.Contains(h.name, filter) && !strings.Contains(h.description, filter) {
+			continue
+		}
+		b.WriteString(h.name)
+		if h.description != "" {
+			b.WriteString(" - " + h.description)
+		}
+		if h.args != "" {
+			b.WriteString("\r\n\t" + h.args)
+		}
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+func (c *cli) versionLine() string {
+	s := c.sim
+	return fmt.Sprintf("# Betaflight / %s (%s) %d.%d.%d %s / %s (%s) MSP API: %d.%d",
+		s.TargetName, s.BoardIdentifier, s.VersionMajor, s.VersionMinor, s.VersionPatch,
+		s.BuildDate, s.BuildTime, s.GitRevision, s.APIMajor, s.APIMinor)
+}
+
+func (c *cli) get(name string) string {
+	var b strings.Builder
+	matched := 0
+	for _, setting := range c.sim.settings {
+		if !strings.Contains(setting.Name, strings.ToLower(name)) {
+			continue
+		}
+		if matched > 0 {
+			b.WriteString("\r\n")
+		}
+		matched++
+		fmt.Fprintf(&b, "%s = %s\r\n", setting.Name, c.st.values[setting.Name][c.st.index(setting)])
+		switch setting.Section {
+		case SectionProfile:
+			fmt.Fprintf(&b, "profile %d\r\n", c.st.profile)
+		case SectionRateProfile:
+			fmt.Fprintf(&b, "rateprofile %d\r\n", c.st.rateProfile)
+		}
+		b.WriteString(setting.rangeLine() + "\r\n")
+		fmt.Fprintf(&b, "Default value: %s\r\n", setting.Default)
+	}
+	if matched == 0 {
+		return errorLine("get", "INVALID NAME: %s", name)
+	}
+	return b.String()
+}
+
+func (c *cli) set(args string) string {
+	name, value, found := strings.Cut(args, "=")
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !found {
+		// List the matching settings
+		var b strings.Builder
+		for _, setting := range c.sim.settings {
+			if strings.Contains(setting.Name, name) {
+				fmt.Fprintf(&b, "%s = %s\r\n", setting.Name, c.st.values[setting.Name][c.st.index(setting)])
+			}
+		}
+		return b.String()
+	}
+	setting, ok := c.sim.byName[name]
+	if !ok {
+		return errorLine("set", "INVALID NAME: %s", name)
+	}
+	v, ok := setting.normalise(value)
+	if !ok {
+		return errorLine("set", "INVALID VALUE") + setting.rangeLine() + "\r\n"
+	}
+	c.st.values[name][c.st.index(setting)] = v
+	return fmt.Sprintf("%s set to %s\r\n", name, v)
+}
+
+func (c *cli) feature(args []string) string {
+	if len(args) == 0 || args[0] == "list" {
+		var enabled []string
+		for _, f := range Features {
+			if c.st.features[f] {
+				enabled = append(enabled, f)
+			}
+		}
+		return "Enabled: " + strings.Join(enabled, " ") + "\r\n"
+	}
+	name := strings.ToUpper(args[0])
+	enable := !strings.HasPrefix(name, "-")
+	name = strings.TrimPrefix(name, "-")
+	for _, f := range Features {
+		if f == name {
+			c.st.features[f] = enable
+			if enable {
+				return "Enabled " + f + "\r\n"
+			}
+			return "Disabled " + f + "\r\n"
+		}
+	}
+	return errorLine("feature", "INVALID NAME")
+}
+
+func (c *cli) profile(cmd string, args []string) string {
+	current, count := &c.st.profile, ProfileCount
+	if cmd == "rateprofile" {
+		current, count = &c.st.rateProfile, RateProfileCount
+	}
+	if len(args) == 0 {
+		return fmt.Sprintf("%s %d\r\n", cmd, *current)
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 || n >= count {
+		return errorLine(cmd, "PARSE ERROR")
+	}
+	*current = n
+	return ""
+}
+
+// identity handles commands for board identity values, which can only be
+// set once.
+func (c *cli) identity(cmd, what, current, value string) string {
+	if value == "" {
+		return fmt.Sprintf("%s %s\r\n", cmd, current)
+	}
+	if value != current {
+		return errorLine(cmd, "%s CANNOT BE CHANGED. CURRENT VALUE: '%s'", what, current)
+	}
+	return ""
+}
+
+func (c *cli) listLines(lc *lineCommand) string {
+	var b strings.Builder
+	for _, key := range c.st.order {
+		if strings.HasPrefix(key+" ", lc.name+" ") {
+			b.WriteString(c.st.lines[key] + "\r\n")
+		}
+	}
+	return b.String()
+}
+
+// printer builds dump and diff output. A section heading is only printed
+// once a line under it is.
+type printer struct {
+	b       strings.Builder
+	heading string
+	printed string
+}
+
+func (p *printer) hash(s string) {
+	p.b.WriteString("\r\n# " + s + "\r\n")
+}
+
+func (p *printer) section(s string) {
+	if s != p.printed {
+		p.heading = s
+	}
+}
+
+func (p *printer) line(format string, a ...interface{}) {
+	if p.heading != "" {
+		p.hash(p.heading)
+		p.printed = p.heading
+		p.heading = ""
+	}
+	fmt.Fprintf(&p.b, format+"\r\n", a...)
+}
+
+func (c *cli) printConfig(args []string, diff bool) string {
+	what := ""
+	for _, a := range args {
+		switch a {
+		case "all", "master", "profile", "rates", "hardware":
+			what = a
+		}
+	}
+	full := what == "" || what == "all"
+	defaults := c.sim.defaultState()
+	st := c.st
+	p := &printer{}
+
+	if full || what == "hardware" {
+		p.hash("version")
+		p.line(c.versionLine())
+	}
+	if what == "all" {
+		p.hash("start the command batch")
+		p.line("batch start")
+		p.hash("reset configuration to default settings")
+		p.line("defaults nosave")
+	}
+	if full || what == "hardware" {
+		p.line("")
+		p.line("board_name %s", c.sim.BoardName)
+		p.line("manufacturer_id %s", c.sim.ManufacturerID)
+		p.line("mcu_id %s", c.sim.MCUID())
+		p.line("signature ")
+	}
+	if full {
+		if name := st.values["craft_name"][0]; name != "" {
+			p.hash("name: " + name)
+		}
+	}
+	if full || what == "hardware" || what == "master" {
+		c.printLines(p, defaults, diff, what)
+	}
+	if full || what == "master" {
+		p.section("master")
+		for _, setting := range c.sim.settings {
+			if setting.Section == SectionMaster {
+				c.printSetting(p, setting, 0, defaults, diff)
+			}
+		}
+	}
+	printProfile := func(cmd string, section Section, idx int) {
+		p.heading = ""
+		p.b.WriteString("\r\n")
+		p.line("%s %d", cmd, idx)
+		p.hash(fmt.Sprintf("%s %d", cmd, idx))
+		for _, setting := range c.sim.settings {
+			if setting.Section == section {
+				c.printSetting(p, setting, idx, defaults, diff)
+			}
+		}
+	}
+	switch {
+	case what == "all":
+		for ii := 0; ii < ProfileCount; ii++ {
+			printProfile("profile", SectionProfile, ii)
+		}
+		for ii := 0; ii < RateProfileCount; ii++ {
+			printProfile("rateprofile", SectionRateProfile, ii)
+		}
+		p.hash("restore original profile selection")
+		p.line("profile %d", st.profile)
+		p.hash("restore original rateprofile selection")
+		p.line("rateprofile %d", st.rateProfile)
+	default:
+		if full || what == "profile" {
+			printProfile("profile", SectionProfile, st.profile)
+		}
+		if full || what == "rates" {
+			printProfile("rateprofile", SectionRateProfile, st.rateProfile)
+		}
+	}
+	if full {
+		p.hash("save configuration")
+		p.line("save")
+	}
+	return p.b.String()
+}
+
+func (c *cli) printSetting(p *printer, setting *Setting, idx int, defaults *state, diff bool) {
+	value := c.st.values[setting.Name][idx]
+	if diff && value == defaults.values[setting.Name][idx] {
+		return
+	}
+	p.line("set %s = %s", setting.Name, value)
+}
+
+// printLines prints the lineCommands and features, in the order the FC
+// does. Only lines that differ from the defaults are printed for a diff.
+func (c *cli) printLines(p *printer, defaults *state, diff bool, what string) {
+	hardware := map[string]bool{"resource": true, "timer": true, "dma": true}
+	printFeatures := func() {
+		p.section("feature")
+		for _, enabled := range []bool{false, true} {
+			for _, f := range Features {
+				if c.st.features[f] != enabled || (diff && defaults.features[f] == enabled) {
+					continue
+				}
+				if enabled {
+					p.line("feature %s", f)
+				} else {
+					p.line("feature -%s", f)
+				}
+			}
+		}
+	}
+	for _, lc := range lineCommands {
+		if (what == "hardware") != hardware[lc.name] && what != "" && what != "all" {
+			continue
+		}
+		if lc.name == "beeper" {
+			printFeatures()
+		}
+		p.section(lc.heading)
+		var keys []string
+		for _, key := range c.st.order {
+			if strings.HasPrefix(key+" ", lc.name+" ") {
+				keys = append(keys, key)
+			}
+		}
+		// Defaults first in their own order, then anything added
+		sort.SliceStable(keys, func(i, j int) bool {
+			_, iDefault := defaults.lines[keys[i]]
+			_, jDefault := defaults.lines[keys[j]]
+			return iDefault && !jDefault
+		})
+		for _, key := range keys {
+			line := c.st.lines[key]
+			if diff && defaults.lines[key] == line {
+				continue
+			}
+			p.line("%s", line)
+		}
+	}
+}
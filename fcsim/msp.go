@@ -0,0 +1,66 @@
+package fcsim
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/robhaswell/btflcli/msp"
+)
+
+// handleMSP returns the reply to an MSP request, which is an error reply for
+// commands the simulator doesn't implement, or nil if it isn't answered.
+func (s *Sim) handleMSP(fr *msp.MSPFrame) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[fr.Code]++
+	var buf bytes.Buffer
+	if s.Silent[fr.Code] {
+		return nil
+	}
+	if s.Unsupported[fr.Code] {
+		return msp.EncodeErrorReply(fr.Code, fr.V2)
+	}
+	switch fr.Code {
+	case msp.MspAPIVersion:
+		buf.Write([]byte{0, s.APIMajor, s.APIMinor})
+	case msp.MspFCVariant:
+		buf.WriteString(s.Variant)
+	case msp.MspFCVersion:
+		buf.Write([]byte{s.VersionMajor, s.VersionMinor, s.VersionPatch})
+	case msp.MspBoardInfo:
+		buf.WriteString(s.BoardIdentifier)
+		binary.Write(&buf, binary.LittleEndian, uint16(0)) // hardware revision
+		buf.WriteByte(0)                                   // board type
+		buf.WriteByte(0)                                   // target capabilities
+		writeString8(&buf, s.TargetName)
+		writeString8(&buf, s.BoardName)
+		writeString8(&buf, s.ManufacturerID)
+		buf.Write(make([]byte, 32)) // signature
+		buf.WriteByte(0)            // MCU type
+		buf.WriteByte(1)            // configuration state
+		binary.Write(&buf, binary.LittleEndian, uint16(8000))
+		binary.Write(&buf, binary.LittleEndian, uint32(0)) // configuration problems
+	case msp.MspBuildInfo:
+		buf.WriteString(s.BuildDate)
+		buf.WriteString(s.BuildTime)
+		buf.WriteString(s.GitRevision)
+	case msp.MspName:
+		buf.WriteString(s.saved.values["craft_name"][0])
+	case msp.MspUID:
+		buf.Write(s.UID[:])
+	case msp.MspRXMap:
+		buf.Write(rxMap)
+	case msp.MspSetRawRC:
+		s.setRawRC(fr)
+	default:
+		if !s.writeTelemetry(fr.Code, &buf) {
+			return msp.EncodeErrorReply(fr.Code, fr.V2)
+		}
+	}
+	return msp.EncodeReply(fr.Code, buf.Bytes(), fr.V2)
+}
+
+func writeString8(buf *bytes.Buffer, s string) {
+	buf.WriteByte(byte(len(s)))
+	buf.WriteString(s)
+}
@@ -0,0 +1,211 @@
+package fcsim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Section is the part of the configuration a setting belongs to.
+type Section int
+
+const (
+	SectionMaster Section = iota
+	SectionProfile
+	SectionRateProfile
+)
+
+const (
+	ProfileCount     = 4
+	RateProfileCount = 4
+)
+
+// Setting describes one CLI variable, as listed by `get`.
+type Setting struct {
+	Name    string
+	Section Section
+	// Values lists the allowed values of a lookup setting
+	Values []string
+	// Min and Max bound numeric settings and the elements of arrays
+	Min, Max int
+	// Length is the number of elements of an array setting
+	Length int
+	// String settings are free text of at most Max characters
+	String  bool
+	Default string
+}
+
+func (s *Setting) isLookup() bool {
+	return len(s.Values) > 0
+}
+
+// normalise validates value and returns it in the form the FC prints it.
+func (s *Setting) normalise(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	switch {
+	case s.String:
+		if len(value) > s.Max {
+			return "", false
+		}
+		return value, true
+	case s.isLookup():
+		for _, v := range s.Values {
+			if strings.EqualFold(v, value) {
+				return v, true
+			}
+		}
+		return "", false
+	case s.Length > 0:
+		elems := strings.Split(value, ",")
+		if len(elems) != s.Length {
+			return "", false
+		}
+		for ii, e := range elems {
+			n, ok := s.number(e)
+			if !ok {
+				return "", false
+			}
+			elems[ii] = strconv.Itoa(n)
+		}
+		return strings.Join(elems, ","), true
+	default:
+		n, ok := s.number(value)
+		if !ok {
+			return "", false
+		}
+		return strconv.Itoa(n), true
+	}
+}
+
+func (s *Setting) number(value string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < s.Min || n > s.Max {
+		return 0, false
+	}
+	return n, true
+}
+
+// rangeLine returns the line `get` prints to describe the valid values.
+func (s *Setting) rangeLine() string {
+	switch {
+	case s.String:
+		return fmt.Sprintf("String length: 0 - %d", s.Max)
+	case s.isLookup():
+		return "Allowed values: " + strings.Join(s.Values, ", ")
+	case s.Length > 0:
+		return fmt.Sprintf("Array length: %d", s.Length)
+	default:
+		return fmt.Sprintf("Allowed range: %d - %d", s.Min, s.Max)
+	}
+}
+
+func number(name string, section Section, min, max, def int) *Setting {
+	return &Setting{Name: name, Section: section, Min: min, Max: max, Default: strconv.Itoa(def)}
+}
+
+func lookup(name string, section Section, def string, values ...string) *Setting {
+	return &Setting{Name: name, Section: section, Values: values, Default: def}
+}
+
+func text(name string, section Section, maxLen int) *Setting {
+	return &Setting{Name: name, Section: section, String: true, Max: maxLen}
+}
+
+func array(name string, section Section, min, max int, def ...int) *Setting {
+	elems := make([]string, len(def))
+	for ii, d := range def {
+		elems[ii] = strconv.Itoa(d)
+	}
+	return &Setting{Name: name, Section: section, Min: min, Max: max, Length: len(def), Default: strings.Join(elems, ",")}
+}
+
+var offOn = []string{"OFF", "ON"}
+
+// DefaultSettings returns a representative subset of the Betaflight 4.5
+// setting table.
+func DefaultSettings() []*Setting {
+	m, p, r := SectionMaster, SectionProfile, SectionRateProfile
+	return []*Setting{
+		number("gyro_lpf1_static_hz", m, 0, 1000, 250),
+		number("gyro_lpf2_static_hz", m, 0, 1000, 500),
+		lookup("gyro_lpf1_type", m, "PT1", "PT1", "BIQUAD", "PT2", "PT3"),
+		number("dyn_notch_count", m, 0, 7, 3),
+		number("dyn_notch_q", m, 1, 1000, 300),
+		number("dyn_notch_min_hz", m, 20, 250, 100),
+		number("dyn_notch_max_hz", m, 200, 1000, 600),
+		number("rpm_filter_harmonics", m, 0, 3, 3),
+		number("rpm_filter_min_hz", m, 30, 200, 100),
+		lookup("serialrx_provider", m, "SBUS", "SPEK1024", "SPEK2048", "SBUS", "SUMD", "SUMH", "XB-B", "XB-B-RJ01", "IBUS", "JETIEXBUS", "CRSF", "SRXL", "CUSTOM", "FPORT", "SRXL2", "GHST", "SPEK2048_SRXL"),
+		lookup("motor_pwm_protocol", m, "DSHOT600", "PWM", "ONESHOT125", "ONESHOT42", "MULTISHOT", "BRUSHED", "DSHOT150", "DSHOT300", "DSHOT600", "PROSHOT1000", "DISABLED"),
+		lookup("dshot_bidir", m, "OFF", offOn...),
+		number("motor_poles", m, 4, 255, 14),
+		number("min_check", m, 750, 2250, 1050),
+		number("max_check", m, 750, 2250, 1900),
+		number("vbat_max_cell_voltage", m, 100, 500, 430),
+		number("vbat_min_cell_voltage", m, 100, 500, 330),
+		number("vbat_warning_cell_voltage", m, 100, 500, 350),
+		number("small_angle", m, 0, 180, 25),
+		lookup("gyro_cal_on_first_arm", m, "OFF", offOn...),
+		lookup("rc_smoothing", m, "ON", offOn...),
+		number("rc_smoothing_auto_factor", m, 0, 250, 30),
+		array("motor_output_reordering", m, 0, 255, 0, 1, 2, 3, 4, 5, 6, 7),
+		number("osd_vbat_pos", m, 0, 65535, 234),
+		number("osd_rssi_pos", m, 0, 65535, 234),
+		lookup("vcd_video_system", m, "AUTO", "AUTO", "PAL", "NTSC", "HD"),
+		text("craft_name", m, 16),
+		text("pilot_name", m, 16),
+
+		number("p_roll", p, 0, 250, 45),
+		number("i_roll", p, 0, 250, 80),
+		number("d_roll", p, 0, 250, 30),
+		number("f_roll", p, 0, 1000, 120),
+		number("p_pitch", p, 0, 250, 47),
+		number("i_pitch", p, 0, 250, 84),
+		number("d_pitch", p, 0, 250, 34),
+		number("f_pitch", p, 0, 1000, 125),
+		number("p_yaw", p, 0, 250, 45),
+		number("i_yaw", p, 0, 250, 80),
+		number("d_yaw", p, 0, 250, 0),
+		number("f_yaw", p, 0, 1000, 120),
+		number("d_max_roll", p, 0, 250, 40),
+		number("d_max_pitch", p, 0, 250, 46),
+		number("dterm_lpf1_dyn_min_hz", p, 0, 1000, 75),
+		number("dterm_lpf1_dyn_max_hz", p, 0, 1000, 150),
+		number("anti_gravity_gain", p, 0, 250, 80),
+		number("tpa_rate", p, 0, 100, 65),
+		number("tpa_breakpoint", p, 750, 2250, 1350),
+		lookup("iterm_relax", p, "RP", "OFF", "RP", "RPY", "RP_INC", "RPY_INC"),
+		number("throttle_boost", p, 0, 100, 5),
+		lookup("simplified_pids_mode", p, "RPY", "OFF", "RP", "RPY"),
+		number("simplified_master_multiplier", p, 0, 200, 100),
+		number("simplified_d_gain", p, 0, 200, 100),
+		text("profile_name", p, 8),
+
+		lookup("rates_type", r, "ACTUAL", "BETAFLIGHT", "RACEFLIGHT", "KISS", "ACTUAL", "QUICK"),
+		number("roll_rc_rate", r, 1, 255, 7),
+		number("pitch_rc_rate", r, 1, 255, 7),
+		number("yaw_rc_rate", r, 1, 255, 7),
+		number("roll_expo", r, 0, 100, 0),
+		number("pitch_expo", r, 0, 100, 0),
+		number("yaw_expo", r, 0, 100, 0),
+		number("roll_srate", r, 0, 255, 67),
+		number("pitch_srate", r, 0, 255, 67),
+		number("yaw_srate", r, 0, 255, 67),
+		number("thr_mid", r, 0, 100, 50),
+		number("thr_expo", r, 0, 100, 0),
+		lookup("throttle_limit_type", r, "OFF", "OFF", "SCALE", "CLIP"),
+		number("throttle_limit_percent", r, 25, 100, 100),
+		text("rateprofile_name", r, 8),
+	}
+}
+
+// Features lists the names accepted by the `feature` command, in the order
+// the FC prints them.
+var Features = []string{
+	"RX_PPM", "INFLIGHT_ACC_CAL", "RX_SERIAL", "MOTOR_STOP", "SERVO_TILT",
+	"SOFTSERIAL", "GPS", "RANGEFINDER", "TELEMETRY", "3D", "RX_PARALLEL_PWM",
+	"RX_MSP", "RSSI_ADC", "LED_STRIP", "DISPLAY", "OSD", "CHANNEL_FORWARDING",
+	"TRANSPONDER", "AIRMODE", "RX_SPI", "ESC_SENSOR", "ANTI_GRAVITY",
+}
+
+var defaultFeatures = []string{"RX_SERIAL", "TELEMETRY", "OSD", "AIRMODE", "ANTI_GRAVITY"}
@@ -0,0 +1,220 @@
+// Package fcsim emulates a Betaflight flight controller well enough to
+// exercise the rest of btflcli without a board: it answers MSP requests and
+// implements the CLI mode entered with '#', backed by an in-memory settings
+// table.
+package fcsim
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robhaswell/btflcli/msp"
+)
+
+// Sim is a simulated flight controller. Its identity fields can be changed
+// before the first connection is served.
+type Sim struct {
+	Variant         string
+	VersionMajor    byte
+	VersionMinor    byte
+	VersionPatch    byte
+	APIMajor        byte
+	APIMinor        byte
+	TargetName      string
+	BoardIdentifier string
+	BoardName       string
+	ManufacturerID  string
+	BuildDate       string
+	BuildTime       string
+	GitRevision     string
+	UID             [12]byte
+	// Unsupported are MSP commands to answer with an error, as firmware
+	// without them would
+	Unsupported map[uint16]bool
+	// Silent are MSP commands never to answer, as if every reply were lost
+	Silent map[uint16]bool
+
+	settings     []*Setting
+	byName       map[string]*Setting
+	defaultLines []string
+
+	mu      sync.Mutex
+	saved   *state
+	started time.Time
+	// rc is the value of each RC channel
+	rc []uint16
+	// rcReceived is when MSP_SET_RAW_RC last arrived
+	rcReceived time.Time
+	// requests counts the MSP requests received for each command
+	requests map[uint16]int
+}
+
+// Default is the simulator that sim:// ports connect to, so that every
+// connection made by one process sees the same configuration.
+var Default = New()
+
+func init() {
+	msp.RegisterScheme("sim", func(u *url.URL, baudRate int) (msp.Transport, error) {
+		return Default.Dial(), nil
+	})
+}
+
+// New returns a simulated Betaflight 4.5.0 board with a default
+// configuration.
+func New() *Sim {
+	s := &Sim{
+		Variant:         "BTFL",
+		VersionMajor:    4,
+		VersionMinor:    5,
+		VersionPatch:    0,
+		APIMajor:        1,
+		APIMinor:        46,
+		TargetName:      "STM32F7X2",
+		BoardIdentifier: "S7X2",
+		BoardName:       "SPEEDYBEEF7V3",
+		ManufacturerID:  "SPBE",
+		BuildDate:       "Apr  1 2024",
+		BuildTime:       "10:00:00",
+		GitRevision:     "c155f58",
+		UID:             [12]byte{0x3b, 0x00, 0x26, 0x00, 0x31, 0x33, 0x51, 0x07, 0x35, 0x36, 0x36, 0x36},
+		settings:        DefaultSettings(),
+		byName:          make(map[string]*Setting),
+		defaultLines:    defaultLines(),
+		started:         time.Now(),
+		rc:              defaultRC(),
+		requests:        make(map[uint16]int),
+	}
+	for _, setting := range s.settings {
+		s.byName[setting.Name] = setting
+	}
+	s.saved = s.defaultState()
+	return s
+}
+
+// MCUID returns the MCU id printed by the CLI, derived from the UID.
+func (s *Sim) MCUID() string {
+	// The UID is three little endian words, printed as 32 bit numbers
+	var b strings.Builder
+	for ii := 0; ii < len(s.UID); ii += 4 {
+		fmt.Fprintf(&b, "%08x", binary.LittleEndian.Uint32(s.UID[ii:]))
+	}
+	return b.String()
+}
+
+// Settings returns the simulator's setting table.
+func (s *Sim) Settings() []*Setting {
+	return s.settings
+}
+
+// Requests returns the number of MSP requests the simulator has received for
+// a command, answered or not.
+func (s *Sim) Requests(code uint16) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[code]
+}
+
+// Get returns the saved value of a setting, from the current profile or
+// rate profile if it belongs to one.
+func (s *Sim) Get(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	setting, ok := s.byName[name]
+	if !ok {
+		return "", false
+	}
+	return s.saved.values[name][s.saved.index(setting)], true
+}
+
+// Set changes the saved value of a setting, as if it had been set in the CLI
+// and saved.
+func (s *Sim) Set(name, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	setting, ok := s.byName[name]
+	if !ok {
+		return fmt.Errorf("invalid name: %s", name)
+	}
+	v, ok := setting.normalise(value)
+	if !ok {
+		return fmt.Errorf("invalid value for %s: %s", name, value)
+	}
+	s.saved.values[name][s.saved.index(setting)] = v
+	return nil
+}
+
+// Reset restores the default configuration.
+func (s *Sim) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved = s.defaultState()
+}
+
+// Dial returns the client end of a new in-memory connection to the
+// simulator.
+func (s *Sim) Dial() msp.Transport {
+	client, server := net.Pipe()
+	go func() {
+		s.Serve(server)
+		server.Close()
+	}()
+	return msp.NewTransport(client)
+}
+
+// Serve talks to a client over rw until reading from it fails. Like a real
+// FC it answers MSP requests until it receives a '#', then runs the CLI
+// until `exit` or `save`.
+func (s *Sim) Serve(rw io.ReadWriter) error {
+	c := &conn{
+		sim: s,
+		r:   bufio.NewReader(rw),
+		w:   rw,
+	}
+	for {
+		b, err := c.r.Peek(1)
+		if err != nil {
+			return err
+		}
+		switch b[0] {
+		case '$':
+			fr, err := msp.DecodeFrame(c.r)
+			var mspErr msp.MSPError
+			if errors.As(err, &mspErr) {
+				// Bad checksum, drop the frame like the FC would
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			reply := s.handleMSP(fr)
+			if reply == nil {
+				continue
+			}
+			if _, err := c.w.Write(reply); err != nil {
+				return err
+			}
+		case '#':
+			c.r.ReadByte()
+			if err := c.serveCLI(); err != nil {
+				return err
+			}
+		default:
+			c.r.ReadByte()
+		}
+	}
+}
+
+// conn is the state of one client connection.
+type conn struct {
+	sim *Sim
+	r   *bufio.Reader
+	w   io.Writer
+}
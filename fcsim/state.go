@@ -0,0 +1,210 @@
+package fcsim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lineCommand is a CLI command such as `serial` or `aux` whose lines are
+// stored as typed, keyed by their leading arguments, and printed back by
+// dump and diff.
+type lineCommand struct {
+	name    string
+	heading string
+	keyArgs int
+	minArgs int
+}
+
+// lineCommands is in the order dump prints them. feature is handled on its
+// own and printed after the mixers.
+var lineCommands = []lineCommand{
+	{"resource", "resources", 2, 3},
+	{"timer", "timer", 1, 2},
+	{"dma", "dma", 2, 3},
+	{"mixer", "mixer", 0, 1},
+	{"mmix", "mixer", 1, 5},
+	{"smix", "servo mixer", 1, 7},
+	{"beeper", "beeper", 1, 1},
+	{"beacon", "beacon", 1, 1},
+	{"map", "map", 0, 1},
+	{"serial", "serial", 1, 6},
+	{"led", "led", 1, 2},
+	{"color", "color", 1, 2},
+	{"mode_color", "mode_color", 2, 3},
+	{"aux", "aux", 1, 7},
+	{"adjrange", "adjrange", 1, 7},
+	{"rxrange", "rxrange", 1, 3},
+	{"vtxtable", "vtxtable", 1, 2},
+	{"rxfail", "rxfail", 1, 2},
+}
+
+func findLineCommand(name string) *lineCommand {
+	for ii := range lineCommands {
+		if lineCommands[ii].name == name {
+			return &lineCommands[ii]
+		}
+	}
+	return nil
+}
+
+// key returns the identity of a line, e.g. "serial 0" for
+// "serial 0 64 115200 57600 0 115200".
+func (c *lineCommand) key(args []string) string {
+	n := c.keyArgs
+	if c.name == "vtxtable" && len(args) > 0 && args[0] == "band" {
+		n = 2
+	}
+	keyArgs := make([]string, 0, n)
+	for _, a := range args[:n] {
+		if c.name == "beeper" || c.name == "beacon" {
+			a = strings.TrimPrefix(a, "-")
+		}
+		keyArgs = append(keyArgs, strings.ToUpper(a))
+	}
+	return strings.TrimSpace(c.name + " " + strings.Join(keyArgs, " "))
+}
+
+func defaultLines() []string {
+	lines := []string{
+		"resource BEEPER 1 C13",
+		"resource MOTOR 1 B04",
+		"resource MOTOR 2 B05",
+		"resource MOTOR 3 B00",
+		"resource MOTOR 4 B01",
+		"resource SERIAL_TX 1 A09",
+		"resource SERIAL_TX 2 A02",
+		"resource SERIAL_RX 1 A10",
+		"resource SERIAL_RX 2 A03",
+		"resource LED_STRIP 1 A08",
+		"resource ADC_BATT 1 C01",
+		"resource ADC_CURR 1 C02",
+		"timer B04 AF2",
+		"timer B05 AF2",
+		"timer B00 AF2",
+		"timer B01 AF2",
+		"timer A08 AF1",
+		"dma ADC 1 1",
+		"dma pin B04 0",
+		"dma pin B05 0",
+		"dma pin B00 0",
+		"dma pin B01 0",
+		"dma pin A08 0",
+		"mixer QUADX",
+		"map AETR1234",
+		"serial 20 1 115200 57600 0 115200",
+		"serial 0 0 115200 57600 0 115200",
+		"serial 1 64 115200 57600 0 115200",
+		"serial 2 0 115200 57600 0 115200",
+		"vtxtable bands 0",
+		"vtxtable channels 0",
+		"vtxtable powerlevels 0",
+	}
+	for ii := 0; ii < 20; ii++ {
+		lines = append(lines, fmt.Sprintf("aux %d 0 0 900 900 0 0", ii))
+	}
+	for ii := 0; ii < 30; ii++ {
+		lines = append(lines, fmt.Sprintf("adjrange %d 0 0 900 900 0 0 0 0", ii))
+	}
+	for ii := 0; ii < 3; ii++ {
+		lines = append(lines, fmt.Sprintf("rxrange %d 1000 2000", ii))
+	}
+	for ii := 0; ii < 18; ii++ {
+		mode := "h"
+		if ii < 4 {
+			mode = "a"
+		}
+		lines = append(lines, fmt.Sprintf("rxfail %d %s", ii, mode))
+	}
+	return lines
+}
+
+// state is one copy of the FC configuration. The simulator keeps the saved
+// state and a working copy for each CLI session.
+type state struct {
+	values      map[string][]string
+	features    map[string]bool
+	lines       map[string]string
+	order       []string
+	profile     int
+	rateProfile int
+}
+
+func (s *Sim) defaultState() *state {
+	st := &state{
+		values:   make(map[string][]string),
+		features: make(map[string]bool),
+		lines:    make(map[string]string),
+	}
+	for _, setting := range s.settings {
+		n := 1
+		switch setting.Section {
+		case SectionProfile:
+			n = ProfileCount
+		case SectionRateProfile:
+			n = RateProfileCount
+		}
+		vals := make([]string, n)
+		for ii := range vals {
+			vals[ii] = setting.Default
+		}
+		st.values[setting.Name] = vals
+	}
+	for _, f := range defaultFeatures {
+		st.features[f] = true
+	}
+	for _, line := range s.defaultLines {
+		st.setLine(line)
+	}
+	return st
+}
+
+func (st *state) clone() *state {
+	c := &state{
+		values:      make(map[string][]string, len(st.values)),
+		features:    make(map[string]bool, len(st.features)),
+		lines:       make(map[string]string, len(st.lines)),
+		order:       append([]string(nil), st.order...),
+		profile:     st.profile,
+		rateProfile: st.rateProfile,
+	}
+	for k, v := range st.values {
+		c.values[k] = append([]string(nil), v...)
+	}
+	for k, v := range st.features {
+		c.features[k] = v
+	}
+	for k, v := range st.lines {
+		c.lines[k] = v
+	}
+	return c
+}
+
+// setLine stores a line of one of the lineCommands. It returns false if the
+// line isn't one or doesn't have enough arguments.
+func (st *state) setLine(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	cmd := findLineCommand(fields[0])
+	if cmd == nil || len(fields)-1 < cmd.minArgs {
+		return false
+	}
+	key := cmd.key(fields[1:])
+	if _, ok := st.lines[key]; !ok {
+		st.order = append(st.order, key)
+	}
+	st.lines[key] = strings.Join(fields, " ")
+	return true
+}
+
+// index returns which copy of a setting's value is current.
+func (st *state) index(setting *Setting) int {
+	switch setting.Section {
+	case SectionProfile:
+		return st.profile
+	case SectionRateProfile:
+		return st.rateProfile
+	}
+	return 0
+}
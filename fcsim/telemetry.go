@@ -0,0 +1,145 @@
+package fcsim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/robhaswell/btflcli/msp"
+)
+
+// boxNames are the modes the simulator has, in the order of the flight mode
+// flags.
+var boxNames = []string{"ARM", "ANGLE", "HORIZON", "HEADFREE", "BEEPER", "OSD DISABLE", "FLIP OVER AFTER CRASH", "PARALYZE"}
+
+const (
+	// angleMode is the bit of ANGLE in the flight mode flags
+	angleMode = 1 << 1
+
+	armingDisableFlagCount = 26
+	// armingDisableFailsafe and armingDisableRxLoss are set while there is
+	// no receiver
+	armingDisableFailsafe = 1 << 1
+	armingDisableRxLoss   = 1 << 2
+
+	cellCount       = 4
+	batteryCapacity = 1300
+	// batteryVoltage is in hundredths of a volt
+	batteryVoltage = 1648
+	// batteryCurrent is in hundredths of an amp
+	batteryCurrent = 52
+	mAhDrawn       = 120
+
+	rcChannelCount = 16
+	// rxTimeout is how long after the last MSP_SET_RAW_RC the receiver is
+	// lost
+	rxTimeout  = 500 * time.Millisecond
+	motorCount = 4
+	// motorStop is the output to a motor of a disarmed craft
+	motorStop = 1000
+	// accOneG is the accelerometer reading at 1g in MSP_RAW_IMU
+	accOneG = 512
+)
+
+// defaultRC returns the RC channels with the sticks centred and the throttle
+// and switches low.
+func defaultRC() []uint16 {
+	rc := make([]uint16, rcChannelCount)
+	for ii := range rc {
+		rc[ii] = 1000
+	}
+	rc[0], rc[1], rc[2] = 1500, 1500, 1500
+	return rc
+}
+
+// rxMap is the order of the channels sent by the receiver, AETR1234, as the
+// channel of roll, pitch, yaw, throttle and AUX 1 to 4.
+var rxMap = []byte{0, 1, 3, 2, 4, 5, 6, 7}
+
+// setRawRC takes the channels sent by an MSP receiver, which are in the
+// order of rxMap.
+func (s *Sim) setRawRC(fr *msp.MSPFrame) {
+	raw := make([]uint16, fr.BytesRemaining()/2)
+	fr.Read(raw)
+	for ii := range s.rc {
+		ch := ii
+		if ii < len(rxMap) {
+			ch = int(rxMap[ii])
+		}
+		if ch < len(raw) {
+			s.rc[ii] = raw[ch]
+		}
+	}
+	s.rcReceived = time.Now()
+}
+
+// writeTelemetry writes the reply to a telemetry request, returning false
+// if code isn't one. The craft sways gently so that readings change over
+// time.
+func (s *Sim) writeTelemetry(code uint16, buf *bytes.Buffer) bool {
+	t := time.Since(s.started).Seconds()
+	le := binary.LittleEndian
+	switch code {
+	case msp.MspStatusEx:
+		var armingDisabled uint32
+		if time.Since(s.rcReceived) > rxTimeout {
+			armingDisabled = armingDisableFailsafe | armingDisableRxLoss
+		}
+		binary.Write(buf, le, uint16(125))      // cycle time
+		binary.Write(buf, le, uint16(0))        // I2C errors
+		binary.Write(buf, le, uint16(1|2|1<<5)) // ACC, BARO and GYRO
+		binary.Write(buf, le, uint32(angleMode))
+		buf.WriteByte(byte(s.saved.profile))
+		binary.Write(buf, le, uint16(12)) // CPU load
+		buf.WriteByte(4)                  // profile count
+		buf.WriteByte(byte(s.saved.rateProfile))
+		buf.WriteByte(0) // no more flight mode flags
+		buf.WriteByte(armingDisableFlagCount)
+		binary.Write(buf, le, armingDisabled)
+		buf.WriteByte(0) // configuration state
+	case msp.MspBoxNames:
+		buf.WriteString(strings.Join(boxNames, ";") + ";")
+	case msp.MspAnalog:
+		buf.WriteByte(byte((batteryVoltage + 5) / 10))
+		binary.Write(buf, le, uint16(mAhDrawn))
+		binary.Write(buf, le, uint16(0)) // RSSI
+		binary.Write(buf, le, int16(batteryCurrent))
+		binary.Write(buf, le, uint16(batteryVoltage))
+	case msp.MspBatteryState:
+		buf.WriteByte(cellCount)
+		binary.Write(buf, le, uint16(batteryCapacity))
+		buf.WriteByte(byte((batteryVoltage + 5) / 10))
+		binary.Write(buf, le, uint16(mAhDrawn))
+		binary.Write(buf, le, int16(batteryCurrent))
+		buf.WriteByte(0) // OK
+		binary.Write(buf, le, uint16(batteryVoltage))
+	case msp.MspAttitude:
+		binary.Write(buf, le, int16(150*math.Sin(t))) // roll, tenths of a degree
+		binary.Write(buf, le, int16(100*math.Cos(t))) // pitch, tenths of a degree
+		binary.Write(buf, le, int16(int(10*t)%360))   // heading
+	case msp.MspRawIMU:
+		roll, pitch := 15*math.Sin(t)*math.Pi/180, 10*math.Cos(t)*math.Pi/180
+		binary.Write(buf, le, int16(-accOneG*math.Sin(pitch)))
+		binary.Write(buf, le, int16(accOneG*math.Sin(roll)))
+		binary.Write(buf, le, int16(accOneG*math.Cos(roll)*math.Cos(pitch)))
+		binary.Write(buf, le, int16(15*math.Cos(t))) // the rates of the sway, °/s
+		binary.Write(buf, le, int16(-10*math.Sin(t)))
+		binary.Write(buf, le, int16(10))
+		binary.Write(buf, le, [3]int16{}) // no magnetometer
+	case msp.MspRC:
+		binary.Write(buf, le, s.rc)
+	case msp.MspMotor:
+		for ii := 0; ii < 8; ii++ {
+			if ii < motorCount {
+				binary.Write(buf, le, uint16(motorStop))
+			} else {
+				binary.Write(buf, le, uint16(0))
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,275 @@
+// Package history keeps configuration dumps in a git repository, one
+// directory per craft, using a pure Go git implementation so no git binary
+// is needed.
+package history
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ErrNotFound is returned when a file doesn't exist at a revision.
+var ErrNotFound = errors.New("file not found")
+
+// defaultAuthor signs commits when there is no user in the git config.
+var defaultAuthor = object.Signature{Name: "btfl", Email: "btfl@localhost"}
+
+// Repo is a history repository.
+type Repo struct {
+	repo *git.Repository
+	dir  string
+}
+
+// Revision is a commit in the history.
+type Revision struct {
+	Hash    string
+	Time    time.Time
+	Author  string
+	Message string
+}
+
+// Short returns the abbreviated hash of the revision.
+func (r *Revision) Short() string {
+	if len(r.Hash) < 7 {
+		return r.Hash
+	}
+	return r.Hash[:7]
+}
+
+// Subject returns the first line of the commit message.
+func (r *Revision) Subject() string {
+	subject, _, _ := strings.Cut(r.Message, "\n")
+	return subject
+}
+
+// Open opens the history repository in dir.
+func Open(dir string) (*Repo, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening history in %s: %w", dir, err)
+	}
+	return &Repo{repo: repo, dir: dir}, nil
+}
+
+// OpenOrInit opens the history repository in dir, creating it if it doesn't
+// exist yet. It refuses to create one inside the work tree of another
+// repository.
+func OpenOrInit(dir string) (*Repo, error) {
+	repo, err := git.PlainOpen(dir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		if err := checkNotInRepo(dir); err != nil {
+			return nil, err
+		}
+		repo, err = git.PlainInit(dir, false)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening history in %s: %w", dir, err)
+	}
+	return &Repo{repo: repo, dir: dir}, nil
+}
+
+// checkNotInRepo returns an error if dir is inside the work tree of a git
+// repository.
+func checkNotInRepo(dir string) error {
+	outer, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("opening history in %s: %w", dir, err)
+	}
+	root := "another repository"
+	if wt, err := outer.Worktree(); err == nil {
+		root = wt.Filesystem.Root()
+	}
+	return fmt.Errorf("not creating a history repository in %s, which is inside %s", dir, root)
+}
+
+// Dir returns the directory of the repository's work tree.
+func (r *Repo) Dir() string {
+	return r.dir
+}
+
+// Commit commits the given files, which are slash separated paths relative
+// to the repository, and returns the new revision.
+func (r *Repo) Commit(files []string, message string) (*Revision, error) {
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if _, err := wt.Add(f); err != nil {
+			return nil, fmt.Errorf("adding %s: %w", f, err)
+		}
+	}
+	sig := author()
+	sig.When = time.Now()
+	hash, err := wt.Commit(message, &git.CommitOptions{Author: &sig})
+	if err != nil {
+		return nil, err
+	}
+	return r.revision(hash)
+}
+
+// author returns the user from the global git config, if there is one.
+func author() object.Signature {
+	cfg, err := gitconfig.LoadConfig(gitconfig.GlobalScope)
+	if err != nil || cfg.User.Name == "" {
+		return defaultAuthor
+	}
+	sig := object.Signature{Name: cfg.User.Name, Email: cfg.User.Email}
+	if sig.Email == "" {
+		sig.Email = defaultAuthor.Email
+	}
+	return sig
+}
+
+func (r *Repo) revision(hash plumbing.Hash) (*Revision, error) {
+	c, err := r.repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	return newRevision(c), nil
+}
+
+func newRevision(c *object.Commit) *Revision {
+	return &Revision{
+		Hash:    c.Hash.String(),
+		Time:    c.Author.When,
+		Author:  c.Author.Name,
+		Message: strings.TrimSpace(c.Message),
+	}
+}
+
+// Log returns the revisions which changed anything in a directory, newest
+// first. An empty dir matches the whole repository.
+func (r *Repo) Log(dir string) ([]*Revision, error) {
+	if head, err := r.Head(); err != nil || head == nil {
+		// Nothing has been committed yet
+		return nil, err
+	}
+	opts := &git.LogOptions{}
+	if dir != "" {
+		prefix := strings.TrimSuffix(dir, "/") + "/"
+		opts.PathFilter = func(p string) bool {
+			return strings.HasPrefix(p, prefix)
+		}
+	}
+	iter, err := r.repo.Log(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	var revs []*Revision
+	err = iter.ForEach(func(c *object.Commit) error {
+		revs = append(revs, newRevision(c))
+		return nil
+	})
+	return revs, err
+}
+
+// Resolve returns the revision named by a hash, abbreviated hash or
+// reference such as HEAD~2.
+func (r *Repo) Resolve(rev string) (*Revision, error) {
+	hash, err := r.repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("unknown revision %q: %w", rev, err)
+	}
+	return r.revision(*hash)
+}
+
+func (r *Repo) tree(rev string) (*object.Tree, error) {
+	resolved, err := r.Resolve(rev)
+	if err != nil {
+		return nil, err
+	}
+	c, err := r.repo.CommitObject(plumbing.NewHash(resolved.Hash))
+	if err != nil {
+		return nil, err
+	}
+	return c.Tree()
+}
+
+// ReadFile returns the contents of a file at a revision.
+func (r *Repo) ReadFile(rev, name string) ([]byte, error) {
+	tree, err := r.tree(rev)
+	if err != nil {
+		return nil, err
+	}
+	f, err := tree.File(name)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, fmt.Errorf("%s at %s: %w", name, rev, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rd, err := f.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return io.ReadAll(rd)
+}
+
+// Files returns the paths of the files directly in a directory at a
+// revision, sorted by name.
+func (r *Repo) Files(rev, dir string) ([]string, error) {
+	tree, err := r.tree(rev)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := tree.Tree(dir)
+	if errors.Is(err, object.ErrDirectoryNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range sub.Entries {
+		if e.Mode.IsFile() {
+			files = append(files, path.Join(dir, e.Name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Dirs returns the directories at the top of the repository at a revision,
+// which are the crafts.
+func (r *Repo) Dirs(rev string) ([]string, error) {
+	tree, err := r.tree(rev)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, e := range tree.Entries {
+		if !e.Mode.IsFile() {
+			dirs = append(dirs, e.Name)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// Head returns the latest revision, or nil if nothing has been committed.
+func (r *Repo) Head() (*Revision, error) {
+	ref, err := r.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.revision(ref.Hash())
+}
@@ -0,0 +1,187 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenOrInit(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "quads")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenOrInit(dir); err != nil {
+		t.Fatal(err)
+	}
+	// Opening it again finds the same repository
+	if _, err := OpenOrInit(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory inside its work tree isn't made into another one
+	inner := filepath.Join(dir, "My Quad")
+	if err := os.Mkdir(inner, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenOrInit(inner); err == nil {
+		t.Error("created a repository inside another")
+	}
+	if _, err := os.Stat(filepath.Join(inner, ".git")); !os.IsNotExist(err) {
+		t.Errorf("%s has a .git: %v", inner, err)
+	}
+}
+
+// commitFiles writes files, keyed by slash separated path, into the work
+// tree and commits them.
+func commitFiles(t *testing.T, repo *Repo, message string, files map[string]string) *Revision {
+	t.Helper()
+	var names []string
+	for name, content := range files {
+		full := filepath.Join(repo.Dir(), filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	rev, err := repo.Commit(names, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rev
+}
+
+func newRepo(t *testing.T) *Repo {
+	t.Helper()
+	repo, err := OpenOrInit(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestCommit(t *testing.T) {
+	repo := newRepo(t)
+	if head, err := repo.Head(); err != nil || head != nil {
+		t.Fatalf("new repository has head %v, %v", head, err)
+	}
+	if revs, err := repo.Log(""); err != nil || revs != nil {
+		t.Fatalf("new repository has log %v, %v", revs, err)
+	}
+
+	rev := commitFiles(t, repo, "Quad: first\n\nBody\n", map[string]string{"Quad/BTFL_4.5.0_DIFF.txt": "set p_pitch = 50\r\n"})
+	if rev.Subject() != "Quad: first" || rev.Message != "Quad: first\n\nBody" {
+		t.Errorf("got subject %q, message %q", rev.Subject(), rev.Message)
+	}
+	if len(rev.Hash) != 40 || rev.Short() != rev.Hash[:7] {
+		t.Errorf("got hash %q, short %q", rev.Hash, rev.Short())
+	}
+	head, err := repo.Head()
+	if err != nil || head == nil || head.Hash != rev.Hash {
+		t.Errorf("head is %v, %v, want %s", head, err, rev.Hash)
+	}
+
+	// The same repository is seen by opening it again
+	reopened, err := Open(repo.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head, err := reopened.Head(); err != nil || head.Hash != rev.Hash {
+		t.Errorf("reopened head is %v, %v, want %s", head, err, rev.Hash)
+	}
+	if _, err := Open(t.TempDir()); err == nil {
+		t.Error("opened a directory without a repository")
+	}
+}
+
+func TestLog(t *testing.T) {
+	repo := newRepo(t)
+	first := commitFiles(t, repo, "A: 1", map[string]string{"A/diff.txt": "1"})
+	second := commitFiles(t, repo, "AB: 2", map[string]string{"AB/diff.txt": "2"})
+	third := commitFiles(t, repo, "A: 3", map[string]string{"A/diff.txt": "3", "A/dump.txt": "3"})
+
+	tests := []struct {
+		dir  string
+		want []*Revision
+	}{
+		{"", []*Revision{third, second, first}},
+		{"A", []*Revision{third, first}},
+		{"A/", []*Revision{third, first}},
+		{"AB", []*Revision{second}},
+		{"C", nil},
+	}
+	for _, tt := range tests {
+		revs, err := repo.Log(tt.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, want []string
+		for _, r := range revs {
+			got = append(got, r.Subject())
+		}
+		for _, r := range tt.want {
+			want = append(want, r.Subject())
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("log of %q is %q, want %q", tt.dir, got, want)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	repo := newRepo(t)
+	first := commitFiles(t, repo, "1", map[string]string{"A/diff.txt": "one"})
+	commitFiles(t, repo, "2", map[string]string{"A/diff.txt": "two"})
+
+	tests := []struct {
+		rev, name, want string
+	}{
+		{first.Hash, "A/diff.txt", "one"},
+		{first.Short(), "A/diff.txt", "one"},
+		{"HEAD", "A/diff.txt", "two"},
+		{"HEAD~1", "A/diff.txt", "one"},
+	}
+	for _, tt := range tests {
+		b, err := repo.ReadFile(tt.rev, tt.name)
+		if err != nil || string(b) != tt.want {
+			t.Errorf("%s at %s is %q, %v, want %q", tt.name, tt.rev, b, err, tt.want)
+		}
+	}
+	if _, err := repo.ReadFile("HEAD", "A/missing.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing file gave %v", err)
+	}
+	if _, err := repo.ReadFile("HEAD~5", "A/diff.txt"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown revision gave %v", err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	repo := newRepo(t)
+	first := commitFiles(t, repo, "1", map[string]string{"B/b.txt": "", "A/z.txt": ""})
+	commitFiles(t, repo, "2", map[string]string{"A/a.txt": "", "A/sub/c.txt": "", "top.txt": ""})
+
+	tests := []struct {
+		rev, dir string
+		want     []string
+	}{
+		{"HEAD", "A", []string{"A/a.txt", "A/z.txt"}},
+		{first.Hash, "A", []string{"A/z.txt"}},
+		{"HEAD", "A/sub", []string{"A/sub/c.txt"}},
+		{"HEAD", "C", nil},
+	}
+	for _, tt := range tests {
+		files, err := repo.Files(tt.rev, tt.dir)
+		if err != nil || !reflect.DeepEqual(files, tt.want) {
+			t.Errorf("files in %s at %s are %q, %v, want %q", tt.dir, tt.rev, files, err, tt.want)
+		}
+	}
+	if dirs, err := repo.Dirs("HEAD"); err != nil || !reflect.DeepEqual(dirs, []string{"A", "B"}) {
+		t.Errorf("dirs are %q, %v", dirs, err)
+	}
+}
@@ -0,0 +1,153 @@
+// Package migrate upgrades Betaflight configurations between firmware
+// releases, renaming, rescaling, moving and dropping settings as the
+// releases in between did.
+package migrate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/robhaswell/btflcli/config"
+)
+
+// Note is something the user should know about a migrated configuration,
+// such as a setting that was dropped or needs checking by hand.
+type Note struct {
+	Version config.Version
+	Section config.Section
+	Setting string
+	Message string
+}
+
+func (n Note) String() string {
+	return fmt.Sprintf("%s: %s: %s: %s", n.Version, n.Section, n.Setting, n.Message)
+}
+
+// Migrate upgrades a configuration from one firmware version to another by
+// applying every rule for the releases after from up to and including to.
+// If from is zero the version in the configuration's header is used. The
+// version line is updated to the new version.
+func Migrate(c *config.Config, from, to config.Version) ([]Note, error) {
+	if from.IsZero() {
+		from = c.Header.Version
+	}
+	if from.IsZero() {
+		return nil, fmt.Errorf("the configuration doesn't say which firmware version it is for")
+	}
+	if to.Compare(from) < 0 {
+		return nil, fmt.Errorf("can't migrate from %s back to %s", from, to)
+	}
+
+	var notes []Note
+	for _, r := range Rules {
+		if r.Version.Compare(from) <= 0 || r.Version.Compare(to) > 0 {
+			continue
+		}
+		notes = append(notes, r.apply(c)...)
+	}
+	c.SetVersion(to)
+	return notes, nil
+}
+
+// Newest returns the newest release there are rules for. Settings only change
+// in minor releases, so a migration to a later patch release of the same
+// minor release is covered too.
+func Newest() config.Version {
+	var newest config.Version
+	for _, r := range Rules {
+		if r.Version.Compare(newest) > 0 {
+			newest = r.Version
+		}
+	}
+	return newest
+}
+
+// Covers reports whether the rules know about every change up to version.
+func Covers(version config.Version) bool {
+	newest := Newest()
+	return version.Major < newest.Major || version.Major == newest.Major && version.Minor <= newest.Minor
+}
+
+func (r *Rule) apply(c *config.Config) []Note {
+	var notes []Note
+	note := func(section config.Section, format string, a ...interface{}) {
+		notes = append(notes, Note{
+			Version: r.Version,
+			Section: section,
+			Setting: r.Setting,
+			Message: fmt.Sprintf(format, a...),
+		})
+	}
+	if r.Func != nil {
+		for _, section := range c.Sections() {
+			if msg := r.Func(c, section); msg != "" {
+				note(section, "%s", msg)
+			}
+		}
+		return notes
+	}
+	for _, section := range c.Sections() {
+		value, ok := c.Get(section, r.Setting)
+		if !ok {
+			continue
+		}
+		switch r.Action {
+		case Rename:
+			c.Rename(section, r.Setting, r.To)
+			if r.Values != nil {
+				if v, ok := r.Values[strings.ToUpper(value)]; ok {
+					c.Set(section, r.To, v)
+				} else {
+					note(section, "renamed to %s, but %s is not a known value", r.To, value)
+				}
+			}
+		case Scale:
+			scaled, err := scale(value, r.Factor)
+			if err != nil {
+				note(section, "%s is not a number, left unchanged", value)
+				continue
+			}
+			c.Set(section, r.Setting, scaled)
+		case Remove:
+			c.Unset(section, r.Setting)
+			msg := fmt.Sprintf("removed (was %s)", value)
+			if r.Note != "" {
+				msg += ", " + r.Note
+			}
+			note(section, "%s", msg)
+			continue
+		case MoveToProfile:
+			c.Unset(section, r.Setting)
+			profiles := c.Profiles()
+			if len(profiles) == 0 {
+				profiles = []int{0}
+			}
+			for _, p := range profiles {
+				ps := config.Section{Kind: config.Profile, Index: p}
+				if existing, ok := c.Get(ps, r.Setting); ok && existing != value {
+					note(ps, "already set to %s, dropped %s from %s", existing, value, section)
+					continue
+				}
+				c.Set(ps, r.Setting, value)
+			}
+		}
+		if r.Note != "" {
+			note(section, "%s", r.Note)
+		}
+	}
+	return notes
+}
+
+// scale multiplies a number, keeping it an integer if it was one.
+func scale(value string, factor float64) (string, error) {
+	if n, err := strconv.Atoi(value); err == nil {
+		return strconv.Itoa(int(math.Round(float64(n) * factor))), nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(f*factor, 'f', -1, 64), nil
+}
@@ -0,0 +1,252 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/robhaswell/btflcli/config"
+)
+
+const testConfig = "# version\r\n" +
+	"# Betaflight / STM32F405 (S405) 4.2.11 Nov  9 2021 / 06:26:55 (948ba6339) MSP API: 1.43\r\n" +
+	"set vbat_max_cell_voltage = 43\r\n" +
+	"set rc_smoothing_type = FILTER\r\n" +
+	"set gyro_lowpass_hz = 200\r\n" +
+	"profile 0\r\n" +
+	"set p_pitch = 52\r\n" +
+	"set ff_interpolate_sp = AVERAGED_2\r\n" +
+	"set anti_gravity_gain = 3500\r\n" +
+	"profile 1\r\n" +
+	"set ff_interpolate_sp = SOMETHING\r\n" +
+	"set anti_gravity_gain = 4000\r\n" +
+	"rateprofile 0\r\n" +
+	"set tpa_rate = 65\r\n" +
+	"set thr_expo = 0.5\r\n"
+
+var (
+	master   = config.MasterSection
+	profile0 = config.Section{Kind: config.Profile, Index: 0}
+	profile1 = config.Section{Kind: config.Profile, Index: 1}
+	rates0   = config.Section{Kind: config.RateProfile, Index: 0}
+)
+
+type setting struct {
+	section config.Section
+	name    string
+	value   string
+	found   bool
+}
+
+func TestRuleActions(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     Rule
+		settings []setting
+		notes    []string
+	}{
+		{
+			name: "rename",
+			rule: rename("4.3.0", "gyro_lowpass_hz", "gyro_lpf1_static_hz"),
+			settings: []setting{
+				{master, "gyro_lowpass_hz", "", false},
+				{master, "gyro_lpf1_static_hz", "200", true},
+			},
+		},
+		{
+			name: "rename with values",
+			rule: Rule{Version: v("4.3.0"), Setting: "ff_interpolate_sp", Action: Rename, To: "feedforward_averaging", Values: map[string]string{
+				"AVERAGED_2": "2_POINT",
+			}},
+			settings: []setting{
+				{profile0, "ff_interpolate_sp", "", false},
+				{profile0, "feedforward_averaging", "2_POINT", true},
+				{profile1, "feedforward_averaging", "SOMETHING", true},
+			},
+			notes: []string{"4.3.0: profile 1: ff_interpolate_sp: renamed to feedforward_averaging, but SOMETHING is not a known value"},
+		},
+		{
+			name: "remove",
+			rule: remove("4.3.0", "rc_smoothing_type", "RC smoothing is always a filter"),
+			settings: []setting{
+				{master, "rc_smoothing_type", "", false},
+				{master, "vbat_max_cell_voltage", "43", true},
+			},
+			notes: []string{"4.3.0: master: rc_smoothing_type: removed (was FILTER), RC smoothing is always a filter"},
+		},
+		{
+			name: "warn",
+			rule: warn("4.4.0", "anti_gravity_gain", "check it"),
+			settings: []setting{
+				{profile0, "anti_gravity_gain", "3500", true},
+				{profile1, "anti_gravity_gain", "4000", true},
+			},
+			notes: []string{
+				"4.4.0: profile 0: anti_gravity_gain: check it",
+				"4.4.0: profile 1: anti_gravity_gain: check it",
+			},
+		},
+		{
+			name:     "scale an integer",
+			rule:     Rule{Version: v("4.0.0"), Setting: "vbat_max_cell_voltage", Action: Scale, Factor: 10},
+			settings: []setting{{master, "vbat_max_cell_voltage", "430", true}},
+		},
+		{
+			name:     "scale a decimal",
+			rule:     Rule{Version: v("4.0.0"), Setting: "thr_expo", Action: Scale, Factor: 3},
+			settings: []setting{{rates0, "thr_expo", "1.5", true}},
+		},
+		{
+			name:     "scale a word",
+			rule:     Rule{Version: v("4.0.0"), Setting: "rc_smoothing_type", Action: Scale, Factor: 10},
+			settings: []setting{{master, "rc_smoothing_type", "FILTER", true}},
+			notes:    []string{"4.0.0: master: rc_smoothing_type: FILTER is not a number, left unchanged"},
+		},
+		{
+			name: "move to profile",
+			rule: Rule{Version: v("4.3.0"), Setting: "tpa_rate", Action: MoveToProfile},
+			settings: []setting{
+				{rates0, "tpa_rate", "", false},
+				{profile0, "tpa_rate", "65", true},
+				{profile1, "tpa_rate", "65", true},
+			},
+		},
+		{
+			name: "func",
+			rule: Rule{Version: v("4.3.0"), Setting: "simplified_pids_mode", Func: keepManualPIDs},
+			settings: []setting{
+				{profile0, "simplified_pids_mode", "OFF", true},
+				{profile1, "simplified_pids_mode", "", false},
+			},
+			notes: []string{"4.3.0: profile 0: simplified_pids_mode: turned off so the PID gains set by hand (p_pitch) are kept"},
+		},
+		{
+			name:     "setting not in the configuration",
+			rule:     remove("4.3.0", "dyn_notch_range", "use dyn_notch_min_hz and dyn_notch_max_hz"),
+			settings: []setting{{master, "dyn_notch_range", "", false}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := config.ParseString(testConfig)
+			var notes []string
+			for _, n := range tt.rule.apply(c) {
+				notes = append(notes, n.String())
+			}
+			if !reflect.DeepEqual(notes, tt.notes) {
+				t.Errorf("got notes %q, want %q", notes, tt.notes)
+			}
+			for _, s := range tt.settings {
+				value, found := c.Get(s.section, s.name)
+				if value != s.value || found != s.found {
+					t.Errorf("%s in %s is %q, %v, want %q, %v", s.name, s.section, value, found, s.value, s.found)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	c := config.ParseString(testConfig)
+	notes, err := Migrate(c, config.Version{}, v("4.3.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range notes {
+		if n.Version.Compare(v("4.2.11")) <= 0 || n.Version.Compare(v("4.3.0")) > 0 {
+			t.Errorf("note %q is from a release outside the migration", n)
+		}
+	}
+	// 4.0.0 is older than the configuration, so the voltage isn't rescaled
+	if value, _ := c.Get(master, "vbat_max_cell_voltage"); value != "43" {
+		t.Errorf("vbat_max_cell_voltage is %q", value)
+	}
+	if value, _ := c.Get(profile0, "feedforward_averaging"); value != "2_POINT" {
+		t.Errorf("feedforward_averaging is %q", value)
+	}
+	// 4.4.0 is newer than the target, so its rename isn't applied
+	if _, found := c.Get(profile0, "anti_gravity_mode"); found {
+		t.Error("anti_gravity_mode was added")
+	}
+	if c.Header.Version != v("4.3.0") {
+		t.Errorf("version is %s", c.Header.Version)
+	}
+	if !strings.Contains(c.String(), " 4.3.0 ") {
+		t.Errorf("the version line wasn't updated:\n%s", c)
+	}
+}
+
+func TestMigrate43To45(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("..", "config", "testdata", "BTFL_4.3.2_DIFF.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := config.ParseBytes(b)
+	notes, err := Migrate(c, config.Version{}, v("4.5.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []setting{
+		{master, "rc_smoothing_auto_factor", "", false},
+		{master, "rc_smoothing_auto_factor_setpoint", "45", true},
+		{master, "dshot_idle_value", "", false},
+		{master, "motor_idle", "450", true},
+		{profile0, "anti_gravity_gain", "4000", true},
+	} {
+		if value, found := c.Get(s.section, s.name); value != s.value || found != s.found {
+			t.Errorf("%s %s is %q, %v, want %q, %v", s.section, s.name, value, found, s.value, s.found)
+		}
+	}
+	var got []string
+	for _, n := range notes {
+		got = append(got, n.String())
+	}
+	want := []string{"4.4.0: profile 0: anti_gravity_gain: the range changed, check the value against the new default"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got notes %q, want %q", got, want)
+	}
+	if !strings.Contains(c.String(), " 4.5.0 ") {
+		t.Errorf("the version line wasn't updated:\n%s", c)
+	}
+
+	// Migrating again finds nothing to do
+	notes, err = Migrate(c, config.Version{}, v("4.5.0"))
+	if err != nil || len(notes) != 0 {
+		t.Errorf("got notes %v, %v", notes, err)
+	}
+}
+
+func TestMigrateErrors(t *testing.T) {
+	if _, err := Migrate(config.ParseString(testConfig), config.Version{}, v("4.1.0")); err == nil {
+		t.Error("migrated backwards")
+	}
+	if _, err := Migrate(config.ParseString("set p_pitch = 50\r\n"), config.Version{}, v("4.4.0")); err == nil {
+		t.Error("migrated a configuration without a version")
+	}
+}
+
+func TestCovers(t *testing.T) {
+	newest := Newest()
+	if newest != v("4.5.0") {
+		t.Errorf("newest rule is for %s", newest)
+	}
+	tests := []struct {
+		version string
+		covered bool
+	}{
+		{"4.3.0", true},
+		{"4.4.0", true},
+		{"4.4.2", true},
+		{"4.5.0", true},
+		{"4.5.1", true},
+		{"4.6.0", false},
+		{"5.0.0", false},
+	}
+	for _, tt := range tests {
+		if got := Covers(v(tt.version)); got != tt.covered {
+			t.Errorf("Covers(%s) is %v", tt.version, got)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package migrate
+
+import (
+	"strings"
+
+	"github.com/robhaswell/btflcli/config"
+)
+
+// Action is what a rule does to a setting.
+type Action int
+
+const (
+	// Warn leaves the setting alone but adds the rule's note
+	Warn Action = iota
+	// Rename renames the setting to To, translating its value through
+	// Values if given
+	Rename
+	// Scale multiplies the value by Factor
+	Scale
+	// Remove drops the setting
+	Remove
+	// MoveToProfile moves a rate profile setting into every PID profile
+	MoveToProfile
+)
+
+// Rule is a change made to a setting by a firmware release.
+type Rule struct {
+	// Version is the release which made the change
+	Version config.Version
+	Setting string
+	Action  Action
+	To      string
+	Values  map[string]string
+	Factor  float64
+	// Note is shown wherever the rule is applied
+	Note string
+	// Func, if set, is called for every section instead of applying
+	// Action, and returns a note or ""
+	Func func(c *config.Config, section config.Section) string
+}
+
+func v(s string) config.Version {
+	version, err := config.ParseVersion(s)
+	if err != nil {
+		panic(err)
+	}
+	return version
+}
+
+func rename(version, from, to string) Rule {
+	return Rule{Version: v(version), Setting: from, Action: Rename, To: to}
+}
+
+func remove(version, name, note string) Rule {
+	return Rule{Version: v(version), Setting: name, Action: Remove, Note: note}
+}
+
+func warn(version, name, note string) Rule {
+	return Rule{Version: v(version), Setting: name, Action: Warn, Note: note}
+}
+
+// Rules are the changes made by each release, oldest first. Rules for the
+// same release are applied in order, so a rename must come before any rule
+// for the new name.
+var Rules = []Rule{
+	// Cell voltages went from 0.1V to 0.01V steps
+	{Version: v("4.0.0"), Setting: "vbat_max_cell_voltage", Action: Scale, Factor: 10},
+	{Version: v("4.0.0"), Setting: "vbat_min_cell_voltage", Action: Scale, Factor: 10},
+	{Version: v("4.0.0"), Setting: "vbat_warning_cell_voltage", Action: Scale, Factor: 10},
+
+	// Filters were renamed to lpf1/lpf2
+	rename("4.3.0", "gyro_lowpass_type", "gyro_lpf1_type"),
+	rename("4.3.0", "gyro_lowpass_hz", "gyro_lpf1_static_hz"),
+	rename("4.3.0", "gyro_lowpass2_type", "gyro_lpf2_type"),
+	rename("4.3.0", "gyro_lowpass2_hz", "gyro_lpf2_static_hz"),
+	rename("4.3.0", "dyn_lpf_gyro_min_hz", "gyro_lpf1_dyn_min_hz"),
+	rename("4.3.0", "dyn_lpf_gyro_max_hz", "gyro_lpf1_dyn_max_hz"),
+	rename("4.3.0", "dterm_lowpass_type", "dterm_lpf1_type"),
+	rename("4.3.0", "dterm_lowpass_hz", "dterm_lpf1_static_hz"),
+	rename("4.3.0", "dterm_lowpass2_type", "dterm_lpf2_type"),
+	rename("4.3.0", "dterm_lowpass2_hz", "dterm_lpf2_static_hz"),
+	rename("4.3.0", "dyn_lpf_dterm_min_hz", "dterm_lpf1_dyn_min_hz"),
+	rename("4.3.0", "dyn_lpf_dterm_max_hz", "dterm_lpf1_dyn_max_hz"),
+	rename("4.3.0", "dyn_lpf_curve_expo", "dterm_lpf1_dyn_expo"),
+	remove("4.3.0", "dyn_notch_width_percent", "use dyn_notch_count to set the number of notches"),
+	remove("4.3.0", "dyn_notch_range", "use dyn_notch_min_hz and dyn_notch_max_hz"),
+
+	// Feedforward settings lost their ff_ abbreviation
+	{Version: v("4.3.0"), Setting: "ff_interpolate_sp", Action: Rename, To: "feedforward_averaging", Values: map[string]string{
+		"OFF":        "OFF",
+		"ON":         "OFF",
+		"AVERAGED_2": "2_POINT",
+		"AVERAGED_3": "3_POINT",
+		"AVERAGED_4": "4_POINT",
+	}},
+	rename("4.3.0", "ff_smooth_factor", "feedforward_smooth_factor"),
+	rename("4.3.0", "ff_boost", "feedforward_boost"),
+	rename("4.3.0", "ff_max_rate_limit", "feedforward_max_rate_limit"),
+	remove("4.3.0", "ff_spike_limit", "feedforward_jitter_factor does a similar job"),
+
+	// RC smoothing is always a filter now, and the cutoffs were renamed
+	remove("4.3.0", "rc_smoothing_type", "RC smoothing is always a filter"),
+	remove("4.3.0", "rc_smoothing_input_type", "the filter type is fixed"),
+	remove("4.3.0", "rc_smoothing_derivative_type", "the filter type is fixed"),
+	rename("4.3.0", "rc_smoothing_input_hz", "rc_smoothing_setpoint_cutoff"),
+	rename("4.3.0", "rc_smoothing_derivative_hz", "rc_smoothing_feedforward_cutoff"),
+	rename("4.3.0", "rc_smoothing_auto_smoothness", "rc_smoothing_auto_factor"),
+
+	// TPA moved from the rate profiles to the PID profiles
+	{Version: v("4.3.0"), Setting: "tpa_rate", Action: MoveToProfile},
+	{Version: v("4.3.0"), Setting: "tpa_breakpoint", Action: MoveToProfile},
+
+	// Simplified tuning arrived, on by default, and recalculates the PID
+	// gains from its sliders. Turn it off where the gains were tuned by hand.
+	{Version: v("4.3.0"), Setting: "simplified_pids_mode", Func: keepManualPIDs},
+
+	rename("4.4.0", "rc_smoothing_auto_factor", "rc_smoothing_auto_factor_setpoint"),
+	remove("4.4.0", "anti_gravity_mode", "anti-gravity always works smoothly"),
+	warn("4.4.0", "anti_gravity_gain", "the range changed, check the value against the new default"),
+
+	// Idle isn't only for DShot, and any of the gyros can be enabled
+	rename("4.5.0", "dshot_idle_value", "motor_idle"),
+	{Version: v("4.5.0"), Setting: "gyro_to_use", Action: Rename, To: "gyro_enable_bitmask", Values: map[string]string{
+		"FIRST":  "1",
+		"SECOND": "2",
+		"BOTH":   "3",
+	}},
+}
+
+var pidGains = []string{"p_roll", "i_roll", "d_roll", "p_pitch", "i_pitch", "d_pitch", "p_yaw", "i_yaw", "d_yaw"}
+
+func keepManualPIDs(c *config.Config, section config.Section) string {
+	if section.Kind != config.Profile {
+		return ""
+	}
+	if _, ok := c.Get(section, "simplified_pids_mode"); ok {
+		return ""
+	}
+	var set []string
+	for _, name := range pidGains {
+		if _, ok := c.Get(section, name); ok {
+			set = append(set, name)
+		}
+	}
+	if len(set) == 0 {
+		return ""
+	}
+	c.Set(section, "simplified_pids_mode", "OFF")
+	return "turned off so the PID gains set by hand (" + strings.Join(set, ", ") + ") are kept"
+}
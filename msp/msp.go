@@ -26,11 +26,13 @@ package msp
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"reflect"
-
-	"go.bug.st/serial"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -52,8 +54,23 @@ const (
 
 	MspReboot = 68
 
+	MspStatus   = 101
+	MspRawIMU   = 102
+	MspMotor    = 104
+	MspRC       = 105
+	MspAttitude = 108
+	MspAnalog   = 110
+
 	MspPID = 112
 
+	MspBoxNames = 116
+
+	MspBatteryState = 130
+
+	MspStatusEx = 150
+
+	MspUID = 160
+
 	MspSetRawRC = 200
 
 	MspSetPID = 202
@@ -94,23 +111,15 @@ func mspV1Encode(cmd byte, data []byte) []byte {
 	return buf.Bytes()
 }
 
-func mspV2Encode(cmd byte, totalLength int) []byte {
-	var payloadLength byte
-	if totalLength > 6 {
-		payloadLength = byte(totalLength) - 9
-	}
+func mspV2Encode(cmd uint16, data []byte) []byte {
 	var buf bytes.Buffer
 	buf.WriteByte('$')
 	buf.WriteByte('X')
 	buf.WriteByte('<')
-	buf.WriteByte(0)
-	buf.WriteByte(cmd)
-	buf.WriteByte(0)
-	buf.WriteByte(byte(payloadLength))
-	buf.WriteByte(0)
-	for ii := byte(0); ii < payloadLength; ii++ {
-		buf.WriteByte(0)
-	}
+	buf.WriteByte(0) // flags
+	binary.Write(&buf, binary.LittleEndian, cmd)
+	binary.Write(&buf, binary.LittleEndian, uint16(len(data)))
+	buf.Write(data)
 	crc := byte(0)
 	for _, v := range buf.Bytes()[3:] {
 		crc = crc8DvbS2(crc, v)
@@ -119,6 +128,29 @@ func mspV2Encode(cmd byte, totalLength int) []byte {
 	return buf.Bytes()
 }
 
+// EncodeReply returns payload framed as the FC's reply to cmd, using MSPv2
+// if v2 is true. It is meant for emulating a flight controller.
+func EncodeReply(cmd uint16, payload []byte, v2 bool) []byte {
+	return encodeWithDirection('>', cmd, payload, v2)
+}
+
+// EncodeErrorReply returns the frame a FC sends when it can't handle cmd.
+func EncodeErrorReply(cmd uint16, v2 bool) []byte {
+	return encodeWithDirection('!', cmd, nil, v2)
+}
+
+func encodeWithDirection(dir byte, cmd uint16, payload []byte, v2 bool) []byte {
+	var frame []byte
+	if v2 || cmd > 0xff || len(payload) > 0xfe {
+		frame = mspV2Encode(cmd, payload)
+	} else {
+		frame = mspV1Encode(byte(cmd), payload)
+	}
+	// The direction isn't covered by the checksum
+	frame[2] = dir
+	return frame
+}
+
 func crc8DvbS2(crc, a byte) byte {
 	crc ^= a
 	for ii := 0; ii < 8; ii++ {
@@ -134,12 +166,21 @@ func crc8DvbS2(crc, a byte) byte {
 type MSP struct {
 	portName string
 	baudRate int
-	Port     serial.Port
+	writeMu  sync.Mutex
+	reader   reader
+	Port     Transport
+
+	// v2 is read by every writer, so it can be switched at any time
+	v2             atomic.Bool
+	checksumErrors atomic.Uint64
+	oobBytes       atomic.Uint64
 }
 
 type MSPFrame struct {
-	Code       uint16
-	Payload    []byte
+	Code    uint16
+	Payload []byte
+	// V2 is true if the frame was received as MSPv2
+	V2         bool
 	payloadPos int
 }
 
@@ -219,20 +260,54 @@ func (e *mspChecksumErr) Error() string {
 		e.checksum, e.expectedChecksum, e.code, e.payload)
 }
 
-type mspOOBErr struct {
-	b byte
+// ErrTimeout is returned when no data arrives from the port within the
+// read timeout set with MSP.SetReadTimeout.
+var ErrTimeout = errors.New("timed out reading from MSP port")
+
+type mspReplyErr struct {
+	code uint16
 }
 
-func (e *mspOOBErr) IsMSPError() bool { return true }
-func (e *mspOOBErr) Error() string {
-	return fmt.Sprintf("out of band MSP byte 0x%02x", e.b)
+func (e *mspReplyErr) IsMSPError() bool { return true }
+func (e *mspReplyErr) Error() string {
+	return fmt.Sprintf("flight controller returned an error for MSP cmd %d", e.code)
 }
 
-func New(portName string, baudRate int) (*MSP, error) {
-	mode := &serial.Mode{
-		BaudRate: baudRate,
+// IsReplyError returns true if err is an error reply from the FC, which is
+// usually sent for commands the firmware doesn't support.
+func IsReplyError(err error) bool {
+	var e *mspReplyErr
+	return errors.As(err, &e)
+}
+
+// IsFrameError returns true if err is a frame that arrived corrupted, after
+// which the connection can carry on.
+func IsFrameError(err error) bool {
+	var checksumErr *mspChecksumErr
+	return errors.As(err, &checksumErr)
+}
+
+// FrameErrors counts what was received from the FC that couldn't be read as
+// a frame.
+type FrameErrors struct {
+	// Checksum is the number of frames with a bad checksum
+	Checksum uint64
+	// OutOfBand is the number of bytes received outside of a frame
+	OutOfBand uint64
+}
+
+// FrameErrors returns the errors seen by ReadFrame on this connection.
+func (m *MSP) FrameErrors() FrameErrors {
+	return FrameErrors{
+		Checksum:  m.checksumErrors.Load(),
+		OutOfBand: m.oobBytes.Load(),
 	}
-	port, err := serial.Open(portName, mode)
+}
+
+// New opens the port named by portName, which can be a serial device or a
+// URL understood by Open, and returns an MSP connection over it.
+func New(portName string, baudRate int) (*MSP, error) {
+	port, err := Open(portName, baudRate)
 	if err != nil {
 		return nil, err
 	}
@@ -243,6 +318,44 @@ func New(portName string, baudRate int) (*MSP, error) {
 	}, nil
 }
 
+// NewWithTransport returns an MSP connection over an already open
+// transport, such as one end of an in-memory pipe. name is only used to
+// identify the connection.
+func NewWithTransport(name string, rwc io.ReadWriteCloser) *MSP {
+	return &MSP{
+		portName: name,
+		Port:     NewTransport(rwc),
+	}
+}
+
+// PortName returns the name of the port this MSP was opened on.
+func (m *MSP) PortName() string {
+	return m.portName
+}
+
+// SetReadTimeout sets the timeout for reads from the port. Once set, reading
+// a frame returns ErrTimeout instead of blocking forever if the other end
+// stops talking.
+func (m *MSP) SetReadTimeout(t time.Duration) error {
+	return m.Port.SetReadTimeout(t)
+}
+
+// read fills buf from the port, translating the empty reads returned by a
+// timed out serial port into ErrTimeout.
+func (m *MSP) read(buf []byte) error {
+	for n := 0; n < len(buf); {
+		nn, err := m.Port.Read(buf[n:])
+		if err != nil {
+			return err
+		}
+		if nn == 0 {
+			return ErrTimeout
+		}
+		n += nn
+	}
+	return nil
+}
+
 func (m *MSP) encodeArgs(w *bytes.Buffer, args ...interface{}) error {
 	for _, arg := range args {
 		switch x := arg.(type) {
@@ -276,33 +389,43 @@ func (m *MSP) encodeArgs(w *bytes.Buffer, args ...interface{}) error {
 	return nil
 }
 
+// SetV2 selects whether commands are sent as MSPv2 frames. Commands that
+// don't fit in an MSPv1 frame are always sent as MSPv2.
+func (m *MSP) SetV2(enabled bool) {
+	m.v2.Store(enabled)
+}
+
+// V2 returns true if commands are sent as MSPv2 frames.
+func (m *MSP) V2() bool {
+	return m.v2.Load()
+}
+
 func (m *MSP) WriteCmd(cmd uint16, args ...interface{}) (int, error) {
 	var buf bytes.Buffer
 	if err := m.encodeArgs(&buf, args...); err != nil {
 		return -1, err
 	}
-	data := buf.Bytes()
-	frame := mspV1Encode(byte(cmd), data)
+	frame := encodeWithDirection('<', cmd, buf.Bytes(), m.v2.Load())
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
 	return m.Port.Write(frame)
 }
 
-func (m *MSP) readMSPV1Frame() (*MSPFrame, error) {
-	buf := make([]byte, 3)
-	if _, err := m.Port.Read(buf); err != nil {
+func readMSPV1Frame(read func([]byte) error, dir byte) (*MSPFrame, error) {
+	// payload length and cmd
+	buf := make([]byte, 2)
+	if err := read(buf); err != nil {
 		return nil, err
 	}
-	if buf[0] != '<' && buf[0] != '>' {
-		return nil, fmt.Errorf("invalid MSP direction char 0x%02x", buf[0])
-	}
 	ccrc := byte(0)
+	ccrc ^= buf[0]
 	ccrc ^= buf[1]
-	ccrc ^= buf[2]
 	var payload []byte
-	payloadLength := int(buf[1])
-	cmd := buf[2]
+	payloadLength := int(buf[0])
+	cmd := buf[1]
 	if payloadLength > 0 {
 		payload = make([]byte, payloadLength)
-		if _, err := io.ReadFull(m.Port, payload); err != nil {
+		if err := read(payload); err != nil {
 			return nil, err
 		}
 		for _, b := range payload {
@@ -310,7 +433,7 @@ func (m *MSP) readMSPV1Frame() (*MSPFrame, error) {
 		}
 	}
 	buf = buf[:1]
-	if _, err := m.Port.Read(buf); err != nil {
+	if err := read(buf); err != nil {
 		return nil, err
 	}
 	crc := buf[0]
@@ -322,6 +445,9 @@ func (m *MSP) readMSPV1Frame() (*MSPFrame, error) {
 			expectedChecksum: ccrc,
 		}
 	}
+	if dir == '!' {
+		return nil, &mspReplyErr{code: uint16(cmd)}
+	}
 	return &MSPFrame{
 		Code:       uint16(cmd),
 		Payload:    payload,
@@ -329,66 +455,119 @@ func (m *MSP) readMSPV1Frame() (*MSPFrame, error) {
 	}, nil
 }
 
-func (m *MSP) readMSPV2Frame() (*MSPFrame, error) {
-	buf := make([]byte, 6)
-	if _, err := m.Port.Read(buf); err != nil {
+func readMSPV2Frame(read func([]byte) error, dir byte) (*MSPFrame, error) {
+	// flags, cmd (u16) and payload length (u16)
+	buf := make([]byte, 5)
+	if err := read(buf); err != nil {
 		return nil, err
 	}
-	if buf[0] != '<' && buf[0] != '>' {
-		return nil, fmt.Errorf("invalid MSP direction char 0x%02x", buf[0])
+	ccrc := byte(0)
+	for _, b := range buf {
+		ccrc = crc8DvbS2(ccrc, b)
 	}
-	// flags := buf[1]
-	code := uint16(buf[2]) | uint16(buf[3])<<8
-	payloadLength := int(uint16(buf[4]) | uint16(buf[5])<<8)
+	code := binary.LittleEndian.Uint16(buf[1:])
+	payloadLength := int(binary.LittleEndian.Uint16(buf[3:]))
 	var payload []byte
 	if payloadLength > 0 {
 		payload = make([]byte, payloadLength)
-		if _, err := io.ReadFull(m.Port, payload); err != nil {
+		if err := read(payload); err != nil {
 			return nil, err
 		}
+		for _, b := range payload {
+			ccrc = crc8DvbS2(ccrc, b)
+		}
 	}
 
-	buf = make([]byte, 1)
-	if _, err := m.Port.Read(buf); err != nil {
+	buf = buf[:1]
+	if err := read(buf); err != nil {
 		return nil, err
 	}
-	// crc := buf[0]
+	crc := buf[0]
+	if crc != ccrc {
+		return nil, &mspChecksumErr{
+			code:             code,
+			payload:          payload,
+			checksum:         crc,
+			expectedChecksum: ccrc,
+		}
+	}
+	if dir == '!' {
+		return nil, &mspReplyErr{code: code}
+	}
 	return &MSPFrame{
 		Code:       code,
 		Payload:    payload,
+		V2:         true,
 		payloadPos: 0,
 	}, nil
 }
 
+// ReadFrame reads the next MSP frame from the port. Anything that isn't
+// part of a frame, such as CLI output or line noise, is skipped until the
+// stream is back in sync, and counted by FrameErrors. A frame with a bad
+// checksum is counted too, and returned as an error for which IsFrameError
+// returns true. An error reply from the FC is returned as an error for
+// which IsReplyError returns true.
+//
+// ReadFrame must not be used while the background reader started by
+// Request is running.
 func (m *MSP) ReadFrame() (*MSPFrame, error) {
 	port := m.Port
 	if port == nil {
 		return nil, io.EOF
 	}
-	buf := make([]byte, 1)
-	for {
-		_, err := port.Read(buf)
-		if err != nil {
-			return nil, err
+	fr, skipped, err := readFrame(m.read)
+	m.oobBytes.Add(uint64(skipped))
+	if IsFrameError(err) {
+		m.checksumErrors.Add(1)
+	}
+	return fr, err
+}
+
+// DecodeFrame reads the next MSP frame from any reader, skipping anything
+// before it. Like ReadFrame it accepts requests as well as replies, so it
+// can be used to emulate the FC side of a connection.
+func DecodeFrame(r io.Reader) (*MSPFrame, error) {
+	fr, _, err := readFrame(func(buf []byte) error {
+		_, err := io.ReadFull(r, buf)
+		return err
+	})
+	return fr, err
+}
+
+// readFrame reads the next frame, returning the number of bytes skipped
+// before it which weren't part of a frame.
+func readFrame(read func([]byte) error) (*MSPFrame, int, error) {
+	// Frames start with $, then M or X for the version, then the direction
+	hdr := make([]byte, 3)
+	n, skipped := 0, 0
+	for n < len(hdr) {
+		if err := read(hdr[n : n+1]); err != nil {
+			return nil, skipped, err
 		}
-		if buf[0] == '$' {
-			// Frame start
-			break
+		b := hdr[n]
+		switch {
+		case b == '$':
+			skipped += n
+			hdr[0] = b
+			n = 1
+		case n == 1 && (b == 'M' || b == 'X'):
+			n = 2
+		case n == 2 && (b == '<' || b == '>' || b == '!'):
+			n = 3
+		default:
+			skipped += n + 1
+			n = 0
 		}
-		return nil, &mspOOBErr{b: buf[0]}
 	}
-	_, err := port.Read(buf)
-	if err != nil {
-		return nil, err
-	}
-	switch buf[0] {
-	case 'M':
-		return m.readMSPV1Frame()
-	case 'X':
-		return m.readMSPV2Frame()
-	default:
-		return nil, fmt.Errorf("unknown MSP char %c", buf[0])
+	var fr *MSPFrame
+	var err error
+	if hdr[1] == 'M' {
+		fr, err = readMSPV1Frame(read, hdr[2])
+	} else {
+		fr, err = readMSPV2Frame(read, hdr[2])
 	}
+	return fr, skipped, err
 }
 
 // RebootIntoBootloader reboots the board into bootloader mode
@@ -398,9 +577,10 @@ func (m *MSP) RebootIntoBootloader() (int, error) {
 	return m.Port.Write([]byte{'R'})
 }
 
-// Close closes the underlying serial port. Note that reading from or
-// writing to a closed MSP will cause a panic.
+// Close stops the background reader and closes the underlying port. Note
+// that reading from or writing to a closed MSP will cause a panic.
 func (m *MSP) Close() error {
+	m.StopReader()
 	var err error
 	if m.Port != nil {
 		if err = m.Port.Close(); err == nil {
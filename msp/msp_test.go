@@ -0,0 +1,168 @@
+package msp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func decode(frame []byte) (*MSPFrame, error) {
+	return DecodeFrame(bytes.NewReader(frame))
+}
+
+func TestCRC8DvbS2(t *testing.T) {
+	// The check value of CRC-8/DVB-S2
+	crc := byte(0)
+	for _, b := range []byte("123456789") {
+		crc = crc8DvbS2(crc, b)
+	}
+	if crc != 0xbc {
+		t.Errorf("got CRC 0x%02x, want 0xbc", crc)
+	}
+}
+
+func TestMSPV2Encode(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    uint16
+		payload []byte
+		want    []byte
+	}{
+		{
+			name: "no payload",
+			code: 100,
+			want: []byte{'$', 'X', '<', 0, 0x64, 0x00, 0x00, 0x00, 0x8f},
+		},
+		{
+			name:    "16-bit code with payload",
+			code:    0x1001,
+			payload: []byte{1, 2, 3},
+			want:    []byte{'$', 'X', '<', 0, 0x01, 0x10, 0x03, 0x00, 1, 2, 3, 0xd8},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mspV2Encode(tt.code, tt.payload)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMSPV2RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		code uint16
+		size int
+	}{
+		{"empty", MspAPIVersion, 0},
+		{"one byte", MspStatusEx, 1},
+		{"largest v1 payload", MspName, 0xff},
+		{"16-bit size", MspBoxNames, 0x1234},
+		{"16-bit code", 0x3003, 16},
+		{"largest code", 0xffff, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := make([]byte, tt.size)
+			for ii := range payload {
+				payload[ii] = byte(ii * 7)
+			}
+			frame := EncodeReply(tt.code, payload, true)
+			if got := binary.LittleEndian.Uint16(frame[4:]); got != tt.code {
+				t.Errorf("code field is %d, want %d", got, tt.code)
+			}
+			if got := binary.LittleEndian.Uint16(frame[6:]); int(got) != tt.size {
+				t.Errorf("size field is %d, want %d", got, tt.size)
+			}
+			if len(frame) != 9+tt.size {
+				t.Errorf("frame is %d bytes, want %d", len(frame), 9+tt.size)
+			}
+
+			fr, err := decode(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fr.Code != tt.code {
+				t.Errorf("decoded code %d, want %d", fr.Code, tt.code)
+			}
+			if !fr.V2 {
+				t.Error("decoded frame isn't marked as v2")
+			}
+			if !bytes.Equal(fr.Payload, payload) {
+				t.Errorf("decoded payload % x, want % x", fr.Payload, payload)
+			}
+		})
+	}
+}
+
+func TestEncodeFallsBackToV2(t *testing.T) {
+	tests := []struct {
+		name string
+		code uint16
+		size int
+		v2   bool
+	}{
+		{"v1", MspStatus, 10, false},
+		{"16-bit code", 0x1001, 0, true},
+		{"payload too long for v1", MspStatus, 0xff, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := encodeWithDirection('<', tt.code, make([]byte, tt.size), false)
+			if got := frame[1] == 'X'; got != tt.v2 {
+				t.Errorf("got v2 %v, want %v", got, tt.v2)
+			}
+			fr, err := decode(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fr.Code != tt.code || len(fr.Payload) != tt.size {
+				t.Errorf("decoded code %d with %d bytes, want %d with %d", fr.Code, len(fr.Payload), tt.code, tt.size)
+			}
+		})
+	}
+}
+
+func TestMSPV2ChecksumError(t *testing.T) {
+	good := mspV2Encode(0x1001, []byte{1, 2, 3})
+	tests := []struct {
+		name    string
+		corrupt func(frame []byte)
+	}{
+		{"checksum", func(frame []byte) { frame[len(frame)-1] ^= 0xff }},
+		{"payload", func(frame []byte) { frame[9] ^= 0x01 }},
+		{"code", func(frame []byte) { frame[5] ^= 0x20 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := append([]byte(nil), good...)
+			tt.corrupt(frame)
+			_, err := decode(frame)
+			var checksumErr *mspChecksumErr
+			if !errors.As(err, &checksumErr) {
+				t.Fatalf("got error %v, want a checksum error", err)
+			}
+			if checksumErr.Checksum() == checksumErr.ExpectedChecksum() {
+				t.Errorf("checksum 0x%02x matches the expected one", checksumErr.Checksum())
+			}
+			if !IsFrameError(err) {
+				t.Error("IsFrameError is false")
+			}
+		})
+	}
+}
+
+func TestErrorReply(t *testing.T) {
+	for _, v2 := range []bool{false, true} {
+		_, err := decode(EncodeErrorReply(MspBoxNames, v2))
+		if !IsReplyError(err) {
+			t.Errorf("v2 %v: got error %v, want an error reply", v2, err)
+		}
+		if IsFrameError(err) {
+			t.Errorf("v2 %v: an error reply is a frame error", v2)
+		}
+	}
+}
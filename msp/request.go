@@ -0,0 +1,226 @@
+package msp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// readerPollInterval is how often the background reader checks whether it
+// has been asked to stop while the port is idle.
+const readerPollInterval = 100 * time.Millisecond
+
+// lateReplyWindow is how long after a request gives up its reply is still
+// expected, and dropped if it arrives.
+const lateReplyWindow = time.Second
+
+// ErrReaderStopped is returned to requests that were still waiting for a
+// reply when the background reader was stopped.
+var ErrReaderStopped = errors.New("MSP reader stopped")
+
+type result struct {
+	frame *MSPFrame
+	err   error
+}
+
+// reader holds the state of the goroutine that reads frames from the port
+// and hands them to the requests waiting for them.
+type reader struct {
+	// runMu serialises starting and stopping the goroutine
+	runMu   sync.Mutex
+	mu      sync.Mutex
+	running bool
+	stop    chan struct{}
+	stopped chan struct{}
+	waiters map[uint16][]*waiter
+}
+
+// waiter is a request waiting for its reply. A request that gives up stays
+// queued, abandoned, so that its reply is dropped if it turns up late rather
+// than handed to the next request for the same code.
+type waiter struct {
+	ch chan result
+	// abandoned is when the request gave up, zero while it is waiting
+	abandoned time.Time
+	// shadowed is set when an abandoned request queued ahead of this one
+	// took a reply. If this request then gives up too, the reply was
+	// probably its own and the one before it was lost, so it isn't left
+	// waiting for another.
+	shadowed bool
+}
+
+// Request sends code with the given arguments and waits for the FC's reply
+// to it. Replies are read by a background goroutine and matched to requests
+// by command code, so Request can be called from several goroutines at once.
+// If the FC answers with an error reply, the returned error satisfies
+// IsReplyError. Request gives up when ctx is done, and if the reply arrives
+// afterwards it is dropped.
+func (m *MSP) Request(ctx context.Context, code uint16, args ...interface{}) (*MSPFrame, error) {
+	w := &waiter{ch: make(chan result, 1)}
+	r := &m.reader
+	r.mu.Lock()
+	if r.waiters == nil {
+		r.waiters = make(map[uint16][]*waiter)
+	}
+	r.waiters[code] = append(r.waiters[code], w)
+	r.mu.Unlock()
+
+	m.startReader()
+	if _, err := m.WriteCmd(code, args...); err != nil {
+		r.mu.Lock()
+		r.removeWaiter(code, w)
+		r.mu.Unlock()
+		return nil, err
+	}
+	select {
+	case res := <-w.ch:
+		return res.frame, res.err
+	case <-ctx.Done():
+		return r.abandon(code, w, ctx.Err())
+	}
+}
+
+// StopReader stops the background reader started by Request, leaving the
+// port free for other traffic such as the CLI. Requests still waiting for a
+// reply fail with ErrReaderStopped. The reader starts again on the next
+// call to Request.
+func (m *MSP) StopReader() {
+	r := &m.reader
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+	r.mu.Lock()
+	if !r.running {
+		r.mu.Unlock()
+		return
+	}
+	r.running = false
+	close(r.stop)
+	stopped := r.stopped
+	r.failAll(ErrReaderStopped)
+	r.mu.Unlock()
+
+	<-stopped
+	if m.Port != nil {
+		m.Port.SetReadTimeout(NoTimeout)
+	}
+}
+
+func (m *MSP) startReader() {
+	r := &m.reader
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		return
+	}
+	r.running = true
+	r.stop = make(chan struct{})
+	r.stopped = make(chan struct{})
+	m.Port.SetReadTimeout(readerPollInterval)
+	go m.readLoop(r.stop, r.stopped)
+}
+
+func (m *MSP) readLoop(stop, stopped chan struct{}) {
+	defer close(stopped)
+	r := &m.reader
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		fr, err := m.ReadFrame()
+		if err == nil {
+			r.deliver(fr.Code, result{frame: fr})
+			continue
+		}
+		if errors.Is(err, ErrTimeout) {
+			continue
+		}
+		var replyErr *mspReplyErr
+		var checksumErr *mspChecksumErr
+		switch {
+		case errors.As(err, &replyErr):
+			r.deliver(replyErr.code, result{err: err})
+		case errors.As(err, &checksumErr):
+			r.deliver(checksumErr.code, result{err: err})
+		default:
+			// The port is gone, fail everyone still waiting
+			r.mu.Lock()
+			if r.stop == stop {
+				r.running = false
+			}
+			r.failAll(err)
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+// deliver hands res to the oldest request waiting for code. Replies nobody
+// is waiting for, including late replies to requests that gave up, are
+// dropped.
+func (r *reader) deliver(code uint16, res result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	waiters := r.waiters[code]
+	for len(waiters) > 0 {
+		w := waiters[0]
+		waiters = waiters[1:]
+		if w.abandoned.IsZero() {
+			w.ch <- res
+			break
+		}
+		if time.Since(w.abandoned) < lateReplyWindow {
+			for _, next := range waiters {
+				next.shadowed = true
+			}
+			break
+		}
+		// Too late to be this reply, so it was lost
+	}
+	r.waiters[code] = waiters
+}
+
+// abandon is called when w gives up waiting with err. It returns the reply
+// instead if that arrived in the meantime.
+func (r *reader) abandon(code uint16, w *waiter, err error) (*MSPFrame, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case res := <-w.ch:
+		return res.frame, res.err
+	default:
+	}
+	if w.shadowed {
+		r.removeWaiter(code, w)
+	} else {
+		w.abandoned = time.Now()
+	}
+	return nil, err
+}
+
+// removeWaiter must be called with r.mu held.
+func (r *reader) removeWaiter(code uint16, w *waiter) {
+	waiters := r.waiters[code]
+	for ii, ww := range waiters {
+		if ww == w {
+			r.waiters[code] = append(waiters[:ii:ii], waiters[ii+1:]...)
+			return
+		}
+	}
+}
+
+// failAll must be called with r.mu held.
+func (r *reader) failAll(err error) {
+	for code, waiters := range r.waiters {
+		for _, w := range waiters {
+			if w.abandoned.IsZero() {
+				w.ch <- result{err: err}
+			}
+		}
+		delete(r.waiters, code)
+	}
+}
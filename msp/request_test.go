@@ -0,0 +1,272 @@
+package msp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeFC is the flight controller end of a pipe to an MSP connection.
+type fakeFC struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func newPipe(t *testing.T) (*MSP, *fakeFC) {
+	a, b := net.Pipe()
+	m := NewWithTransport("pipe", a)
+	t.Cleanup(func() {
+		b.Close()
+		m.Close()
+	})
+	return m, &fakeFC{t: t, conn: b}
+}
+
+// expect reads the next request and checks it is for code.
+func (f *fakeFC) expect(code uint16) {
+	f.t.Helper()
+	fr, err := DecodeFrame(f.conn)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if fr.Code != code {
+		f.t.Fatalf("got request for %d, want %d", fr.Code, code)
+	}
+}
+
+func (f *fakeFC) send(b []byte) {
+	f.t.Helper()
+	if _, err := f.conn.Write(b); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// request runs Request in the background, returning a channel for its
+// result.
+func request(ctx context.Context, m *MSP, code uint16) <-chan result {
+	ch := make(chan result, 1)
+	go func() {
+		fr, err := m.Request(ctx, code)
+		ch <- result{frame: fr, err: err}
+	}()
+	return ch
+}
+
+func waitResult(t *testing.T, ch <-chan result) result {
+	t.Helper()
+	select {
+	case res := <-ch:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the request")
+		return result{}
+	}
+}
+
+func TestRequestRoutesRepliesByCode(t *testing.T) {
+	m, fc := newPipe(t)
+	ctx := context.Background()
+	status := request(ctx, m, MspStatus)
+	fc.expect(MspStatus)
+	attitude := request(ctx, m, MspAttitude)
+	fc.expect(MspAttitude)
+
+	// Answer out of order
+	fc.send(EncodeReply(MspAttitude, []byte{1, 2}, false))
+	fc.send(EncodeReply(MspStatus, []byte{3}, false))
+
+	for _, tt := range []struct {
+		ch      <-chan result
+		code    uint16
+		payload []byte
+	}{
+		{status, MspStatus, []byte{3}},
+		{attitude, MspAttitude, []byte{1, 2}},
+	} {
+		res := waitResult(t, tt.ch)
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		if res.frame.Code != tt.code || string(res.frame.Payload) != string(tt.payload) {
+			t.Errorf("request for %d got code %d with payload % x", tt.code, res.frame.Code, res.frame.Payload)
+		}
+	}
+}
+
+func TestRequestReplyError(t *testing.T) {
+	m, fc := newPipe(t)
+	ch := request(context.Background(), m, MspBoxNames)
+	fc.expect(MspBoxNames)
+	fc.send(EncodeErrorReply(MspBoxNames, false))
+	if res := waitResult(t, ch); !IsReplyError(res.err) {
+		t.Errorf("got error %v, want an error reply", res.err)
+	}
+}
+
+func TestRequestResyncs(t *testing.T) {
+	m, fc := newPipe(t)
+	ch := request(context.Background(), m, MspStatus)
+	fc.expect(MspStatus)
+	// A false start counts the bytes up to and including the one which
+	// breaks the header
+	fc.send([]byte("hello $M?"))
+	fc.send(EncodeReply(MspStatus, []byte{7}, false))
+	res := waitResult(t, ch)
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.frame.Payload[0] != 7 {
+		t.Errorf("got payload % x", res.frame.Payload)
+	}
+	if errs := m.FrameErrors(); errs.OutOfBand != 9 || errs.Checksum != 0 {
+		t.Errorf("got frame errors %+v, want 9 out of band bytes", errs)
+	}
+}
+
+func TestRequestChecksumError(t *testing.T) {
+	m, fc := newPipe(t)
+	ch := request(context.Background(), m, MspStatus)
+	fc.expect(MspStatus)
+	frame := EncodeReply(MspStatus, []byte{7}, true)
+	frame[len(frame)-1] ^= 0xff
+	fc.send(frame)
+	if res := waitResult(t, ch); !IsFrameError(res.err) {
+		t.Errorf("got error %v, want a frame error", res.err)
+	}
+	if errs := m.FrameErrors(); errs.Checksum != 1 {
+		t.Errorf("got frame errors %+v, want 1 checksum error", errs)
+	}
+}
+
+func TestRequestTimeoutAbandonsWaiter(t *testing.T) {
+	m, fc := newPipe(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ch := request(ctx, m, MspStatus)
+	fc.expect(MspStatus)
+	if res := waitResult(t, ch); !errors.Is(res.err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the deadline", res.err)
+	}
+	m.reader.mu.Lock()
+	waiters := m.reader.waiters[MspStatus]
+	m.reader.mu.Unlock()
+	if len(waiters) != 1 || waiters[0].abandoned.IsZero() {
+		t.Errorf("got waiters %+v, want one abandoned", waiters)
+	}
+
+	// The late reply is dropped rather than given to the next request
+	fc.send(EncodeReply(MspStatus, []byte{1}, false))
+	ch = request(context.Background(), m, MspAttitude)
+	fc.expect(MspAttitude)
+	fc.send(EncodeReply(MspAttitude, nil, false))
+	if res := waitResult(t, ch); res.err != nil {
+		t.Fatal(res.err)
+	}
+	ch = request(context.Background(), m, MspStatus)
+	fc.expect(MspStatus)
+	fc.send(EncodeReply(MspStatus, []byte{2}, false))
+	res := waitResult(t, ch)
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.frame.Payload[0] != 2 {
+		t.Errorf("got the late reply % x", res.frame.Payload)
+	}
+}
+
+// timeOut makes a request for code which gives up before the FC answers.
+func timeOut(t *testing.T, m *MSP, fc *fakeFC, code uint16) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ch := request(ctx, m, code)
+	fc.expect(code)
+	if res := waitResult(t, ch); !errors.Is(res.err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the deadline", res.err)
+	}
+}
+
+func TestRequestDropsLateReply(t *testing.T) {
+	m, fc := newPipe(t)
+	timeOut(t, m, fc, MspStatus)
+
+	// The late reply arrives after the next request for the same code
+	ch := request(context.Background(), m, MspStatus)
+	fc.expect(MspStatus)
+	fc.send(EncodeReply(MspStatus, []byte{1}, false))
+	fc.send(EncodeReply(MspStatus, []byte{2}, false))
+	res := waitResult(t, ch)
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.frame.Payload[0] != 2 {
+		t.Errorf("got the late reply % x", res.frame.Payload)
+	}
+}
+
+func TestRequestAfterLostReply(t *testing.T) {
+	m, fc := newPipe(t)
+	// The reply to the first request never arrives, so the second one's
+	// reply is taken for it and the second request gives up too
+	timeOut(t, m, fc, MspStatus)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	ch := request(ctx, m, MspStatus)
+	fc.expect(MspStatus)
+	fc.send(EncodeReply(MspStatus, []byte{2}, false))
+	if res := waitResult(t, ch); !errors.Is(res.err, context.DeadlineExceeded) {
+		t.Fatalf("got %+v, want the deadline", res)
+	}
+
+	// Which leaves the replies back in step for the third
+	ch = request(context.Background(), m, MspStatus)
+	fc.expect(MspStatus)
+	fc.send(EncodeReply(MspStatus, []byte{3}, false))
+	res := waitResult(t, ch)
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.frame.Payload[0] != 3 {
+		t.Errorf("got payload % x", res.frame.Payload)
+	}
+}
+
+func TestRequestLateReplyWindow(t *testing.T) {
+	m, fc := newPipe(t)
+	timeOut(t, m, fc, MspStatus)
+	m.reader.mu.Lock()
+	m.reader.waiters[MspStatus][0].abandoned = time.Now().Add(-lateReplyWindow)
+	m.reader.mu.Unlock()
+
+	// A reply this late is taken to be the next request's
+	ch := request(context.Background(), m, MspStatus)
+	fc.expect(MspStatus)
+	fc.send(EncodeReply(MspStatus, []byte{2}, false))
+	res := waitResult(t, ch)
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.frame.Payload[0] != 2 {
+		t.Errorf("got payload % x", res.frame.Payload)
+	}
+}
+
+func TestStopReaderFailsPending(t *testing.T) {
+	m, fc := newPipe(t)
+	ch := request(context.Background(), m, MspStatus)
+	fc.expect(MspStatus)
+	m.StopReader()
+	if res := waitResult(t, ch); !errors.Is(res.err, ErrReaderStopped) {
+		t.Errorf("got error %v, want ErrReaderStopped", res.err)
+	}
+
+	// The reader starts again for the next request
+	ch = request(context.Background(), m, MspStatus)
+	fc.expect(MspStatus)
+	fc.send(EncodeReply(MspStatus, nil, false))
+	if res := waitResult(t, ch); res.err != nil {
+		t.Error(res.err)
+	}
+}
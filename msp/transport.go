@@ -0,0 +1,238 @@
+package msp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// NoTimeout can be passed to SetReadTimeout to make reads block until data
+// arrives.
+const NoTimeout time.Duration = -1
+
+// Transport is a connection to a flight controller that MSP frames and CLI
+// text are exchanged over. Read follows the semantics of a serial port: once
+// a read timeout is set, a Read that times out returns 0 bytes and no error.
+type Transport interface {
+	io.ReadWriteCloser
+	SetReadTimeout(t time.Duration) error
+}
+
+// Dialer opens a Transport for a port URL.
+type Dialer func(u *url.URL, baudRate int) (Transport, error)
+
+var (
+	dialersMu sync.Mutex
+	dialers   = map[string]Dialer{
+		"serial": dialSerial,
+		"tcp":    dialNet,
+		"udp":    dialNet,
+	}
+)
+
+// RegisterScheme makes ports named scheme://... open through dial.
+func RegisterScheme(scheme string, dial Dialer) {
+	dialersMu.Lock()
+	defer dialersMu.Unlock()
+	dialers[scheme] = dial
+}
+
+// Open opens the transport named by port. Plain device names such as
+// /dev/ttyACM0 or COM3 open a serial port, as does serial:///dev/ttyACM0.
+// tcp://host:port and udp://host:port connect over the network, for example
+// to the MSP port of a Betaflight SITL build.
+func Open(port string, baudRate int) (Transport, error) {
+	scheme, _, found := strings.Cut(port, "://")
+	if !found {
+		return openSerial(port, baudRate)
+	}
+	u, err := url.Parse(port)
+	if err != nil {
+		return nil, err
+	}
+	dialersMu.Lock()
+	dial, ok := dialers[scheme]
+	dialersMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported port type %q", scheme)
+	}
+	return dial(u, baudRate)
+}
+
+func openSerial(name string, baudRate int) (Transport, error) {
+	mode := &serial.Mode{
+		BaudRate: baudRate,
+	}
+	return serial.Open(name, mode)
+}
+
+func dialSerial(u *url.URL, baudRate int) (Transport, error) {
+	return openSerial(serialDevice(u), baudRate)
+}
+
+// serialDevice returns the device named by a serial:// URL.
+func serialDevice(u *url.URL) string {
+	if u.Host != "" {
+		// serial://COM3
+		return u.Host + u.Path
+	}
+	return u.Path
+}
+
+func dialNet(u *url.URL, baudRate int) (Transport, error) {
+	conn, err := net.Dial(u.Scheme, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	t := &netTransport{conn: conn}
+	if u.Scheme == "udp" {
+		// A datagram is discarded if it doesn't fit in the read buffer, so
+		// read whole datagrams and hand them out piecemeal.
+		t.r = bufio.NewReaderSize(conn, 65536)
+	} else {
+		t.r = conn
+	}
+	return t, nil
+}
+
+type netTransport struct {
+	conn net.Conn
+	r    io.Reader
+
+	mu      sync.Mutex
+	timeout time.Duration
+}
+
+func (t *netTransport) Read(p []byte) (int, error) {
+	t.mu.Lock()
+	timeout := t.timeout
+	t.mu.Unlock()
+
+	if timeout > 0 {
+		t.conn.SetReadDeadline(time.Now().Add(timeout))
+	} else {
+		t.conn.SetReadDeadline(time.Time{})
+	}
+	n, err := t.r.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, nil
+	}
+	return n, err
+}
+
+func (t *netTransport) Write(p []byte) (int, error) {
+	return t.conn.Write(p)
+}
+
+func (t *netTransport) Close() error {
+	return t.conn.Close()
+}
+
+func (t *netTransport) SetReadTimeout(d time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timeout = d
+	return nil
+}
+
+// NewTransport wraps any io.ReadWriteCloser, such as one end of a pipe, so
+// it can be used as a Transport. Reads happen on a background goroutine so
+// that they can time out.
+func NewTransport(rwc io.ReadWriteCloser) Transport {
+	if t, ok := rwc.(Transport); ok {
+		return t
+	}
+	t := &streamTransport{
+		rwc:     rwc,
+		timeout: NoTimeout,
+		chunks:  make(chan []byte),
+		done:    make(chan struct{}),
+	}
+	go t.pump()
+	return t
+}
+
+type streamTransport struct {
+	rwc     io.ReadWriteCloser
+	chunks  chan []byte
+	pending []byte
+	err     error
+	done    chan struct{}
+	once    sync.Once
+
+	mu      sync.Mutex
+	timeout time.Duration
+}
+
+func (t *streamTransport) pump() {
+	for {
+		buf := make([]byte, 1024)
+		n, err := t.rwc.Read(buf)
+		if n > 0 {
+			select {
+			case t.chunks <- buf[:n]:
+			case <-t.done:
+				return
+			}
+		}
+		if err != nil {
+			t.err = err
+			close(t.chunks)
+			return
+		}
+	}
+}
+
+func (t *streamTransport) Read(p []byte) (int, error) {
+	if len(t.pending) == 0 {
+		t.mu.Lock()
+		timeout := t.timeout
+		t.mu.Unlock()
+
+		var timer <-chan time.Time
+		if timeout > 0 {
+			tm := time.NewTimer(timeout)
+			defer tm.Stop()
+			timer = tm.C
+		}
+		select {
+		case chunk, ok := <-t.chunks:
+			if !ok {
+				return 0, t.err
+			}
+			t.pending = chunk
+		case <-timer:
+			return 0, nil
+		case <-t.done:
+			return 0, io.ErrClosedPipe
+		}
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+func (t *streamTransport) Write(p []byte) (int, error) {
+	return t.rwc.Write(p)
+}
+
+func (t *streamTransport) Close() error {
+	t.once.Do(func() { close(t.done) })
+	return t.rwc.Close()
+}
+
+func (t *streamTransport) SetReadTimeout(d time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timeout = d
+	return nil
+}
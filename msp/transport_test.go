@@ -0,0 +1,202 @@
+package msp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSerialDevice(t *testing.T) {
+	tests := []struct {
+		port, device string
+	}{
+		{"serial:///dev/ttyACM0", "/dev/ttyACM0"},
+		{"serial://COM3", "COM3"},
+		{"serial:///dev/serial/by-id/usb-Betaflight", "/dev/serial/by-id/usb-Betaflight"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := serialDevice(u); got != tt.device {
+			t.Errorf("%s: got %q, want %q", tt.port, got, tt.device)
+		}
+	}
+}
+
+func TestOpenFails(t *testing.T) {
+	tests := []struct {
+		port, err string
+	}{
+		{"/dev/no-such-port", ""},
+		{"serial:///dev/no-such-port", ""},
+		{"bluetooth://00:11:22:33:44:55", `unsupported port type "bluetooth"`},
+		{"tcp://%zz", "invalid URL escape"},
+	}
+	for _, tt := range tests {
+		port, err := Open(tt.port, 115200)
+		if err == nil {
+			port.Close()
+			t.Errorf("%s: opened", tt.port)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.port, err, tt.err)
+		}
+	}
+}
+
+// acceptTCP returns the address of a loopback listener and a channel that
+// receives the first connection made to it.
+func acceptTCP(t *testing.T) (string, <-chan net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	conns := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			close(conns)
+			return
+		}
+		t.Cleanup(func() { c.Close() })
+		conns <- c
+	}()
+	return l.Addr().String(), conns
+}
+
+func TestOpenTCP(t *testing.T) {
+	addr, conns := acceptTCP(t)
+	port, err := Open("tcp://"+addr, 115200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+	server := <-conns
+
+	if _, err := port.Write([]byte("$M<")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "$M<" {
+		t.Fatalf("server got %q, %v", buf, err)
+	}
+	server.Write([]byte("$M>"))
+	if _, err := io.ReadFull(port, buf); err != nil || string(buf) != "$M>" {
+		t.Fatalf("port got %q, %v", buf, err)
+	}
+}
+
+func TestOpenUDP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	port, err := Open("udp://"+server.LocalAddr().String(), 115200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+	port.SetReadTimeout(time.Second)
+
+	// The server only learns the client's address from its first datagram
+	port.Write([]byte("hello"))
+	buf := make([]byte, 64)
+	n, client, err := server.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("server got %q, %v", buf[:n], err)
+	}
+
+	// A datagram read in pieces is neither truncated nor merged with the
+	// next one
+	frame := EncodeReply(MspAPIVersion, []byte{0, 1, 46}, false)
+	server.WriteTo(frame, client)
+	server.WriteTo([]byte("next"), client)
+	var got []byte
+	for len(got) < len(frame)+4 {
+		n, err := port.Read(buf[:2])
+		if err != nil || n == 0 {
+			t.Fatalf("read %d, %v after %q", n, err, got)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if want := append(frame, "next"...); !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNetReadTimeout(t *testing.T) {
+	addr, conns := acceptTCP(t)
+	port, err := Open("tcp://"+addr, 115200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+	server := <-conns
+
+	// A timed out read returns nothing, like a serial port
+	port.SetReadTimeout(50 * time.Millisecond)
+	buf := make([]byte, 8)
+	start := time.Now()
+	n, err := port.Read(buf)
+	if n != 0 || err != nil {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("timed out after %v", elapsed)
+	}
+
+	// Without a timeout a read waits for data
+	port.SetReadTimeout(NoTimeout)
+	time.AfterFunc(200*time.Millisecond, func() { server.Write([]byte("late")) })
+	n, err = port.Read(buf)
+	if err != nil || string(buf[:n]) != "late" {
+		t.Fatalf("got %q, %v", buf[:n], err)
+	}
+
+	// The timeout can be changed while another goroutine is reading
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ii := 0; ii < 5; ii++ {
+			port.Read(buf)
+		}
+	}()
+	for ii := 0; ii < 5; ii++ {
+		port.SetReadTimeout(time.Duration(ii+1) * time.Millisecond)
+	}
+	wg.Wait()
+}
+
+func TestSetV2(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	m := NewWithTransport("pipe", client)
+	defer m.Close()
+	go io.Copy(io.Discard, server)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ii := 0; ii < 10; ii++ {
+			m.WriteCmd(MspAPIVersion)
+		}
+	}()
+	m.SetV2(true)
+	wg.Wait()
+	if !m.V2() {
+		t.Error("MSPv2 is off")
+	}
+}
@@ -0,0 +1,68 @@
+package record
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type csvWriter struct {
+	buf *bufio.Writer
+	w   *csv.Writer
+}
+
+// NewCSVWriter writes a recording as CSV. The header is written as comment
+// lines starting with #, followed by a line giving the unit of each column
+// and the line of column names. Missing values are left empty.
+func NewCSVWriter(w io.Writer, h *Header) (Writer, error) {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, "# btfl recording\n")
+	fmt.Fprintf(buf, "# firmware: %s %s", h.Variant, h.Version)
+	if h.GitRevision != "" {
+		fmt.Fprintf(buf, " (%s) %s", h.GitRevision, h.BuildDate)
+	}
+	fmt.Fprintf(buf, "\n# craft: %s\n", h.CraftName)
+	fmt.Fprintf(buf, "# board: %s, target %s, UID %s\n", h.BoardName, h.Target, h.MCUID)
+	fmt.Fprintf(buf, "# started: %s\n", h.Started.Format(time.RFC3339Nano))
+	fmt.Fprintf(buf, "# rate: %g Hz\n", h.Rate)
+	units := make([]string, len(h.Columns))
+	names := make([]string, len(h.Columns))
+	for ii, c := range h.Columns {
+		units[ii] = c.Unit
+		names[ii] = c.Name
+	}
+	fmt.Fprintf(buf, "# units: %s\n", strings.Join(units, ","))
+
+	cw := &csvWriter{buf: buf, w: csv.NewWriter(buf)}
+	if err := cw.w.Write(names); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) Write(row []float64) error {
+	record := make([]string, len(row))
+	for ii, v := range row {
+		switch {
+		case math.IsNaN(v):
+		case ii == 0:
+			record[ii] = strconv.FormatFloat(v, 'f', 4, 64)
+		default:
+			record[ii] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	if err := cw.w.Error(); err != nil {
+		return err
+	}
+	return cw.buf.Flush()
+}
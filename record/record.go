@@ -0,0 +1,174 @@
+// Package record writes telemetry polled from a flight controller to files,
+// one row per sample, as CSV or in a compact columnar binary format.
+package record
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/robhaswell/btflcli/msp"
+)
+
+// BitmaskUnit is the unit of columns of flags.
+const BitmaskUnit = "bitmask"
+
+// Column is a column of a recording.
+type Column struct {
+	Name string `json:"name"`
+	Unit string `json:"unit,omitempty"`
+}
+
+// Header describes a recording: the board it was taken from and its
+// columns, the first of which is the time of each sample.
+type Header struct {
+	Variant     string    `json:"variant"`
+	Version     string    `json:"version"`
+	CraftName   string    `json:"craft_name,omitempty"`
+	BoardName   string    `json:"board_name,omitempty"`
+	Target      string    `json:"target,omitempty"`
+	MCUID       string    `json:"mcu_id,omitempty"`
+	GitRevision string    `json:"git_revision,omitempty"`
+	BuildDate   string    `json:"build_date,omitempty"`
+	Started     time.Time `json:"started"`
+	// Rate is the number of samples a second asked for
+	Rate    float64  `json:"rate"`
+	Columns []Column `json:"columns"`
+
+	// codes are the messages recorded and widths the number of columns
+	// each takes
+	codes  []uint16
+	widths []int
+}
+
+// Writer writes samples to a recording.
+type Writer interface {
+	// Write adds a row, with a value for each column. Values missing from
+	// the sample are NaN.
+	Write(row []float64) error
+	// Close writes anything buffered. It doesn't close the underlying
+	// writer.
+	Close() error
+}
+
+// NewHeader returns the header for a recording of the given messages from
+// a board. The number of RC channels and motors are taken from a sample.
+func NewHeader(board *fc.FC, id *fc.Identity, rate float64, codes []uint16, sample *fc.Telemetry) *Header {
+	h := &Header{
+		Variant:   board.Variant,
+		Version:   fmt.Sprintf("%d.%d.%d", board.VersionMajor, board.VersionMinor, board.VersionPatch),
+		CraftName: board.Name,
+		Started:   sample.Time,
+		Rate:      rate,
+		Columns:   []Column{{"time", "s"}},
+	}
+	if id != nil {
+		h.BoardName = id.BoardName
+		h.Target = id.TargetName
+		h.MCUID = id.MCUID
+		h.GitRevision = id.GitRevision
+		h.BuildDate = id.BuildDate
+	}
+	for _, code := range codes {
+		cols := columns(code, sample)
+		h.Columns = append(h.Columns, cols...)
+		h.codes = append(h.codes, code)
+		h.widths = append(h.widths, len(cols))
+	}
+	return h
+}
+
+// columns returns the columns holding a message.
+func columns(code uint16, sample *fc.Telemetry) []Column {
+	switch code {
+	case msp.MspStatusEx:
+		return []Column{
+			{"cycle_time", "us"},
+			{"cpu_load", "%"},
+			{"profile", ""},
+			{"rate_profile", ""},
+			{"arming_disable_flags", BitmaskUnit},
+		}
+	case msp.MspAttitude:
+		return []Column{{"roll", "deg"}, {"pitch", "deg"}, {"heading", "deg"}}
+	case msp.MspRawIMU:
+		return []Column{
+			{"acc_x", "g"}, {"acc_y", "g"}, {"acc_z", "g"},
+			{"gyro_x", "deg/s"}, {"gyro_y", "deg/s"}, {"gyro_z", "deg/s"},
+			{"mag_x", ""}, {"mag_y", ""}, {"mag_z", ""},
+		}
+	case msp.MspRC:
+		return numbered("rc", len(sample.RC), "us")
+	case msp.MspMotor:
+		return numbered("motor", len(sample.Motors), "us")
+	case msp.MspAnalog:
+		return []Column{{"voltage", "V"}, {"current", "A"}, {"drawn", "mAh"}, {"rssi", "/1023"}}
+	case msp.MspBatteryState:
+		return []Column{{"battery_voltage", "V"}, {"battery_current", "A"}, {"battery_drawn", "mAh"}, {"battery_cells", ""}}
+	}
+	return nil
+}
+
+func numbered(name string, n int, unit string) []Column {
+	cols := make([]Column, n)
+	for ii := range cols {
+		cols[ii] = Column{fmt.Sprintf("%s_%d", name, ii+1), unit}
+	}
+	return cols
+}
+
+// Row returns the values of a sample for the columns of h.
+func (h *Header) Row(t *fc.Telemetry) []float64 {
+	row := []float64{t.Time.Sub(h.Started).Seconds()}
+	for ii, code := range h.codes {
+		row = append(row, values(code, t, h.widths[ii])...)
+	}
+	return row
+}
+
+// values returns n values of a message in a sample, padded with NaNs if
+// it's missing.
+func values(code uint16, t *fc.Telemetry, n int) []float64 {
+	var v []float64
+	switch code {
+	case msp.MspStatusEx:
+		if s := t.Status; s != nil {
+			v = []float64{float64(s.CycleTime), float64(s.CPULoad), float64(s.Profile), float64(s.RateProfile), float64(s.ArmingDisableFlags)}
+		}
+	case msp.MspAttitude:
+		if a := t.Attitude; a != nil {
+			v = []float64{a.Roll, a.Pitch, a.Heading}
+		}
+	case msp.MspRawIMU:
+		if imu := t.RawIMU; imu != nil {
+			v = append(v, imu.Acc[:]...)
+			v = append(v, imu.Gyro[:]...)
+			v = append(v, imu.Mag[:]...)
+		}
+	case msp.MspRC:
+		v = floats(t.RC)
+	case msp.MspMotor:
+		v = floats(t.Motors)
+	case msp.MspAnalog:
+		if a := t.Analog; a != nil {
+			v = []float64{a.Voltage, a.Current, float64(a.Drawn), float64(a.RSSI)}
+		}
+	case msp.MspBatteryState:
+		if b := t.Battery; b != nil {
+			v = []float64{b.Voltage, b.Current, float64(b.Drawn), float64(b.Cells)}
+		}
+	}
+	for len(v) < n {
+		v = append(v, math.NaN())
+	}
+	return v[:n]
+}
+
+func floats(v []uint16) []float64 {
+	f := make([]float64, len(v))
+	for ii, x := range v {
+		f[ii] = float64(x)
+	}
+	return f
+}
@@ -0,0 +1,299 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robhaswell/btflcli/fc"
+	"github.com/robhaswell/btflcli/msp"
+)
+
+var started = time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+
+// testCodes are the messages recorded by the tests.
+var testCodes = []uint16{msp.MspStatusEx, msp.MspAttitude, msp.MspRC, msp.MspMotor, msp.MspAnalog}
+
+// armingFlags uses the highest bits Betaflight sets, which a float32 can't
+// hold alongside the lowest.
+const armingFlags = 1<<25 | 1<<24 | 1
+
+// samples are a full sample and one where replies were dropped.
+func samples() []*fc.Telemetry {
+	return []*fc.Telemetry{
+		{
+			Time:     started,
+			Status:   &fc.Status{CycleTime: 125, CPULoad: 12, Profile: 1, RateProfile: 2, ArmingDisableFlags: armingFlags},
+			Attitude: &fc.Attitude{Roll: -12.3, Pitch: 4.5, Heading: 270},
+			RC:       []uint16{1500, 1500, 1000, 1500, 1000, 1000, 2000, 1000},
+			Motors:   []uint16{1000, 1000, 1000, 1000},
+			Analog:   &fc.Analog{Voltage: 16.8, Current: 0.25, Drawn: 12, RSSI: 1023},
+		},
+		{
+			Time:     started.Add(20 * time.Millisecond),
+			Attitude: &fc.Attitude{Roll: -12.4, Pitch: 4.5, Heading: 271},
+			Motors:   []uint16{1100, 1101, 1102, 1103},
+			Analog:   &fc.Analog{Voltage: 16.7, Current: 10.5, Drawn: 13, RSSI: 1000},
+			Dropped:  2,
+		},
+	}
+}
+
+func testHeader(t *testing.T) *Header {
+	t.Helper()
+	board := &fc.FC{Variant: "BTFL", VersionMajor: 4, VersionMinor: 5, Name: "Bench Quad"}
+	id := &fc.Identity{BoardName: "SPEEDYBEEF7V3", TargetName: "STM32F7X2", MCUID: "3b0026003133510735363636", GitRevision: "c155f58", BuildDate: "Apr  1 2024"}
+	return NewHeader(board, id, 50, testCodes, samples()[0])
+}
+
+func TestNewHeader(t *testing.T) {
+	h := testHeader(t)
+	if h.Variant != "BTFL" || h.Version != "4.5.0" || h.CraftName != "Bench Quad" || h.BoardName != "SPEEDYBEEF7V3" ||
+		h.Target != "STM32F7X2" || !h.Started.Equal(started) || h.Rate != 50 {
+		t.Errorf("got header %+v", h)
+	}
+	// The first sample sets the number of RC channels and motors
+	var names []string
+	for _, c := range h.Columns {
+		names = append(names, c.Name)
+	}
+	want := []string{
+		"time",
+		"cycle_time", "cpu_load", "profile", "rate_profile", "arming_disable_flags",
+		"roll", "pitch", "heading",
+		"rc_1", "rc_2", "rc_3", "rc_4", "rc_5", "rc_6", "rc_7", "rc_8",
+		"motor_1", "motor_2", "motor_3", "motor_4",
+		"voltage", "current", "drawn", "rssi",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got columns %q, want %q", names, want)
+	}
+	if c := h.Columns[5]; c.Unit != BitmaskUnit {
+		t.Errorf("%s has unit %q", c.Name, c.Unit)
+	}
+}
+
+func TestRow(t *testing.T) {
+	h := testHeader(t)
+	s := samples()
+	// Samples with more channels than the first are cut to fit
+	s[1].RC = []uint16{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	full, dropped := h.Row(s[0]), h.Row(s[1])
+	for _, row := range [][]float64{full, dropped} {
+		if len(row) != len(h.Columns) {
+			t.Fatalf("row has %d values for %d columns", len(row), len(h.Columns))
+		}
+	}
+	if full[0] != 0 || dropped[0] != 0.02 {
+		t.Errorf("got times %v and %v", full[0], dropped[0])
+	}
+	if full[5] != armingFlags {
+		t.Errorf("got arming flags %v", full[5])
+	}
+	for ii := 1; ii <= 5; ii++ {
+		if !math.IsNaN(dropped[ii]) {
+			t.Errorf("dropped %s is %v, want NaN", h.Columns[ii].Name, dropped[ii])
+		}
+	}
+	if dropped[9] != 1 || dropped[16] != 8 || dropped[17] != 1100 {
+		t.Errorf("got row %v", dropped)
+	}
+}
+
+// same reports whether two values are the same, or both missing.
+func same(a, b float64) bool {
+	return a == b || math.IsNaN(a) && math.IsNaN(b)
+}
+
+// readCSV reads the header comments, units, names and rows of a CSV
+// recording.
+func readCSV(t *testing.T, r io.Reader) (comments, units, names []string, rows [][]float64) {
+	t.Helper()
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil || b[0] != '#' {
+			break
+		}
+		line, _ := br.ReadString('\n')
+		comments = append(comments, strings.TrimSuffix(line, "\n"))
+	}
+	if n := len(comments); n > 0 {
+		units = strings.Split(strings.TrimPrefix(comments[n-1], "# units: "), ",")
+	}
+	records, err := csv.NewReader(br).ReadAll()
+	if err != nil || len(records) == 0 {
+		t.Fatalf("got %q, %v", records, err)
+	}
+	names = records[0]
+	for _, rec := range records[1:] {
+		row := make([]float64, len(rec))
+		for ii, s := range rec {
+			row[ii] = math.NaN()
+			if s != "" {
+				if row[ii], err = strconv.ParseFloat(s, 64); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+	return comments, units, names, rows
+}
+
+func TestCSV(t *testing.T) {
+	h := testHeader(t)
+	var buf bytes.Buffer
+	w, err := NewCSVWriter(&buf, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want [][]float64
+	for _, s := range samples() {
+		row := h.Row(s)
+		want = append(want, row)
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	comments, units, names, rows := readCSV(t, &buf)
+	wantComments := []string{
+		"# btfl recording",
+		"# firmware: BTFL 4.5.0 (c155f58) Apr  1 2024",
+		"# craft: Bench Quad",
+		"# board: SPEEDYBEEF7V3, target STM32F7X2, UID 3b0026003133510735363636",
+		"# started: 2024-04-01T10:00:00Z",
+		"# rate: 50 Hz",
+	}
+	if !reflect.DeepEqual(comments[:len(comments)-1], wantComments) {
+		t.Errorf("got comments %q, want %q", comments, wantComments)
+	}
+	if len(units) != len(h.Columns) || len(names) != len(h.Columns) {
+		t.Fatalf("got units %q and names %q for %d columns", units, names, len(h.Columns))
+	}
+	for ii, c := range h.Columns {
+		if units[ii] != c.Unit || names[ii] != c.Name {
+			t.Errorf("column %d is %s in %s, want %s in %s", ii, names[ii], units[ii], c.Name, c.Unit)
+		}
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for ii := range rows {
+		for jj := range rows[ii] {
+			if !same(rows[ii][jj], want[ii][jj]) {
+				t.Errorf("row %d %s is %v, want %v", ii, names[jj], rows[ii][jj], want[ii][jj])
+			}
+		}
+	}
+}
+
+// readColumnar reads the header and rows of a columnar recording.
+func readColumnar(t *testing.T, r io.Reader) (*Header, [][]float64) {
+	t.Helper()
+	le := binary.LittleEndian
+	magic := make([]byte, len(ColumnarMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != ColumnarMagic {
+		t.Fatalf("got magic %q, %v", magic, err)
+	}
+	var n uint32
+	if err := binary.Read(r, le, &n); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		t.Fatal(err)
+	}
+	var h Header
+	if err := json.Unmarshal(data, &h); err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]float64
+	for {
+		if err := binary.Read(r, le, &n); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		block := make([][]float64, n)
+		for ii := range block {
+			block[ii] = make([]float64, len(h.Columns))
+		}
+		for jj, c := range h.Columns {
+			for ii := range block {
+				if jj == 0 || c.Unit == BitmaskUnit {
+					if err := binary.Read(r, le, &block[ii][jj]); err != nil {
+						t.Fatal(err)
+					}
+				} else {
+					var v float32
+					if err := binary.Read(r, le, &v); err != nil {
+						t.Fatal(err)
+					}
+					block[ii][jj] = float64(v)
+				}
+			}
+		}
+		rows = append(rows, block...)
+	}
+	return &h, rows
+}
+
+func TestColumnar(t *testing.T) {
+	h := testHeader(t)
+	var buf bytes.Buffer
+	w, err := NewColumnarWriter(&buf, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enough rows for a full block and part of another
+	var want [][]float64
+	for ii := 0; ii < blockRows+10; ii++ {
+		s := samples()[ii%2]
+		s.Time = started.Add(time.Duration(ii) * 20 * time.Millisecond)
+		row := h.Row(s)
+		want = append(want, row)
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, rows := readColumnar(t, &buf)
+	if !reflect.DeepEqual(got.Columns, h.Columns) || got.CraftName != h.CraftName || !got.Started.Equal(h.Started) {
+		t.Errorf("got header %+v, want %+v", got, h)
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for ii := range rows {
+		for jj, c := range h.Columns {
+			v := want[ii][jj]
+			// Only the time and bitmasks keep every bit
+			if jj != 0 && c.Unit != BitmaskUnit {
+				v = float64(float32(v))
+			}
+			if !same(rows[ii][jj], v) {
+				t.Fatalf("row %d %s is %v, want %v", ii, c.Name, rows[ii][jj], v)
+			}
+		}
+	}
+	if flags := rows[0][5]; flags != armingFlags {
+		t.Errorf("got arming flags %#x, want %#x", uint32(flags), armingFlags)
+	}
+}
@@ -0,0 +1,160 @@
+package rx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// JoystickFormat is the layout of the events read from a Linux input
+// device.
+type JoystickFormat int
+
+const (
+	// JSFormat is the joystick API of /dev/input/js*
+	JSFormat JoystickFormat = iota
+	// EvdevFormat is the event interface of /dev/input/event*, as laid out
+	// on this machine
+	EvdevFormat
+	// Evdev64Format and Evdev32Format are the event interface as laid out
+	// on 64-bit and 32-bit machines, whose struct timeval fields are the
+	// size of a long, for replaying recordings made on another machine
+	Evdev64Format
+	Evdev32Format
+)
+
+const (
+	jsEventSize   = 8
+	jsEventButton = 0x01
+	jsEventAxis   = 0x02
+	jsEventInit   = 0x80
+	evdevKey      = 0x01
+	evdevAbs      = 0x03
+)
+
+// IsEvdev returns true for the formats of the event interface.
+func (f JoystickFormat) IsEvdev() bool {
+	return f == EvdevFormat || f == Evdev64Format || f == Evdev32Format
+}
+
+// longSize returns the size of the fields of the struct timeval of an evdev
+// event.
+func (f JoystickFormat) longSize() int {
+	switch f {
+	case Evdev64Format:
+		return 8
+	case Evdev32Format:
+		return 4
+	}
+	// A Go int is the size of a C long on Linux
+	return strconv.IntSize / 8
+}
+
+// JoystickEvent is the movement of an axis or the press or release of a
+// button.
+type JoystickEvent struct {
+	// Time is the time of the event as given by the kernel
+	Time time.Duration
+	Axis bool
+	// Number is the axis or button number for JSFormat, or the ABS_* or
+	// BTN_* code for EvdevFormat
+	Number int
+	// Value is the position of an axis, or 1 for a pressed button and 0
+	// for a released one
+	Value int32
+}
+
+// JoystickReader reads the events of a joystick.
+type JoystickReader struct {
+	r      io.Reader
+	format JoystickFormat
+	// Realtime delays each event until its time has come, relative to the
+	// first one, to replay recorded events at the speed they happened
+	Realtime bool
+	start    time.Time
+	first    time.Duration
+}
+
+// NewJoystickReader returns a reader of events in the given format, from a
+// device or a recording of one, e.g. made with cat /dev/input/js0.
+func NewJoystickReader(r io.Reader, format JoystickFormat) *JoystickReader {
+	return &JoystickReader{r: r, format: format}
+}
+
+// Next returns the next axis or button event, skipping other events. It
+// returns io.EOF at the end of a recording.
+func (j *JoystickReader) Next() (JoystickEvent, error) {
+	for {
+		ev, ok, err := j.read()
+		if err != nil {
+			return ev, err
+		}
+		if !ok {
+			continue
+		}
+		if j.Realtime {
+			if j.start.IsZero() {
+				j.start, j.first = time.Now(), ev.Time
+			}
+			time.Sleep(time.Until(j.start.Add(ev.Time - j.first)))
+		}
+		return ev, nil
+	}
+}
+
+func (j *JoystickReader) read() (JoystickEvent, bool, error) {
+	le := binary.LittleEndian
+	var ev JoystickEvent
+	switch j.format {
+	case JSFormat:
+		// u32 time in ms, s16 value, u8 type, u8 number
+		var b [jsEventSize]byte
+		if _, err := io.ReadFull(j.r, b[:]); err != nil {
+			return ev, false, err
+		}
+		ev.Time = time.Duration(le.Uint32(b[0:])) * time.Millisecond
+		ev.Value = int32(int16(le.Uint16(b[4:])))
+		ev.Number = int(b[7])
+		// The initial state of each control is marked as such
+		switch b[6] &^ jsEventInit {
+		case jsEventAxis:
+			ev.Axis = true
+		case jsEventButton:
+		default:
+			return ev, false, nil
+		}
+	case EvdevFormat, Evdev64Format, Evdev32Format:
+		// struct timeval of two longs, u16 type, u16 code, s32 value
+		long := j.format.longSize()
+		b := make([]byte, 2*long+8)
+		if _, err := io.ReadFull(j.r, b); err != nil {
+			return ev, false, err
+		}
+		word := func(b []byte) time.Duration {
+			if long == 4 {
+				return time.Duration(le.Uint32(b))
+			}
+			return time.Duration(le.Uint64(b))
+		}
+		ev.Time = word(b[0:])*time.Second + word(b[long:])*time.Microsecond
+		b = b[2*long:]
+		ev.Number = int(le.Uint16(b[2:]))
+		ev.Value = int32(le.Uint32(b[4:]))
+		switch le.Uint16(b[0:]) {
+		case evdevAbs:
+			ev.Axis = true
+		case evdevKey:
+			// Auto-repeat isn't a press
+			if ev.Value > 1 {
+				return ev, false, nil
+			}
+		default:
+			return ev, false, nil
+		}
+	default:
+		return ev, false, fmt.Errorf("unknown joystick format %d", j.format)
+	}
+	return ev, true, nil
+}
@@ -0,0 +1,196 @@
+package rx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultAxisMin and defaultAxisMax are the range of the axes of the
+	// joystick API
+	defaultAxisMin = -32767
+	defaultAxisMax = 32767
+)
+
+// Mapping assigns the axes and buttons of a joystick to RC channels. It is
+// read from YAML such as:
+//
+//	roll:     {axis: 3, expo: 0.3, deadband: 0.05}
+//	pitch:    {axis: 4, expo: 0.3, deadband: 0.05, invert: true}
+//	yaw:      {axis: 0, expo: 0.2, deadband: 0.05}
+//	throttle: {axis: 1, invert: true}
+//	aux:
+//	  1: {button: 0, toggle: true}
+//	  2: {axis: 5}
+type Mapping struct {
+	Roll     *Input `yaml:"roll"`
+	Pitch    *Input `yaml:"pitch"`
+	Yaw      *Input `yaml:"yaw"`
+	Throttle *Input `yaml:"throttle"`
+	// AUX are the AUX channels by number, from 1
+	AUX map[int]*Input `yaml:"aux"`
+}
+
+// Input is the axis or button driving a channel.
+type Input struct {
+	Axis   *int `yaml:"axis"`
+	Button *int `yaml:"button"`
+	// Min and Max are the range of the axis, which defaults to that of the
+	// joystick API. evdev devices report their own, so their axes must give
+	// them.
+	Min *int32 `yaml:"min"`
+	Max *int32 `yaml:"max"`
+	// Invert reverses the direction of the axis
+	Invert bool `yaml:"invert"`
+	// Deadband is the fraction of each half of the axis around the centre
+	// which is treated as centred
+	Deadband float64 `yaml:"deadband"`
+	// Expo, from 0 to 1, softens the response around the centre
+	Expo float64 `yaml:"expo"`
+	// Toggle makes each press of the button switch the channel between low
+	// and high, rather than it being high while the button is held
+	Toggle bool `yaml:"toggle"`
+}
+
+// ReadMapping reads and checks a mapping for a joystick read in format.
+func ReadMapping(r io.Reader, format JoystickFormat) (*Mapping, error) {
+	m := &Mapping{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(m); err != nil {
+		return nil, err
+	}
+	if len(m.channels()) == 0 {
+		return nil, errors.New("the mapping has no channels")
+	}
+	for aux := range m.AUX {
+		if aux < 1 || aux > len((*RxSticks)(nil).Channels) {
+			return nil, fmt.Errorf("there is no AUX channel %d", aux)
+		}
+	}
+	for ch, in := range m.channels() {
+		if err := in.check(format); err != nil {
+			return nil, fmt.Errorf("%s: %w", channelName(ch), err)
+		}
+	}
+	return m, nil
+}
+
+// channels returns the inputs by RC channel, numbered as by
+// RxSticks.SetChannel.
+func (m *Mapping) channels() map[int]*Input {
+	channels := make(map[int]*Input)
+	for ii, in := range []*Input{m.Roll, m.Pitch, m.Yaw, m.Throttle} {
+		if in != nil {
+			channels[ii+1] = in
+		}
+	}
+	for aux, in := range m.AUX {
+		channels[aux+4] = in
+	}
+	return channels
+}
+
+// AUXCount returns the highest AUX channel the mapping drives.
+func (m *Mapping) AUXCount() int {
+	n := 0
+	for aux := range m.AUX {
+		n = max(n, aux)
+	}
+	return n
+}
+
+func channelName(ch int) string {
+	if ch <= 4 {
+		return []string{"roll", "pitch", "yaw", "throttle"}[ch-1]
+	}
+	return fmt.Sprintf("aux %d", ch-4)
+}
+
+func (in *Input) check(format JoystickFormat) error {
+	switch {
+	case in == nil:
+		return errors.New("no axis or button")
+	case (in.Axis == nil) == (in.Button == nil):
+		return errors.New("give either an axis or a button")
+	case in.Button != nil && (in.Invert || in.Deadband != 0 || in.Expo != 0 || in.Min != nil || in.Max != nil):
+		return errors.New("invert, deadband, expo, min and max only apply to axes")
+	case in.Axis != nil && in.Toggle:
+		return errors.New("toggle only applies to buttons")
+	case in.Deadband < 0 || in.Deadband >= 1:
+		return fmt.Errorf("deadband %g is not from 0 to less than 1", in.Deadband)
+	case in.Expo < 0 || in.Expo > 1:
+		return fmt.Errorf("expo %g is not from 0 to 1", in.Expo)
+	case in.Axis != nil && format.IsEvdev() && (in.Min == nil || in.Max == nil):
+		return errors.New("event device axes need their min and max, as shown by evtest")
+	}
+	if lo, hi := in.axisRange(); lo >= hi {
+		return fmt.Errorf("min %d is not less than max %d", lo, hi)
+	}
+	return nil
+}
+
+func (in *Input) axisRange() (int32, int32) {
+	lo, hi := int32(defaultAxisMin), int32(defaultAxisMax)
+	if in.Min != nil {
+		lo = *in.Min
+	}
+	if in.Max != nil {
+		hi = *in.Max
+	}
+	return lo, hi
+}
+
+// Position returns the position of a channel from -1 to 1 for a reading of
+// the axis, after inversion, deadband and expo.
+func (in *Input) Position(value int32) float64 {
+	lo, hi := in.axisRange()
+	mid := (float64(lo) + float64(hi)) / 2
+	v := (float64(value) - mid) / (float64(hi) - mid)
+	v = math.Max(-1, math.Min(1, v))
+	if in.Invert {
+		v = -v
+	}
+	if a := math.Abs(v); a < in.Deadband {
+		v = 0
+	} else {
+		v = math.Copysign((a-in.Deadband)/(1-in.Deadband), v)
+	}
+	return (1-in.Expo)*v + in.Expo*v*v*v
+}
+
+// Apply sets the channels driven by the axis or button of ev.
+func (m *Mapping) Apply(sticks *RxSticks, ev JoystickEvent) {
+	for ch, in := range m.channels() {
+		switch {
+		case ev.Axis && in.Axis != nil && *in.Axis == ev.Number:
+			sticks.SetChannel(ch, uint16(math.Round(RxMid+(RxHigh-RxMid)*in.Position(ev.Value))))
+		case !ev.Axis && in.Button != nil && *in.Button == ev.Number:
+			switch {
+			case in.Toggle && ev.Value == 1:
+				sticks.ToggleChannel(ch)
+			case in.Toggle:
+			case ev.Value != 0:
+				sticks.SetChannel(ch, RxHigh)
+			default:
+				sticks.SetChannel(ch, RxLow)
+			}
+		}
+	}
+}
+
+// Drive applies the events of a joystick to sticks until reading fails,
+// returning io.EOF at the end of a recording.
+func (m *Mapping) Drive(j *JoystickReader, sticks *RxSticks) error {
+	for {
+		ev, err := j.Next()
+		if err != nil {
+			return err
+		}
+		m.Apply(sticks, ev)
+	}
+}
@@ -0,0 +1,258 @@
+package rx
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// jsMapping is for a gamepad read with the joystick API.
+const jsMapping = `
+roll:     {axis: 3, expo: 0.3, deadband: 0.05}
+pitch:    {axis: 4, expo: 0.3, deadband: 0.05, invert: true}
+yaw:      {axis: 0, expo: 0.2, deadband: 0.05}
+throttle: {axis: 1, invert: true}
+aux:
+  1: {button: 0, toggle: true}
+  2: {axis: 5}
+  3: {button: 1}
+`
+
+// evdevMapping is for the same gamepad read as an event device.
+const evdevMapping = `
+roll:     {axis: 3, min: -32768, max: 32767, expo: 0.3, deadband: 0.05}
+pitch:    {axis: 4, min: -32768, max: 32767, expo: 0.3, deadband: 0.05, invert: true}
+yaw:      {axis: 0, min: -32768, max: 32767, expo: 0.2, deadband: 0.05}
+throttle: {axis: 1, min: -32768, max: 32767}
+aux:
+  1: {button: 0x130, toggle: true}
+  2: {axis: 5, min: 0, max: 1023}
+  3: {button: 0x131}
+`
+
+func readMapping(t *testing.T, text string, format JoystickFormat) *Mapping {
+	t.Helper()
+	m, err := ReadMapping(strings.NewReader(text), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func readCapture(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// drive replays events through a mapping as fast as they can be read.
+func drive(t *testing.T, m *Mapping, events []byte, format JoystickFormat) *RxSticks {
+	t.Helper()
+	var sticks RxSticks
+	sticks.Reset()
+	j := NewJoystickReader(bytes.NewReader(events), format)
+	start := time.Now()
+	if err := m.Drive(j, &sticks); err != io.EOF {
+		t.Fatalf("got %v at the end of the recording, want io.EOF", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("replaying took %s", d)
+	}
+	return &sticks
+}
+
+type channels struct {
+	roll, pitch, yaw, throttle uint16
+	aux                        [3]uint16
+}
+
+func (c channels) check(t *testing.T, sticks *RxSticks) {
+	t.Helper()
+	got := channels{sticks.Roll, sticks.Pitch, sticks.Yaw, sticks.Throttle, [3]uint16{sticks.Channels[0], sticks.Channels[1], sticks.Channels[2]}}
+	if got != c {
+		t.Errorf("got channels %+v, want %+v", got, c)
+	}
+}
+
+func TestDrive(t *testing.T) {
+	// Half right roll with deadband and expo, pitch inside the deadband,
+	// full left yaw, full throttle, AUX 1 toggled on and off again, AUX 2
+	// centred and AUX 3 held
+	want := channels{roll: 1682, pitch: 1500, yaw: 1000, throttle: 2000, aux: [3]uint16{1000, 1500, 2000}}
+	tests := []struct {
+		capture string
+		mapping string
+		format  JoystickFormat
+	}{
+		{"moves.js", jsMapping, JSFormat},
+		{"moves.evdev64", evdevMapping, Evdev64Format},
+		{"moves.evdev32", evdevMapping, Evdev32Format},
+	}
+	for _, tt := range tests {
+		t.Run(tt.capture, func(t *testing.T) {
+			m := readMapping(t, tt.mapping, tt.format)
+			sticks := drive(t, m, readCapture(t, tt.capture), tt.format)
+			want.check(t, sticks)
+		})
+	}
+}
+
+func TestDriveInitialState(t *testing.T) {
+	// The joystick API starts with the state of every axis and button,
+	// marked with JS_EVENT_INIT, which sets the channels before anything
+	// moves
+	events := readCapture(t, "moves.js")[:10*jsEventSize]
+	m := readMapping(t, jsMapping, JSFormat)
+	var sticks RxSticks
+	sticks.Reset()
+	sticks.SetChannel(4, RxMid)
+	sticks.SetChannel(6, RxMid)
+	sticks.SetChannel(7, RxHigh)
+	if err := m.Drive(NewJoystickReader(bytes.NewReader(events), JSFormat), &sticks); err != io.EOF {
+		t.Fatal(err)
+	}
+	channels{roll: 1500, pitch: 1500, yaw: 1500, throttle: 1000, aux: [3]uint16{1000, 1000, 1000}}.check(t, &sticks)
+}
+
+func TestEvdevFormat(t *testing.T) {
+	// EvdevFormat reads the capture made on a machine like this one
+	native := "moves.evdev64"
+	if strconv.IntSize == 32 {
+		native = "moves.evdev32"
+	}
+	var events []JoystickEvent
+	for _, tt := range []struct {
+		capture string
+		format  JoystickFormat
+	}{
+		{native, EvdevFormat},
+		{"moves.evdev64", Evdev64Format},
+		{"moves.evdev32", Evdev32Format},
+	} {
+		j := NewJoystickReader(bytes.NewReader(readCapture(t, tt.capture)), tt.format)
+		var got []JoystickEvent
+		for {
+			ev, err := j.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, ev)
+		}
+		// SYN and MSC events and the auto-repeat are skipped
+		if len(got) != 12 {
+			t.Fatalf("%s: got %d events", tt.capture, len(got))
+		}
+		if events == nil {
+			events = got
+		}
+		for ii := range got {
+			if got[ii] != events[ii] {
+				t.Errorf("%s: event %d is %+v, want %+v", tt.capture, ii, got[ii], events[ii])
+			}
+		}
+	}
+	if first, want := events[0].Time, 1760000000*time.Second; first != want {
+		t.Errorf("first event at %s, want %s", first, want)
+	}
+	last := events[len(events)-1]
+	if last.Time-events[0].Time != 1900*time.Millisecond || !last.Axis || last.Number != 5 || last.Value != 512 {
+		t.Errorf("got last event %+v", last)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	i32 := func(n int32) *int32 { return &n }
+	tests := []struct {
+		name  string
+		in    Input
+		value int32
+		want  float64
+	}{
+		{"centre", Input{}, 0, 0},
+		{"full", Input{}, 32767, 1},
+		{"beyond the range", Input{}, -32768, -1},
+		{"half", Input{}, 16384, 0.5},
+		{"inverted", Input{Invert: true}, 16384, -0.5},
+		{"inside the deadband", Input{Deadband: 0.1}, 3000, 0},
+		{"outside the deadband", Input{Deadband: 0.1}, -18022, -0.5},
+		{"deadband at full", Input{Deadband: 0.1}, 32767, 1},
+		{"expo", Input{Expo: 0.5}, 16384, 0.3125},
+		{"expo at full", Input{Expo: 1}, -32767, -1},
+		{"full expo", Input{Expo: 1}, 16384, 0.125},
+		{"own range", Input{Min: i32(0), Max: i32(255)}, 255, 1},
+		{"own range low", Input{Min: i32(0), Max: i32(255)}, 0, -1},
+		{"own range centre", Input{Min: i32(0), Max: i32(254)}, 127, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.in.Position(tt.value); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("%s: position of %d is %g, want %g", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestToggle(t *testing.T) {
+	m := readMapping(t, "aux: {1: {button: 2, toggle: true}, 2: {button: 3}}", JSFormat)
+	var sticks RxSticks
+	sticks.Reset()
+	for _, tt := range []struct {
+		button      int
+		value       int32
+		aux1, aux2  uint16
+		description string
+	}{
+		{2, 1, RxHigh, RxLow, "press toggles on"},
+		{2, 0, RxHigh, RxLow, "release leaves it on"},
+		{3, 1, RxHigh, RxHigh, "momentary button held"},
+		{2, 1, RxLow, RxHigh, "press toggles off"},
+		{3, 0, RxLow, RxLow, "momentary button released"},
+		{4, 1, RxLow, RxLow, "unmapped button"},
+	} {
+		m.Apply(&sticks, JoystickEvent{Number: tt.button, Value: tt.value})
+		if sticks.Channels[0] != tt.aux1 || sticks.Channels[1] != tt.aux2 {
+			t.Errorf("%s: AUX 1 is %d and AUX 2 %d, want %d and %d", tt.description, sticks.Channels[0], sticks.Channels[1], tt.aux1, tt.aux2)
+		}
+	}
+}
+
+func TestReadMappingErrors(t *testing.T) {
+	tests := []struct {
+		mapping string
+		format  JoystickFormat
+		err     string
+	}{
+		{"", JSFormat, "EOF"},
+		{"roll: {axis: 0}\nwobble: {axis: 1}", JSFormat, "field wobble not found"},
+		{"aux: {}", JSFormat, "no channels"},
+		{"aux: {15: {button: 0}}", JSFormat, "no AUX channel 15"},
+		{"roll: {}", JSFormat, "roll: give either an axis or a button"},
+		{"roll: {axis: 0, button: 1}", JSFormat, "roll: give either an axis or a button"},
+		{"aux: {1: {button: 0, invert: true}}", JSFormat, "aux 1: invert, deadband, expo, min and max only apply to axes"},
+		{"aux: {1: {axis: 0, toggle: true}}", JSFormat, "aux 1: toggle only applies to buttons"},
+		{"pitch: {axis: 0, deadband: 1}", JSFormat, "pitch: deadband 1 is not from 0 to less than 1"},
+		{"yaw: {axis: 0, expo: 1.5}", JSFormat, "yaw: expo 1.5 is not from 0 to 1"},
+		{"throttle: {axis: 0, min: 10, max: 10}", JSFormat, "throttle: min 10 is not less than max 10"},
+		{"roll: {axis: 0}", EvdevFormat, "roll: event device axes need their min and max"},
+		{"roll: {axis: 0, min: 0}", Evdev32Format, "roll: event device axes need their min and max"},
+	}
+	for _, tt := range tests {
+		_, err := ReadMapping(strings.NewReader(tt.mapping), tt.format)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: got error %v, want %q", tt.mapping, err, tt.err)
+		}
+	}
+
+	// Buttons don't need a range on event devices
+	readMapping(t, "aux: {1: {button: 0x130}}", Evdev64Format)
+}
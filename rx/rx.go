@@ -59,6 +59,8 @@ type RxSticks struct {
 }
 
 func (r *RxSticks) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.Roll = RxMid
 	r.Pitch = RxMid
 	r.Yaw = RxMid
@@ -109,7 +111,7 @@ func (r *RxSticks) Keypress(key RXKey) {
 		r.lastPress[RXKeyUp] = time.Time{}
 	case RXKeyRight:
 		r.Roll = RxHigh
-		r.lastPress[RXKeyRight] = time.Time{}
+		r.lastPress[RXKeyLeft] = time.Time{}
 	case RXKey1:
 		r.switchChannel(5)
 	case RXKey2:
@@ -135,6 +137,8 @@ func (r *RxSticks) Keypress(key RXKey) {
 	r.lastPress[key] = time.Now()
 }
 
+// Update centres the sticks whose key has been released, and drops the
+// throttle back to low rather than leaving it at half.
 func (r *RxSticks) Update() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -147,7 +151,7 @@ func (r *RxSticks) Update() {
 			r.lastPress[ii] = time.Time{}
 			switch RXKey(ii) {
 			case RXKeyW, RXKeyS:
-				r.Throttle = RxMid
+				r.Throttle = RxLow
 			case RXKeyA, RXKeyD:
 				r.Yaw = RxMid
 			case RXKeyUp, RXKeyDown:
@@ -159,6 +163,35 @@ func (r *RxSticks) Update() {
 	}
 }
 
+// SetChannel sets RC channel ch, numbered from 1 in the order roll, pitch,
+// yaw, throttle and then the AUX channels, to value.
+func (r *RxSticks) SetChannel(ch int, value uint16) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch ch {
+	case 1:
+		r.Roll = value
+	case 2:
+		r.Pitch = value
+	case 3:
+		r.Yaw = value
+	case 4:
+		r.Throttle = value
+	default:
+		if idx := ch - 5; idx >= 0 && idx < len(r.Channels) {
+			r.Channels[idx] = value
+		}
+	}
+}
+
+// ToggleChannel switches AUX channel ch, numbered as by SetChannel, between
+// low and high.
+func (r *RxSticks) ToggleChannel(ch int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.switchChannel(ch)
+}
+
 func (r *RxSticks) switchChannel(ch int) {
 	idx := ch - 5
 	if idx >= 0 && idx < len(r.Channels) {
@@ -0,0 +1,80 @@
+package rx
+
+import (
+	"testing"
+	"time"
+)
+
+// release makes every key pressed look as if it was released a while ago.
+func release(r *RxSticks) {
+	for ii, ts := range r.lastPress {
+		if !ts.IsZero() {
+			r.lastPress[ii] = ts.Add(-2 * keyTimeout)
+		}
+	}
+	r.Update()
+}
+
+func TestKeypress(t *testing.T) {
+	tests := []struct {
+		name                       string
+		keys                       []RXKey
+		roll, pitch, yaw, throttle uint16
+		released                   [4]uint16
+	}{
+		{"throttle up", []RXKey{RXKeyW}, RxMid, RxMid, RxMid, RxHigh, [4]uint16{RxMid, RxMid, RxMid, RxLow}},
+		{"throttle down", []RXKey{RXKeyW, RXKeyS}, RxMid, RxMid, RxMid, RxLow, [4]uint16{RxMid, RxMid, RxMid, RxLow}},
+		{"yaw", []RXKey{RXKeyA}, RxMid, RxMid, RxLow, RxLow, [4]uint16{RxMid, RxMid, RxMid, RxLow}},
+		{"yaw reversed", []RXKey{RXKeyA, RXKeyD}, RxMid, RxMid, RxHigh, RxLow, [4]uint16{RxMid, RxMid, RxMid, RxLow}},
+		{"pitch and roll", []RXKey{RXKeyUp, RXKeyLeft}, RxLow, RxHigh, RxMid, RxLow, [4]uint16{RxMid, RxMid, RxMid, RxLow}},
+		{"everything", []RXKey{RXKeyW, RXKeyD, RXKeyDown, RXKeyRight}, RxHigh, RxLow, RxHigh, RxHigh, [4]uint16{RxMid, RxMid, RxMid, RxLow}},
+	}
+	for _, tt := range tests {
+		r := &RxSticks{}
+		r.Reset()
+		for _, key := range tt.keys {
+			r.Keypress(key)
+		}
+		// Held keys keep their sticks where they are
+		r.Update()
+		if got := [4]uint16{r.Roll, r.Pitch, r.Yaw, r.Throttle}; got != [4]uint16{tt.roll, tt.pitch, tt.yaw, tt.throttle} {
+			t.Errorf("%s: held sticks are %v, want %v", tt.name, got, [4]uint16{tt.roll, tt.pitch, tt.yaw, tt.throttle})
+		}
+		release(r)
+		if got := [4]uint16{r.Roll, r.Pitch, r.Yaw, r.Throttle}; got != tt.released {
+			t.Errorf("%s: released sticks are %v, want %v", tt.name, got, tt.released)
+		}
+	}
+}
+
+func TestThrottleReleasedLow(t *testing.T) {
+	r := &RxSticks{}
+	r.Reset()
+	r.Keypress(RXKeyW)
+	if r.Throttle != RxHigh {
+		t.Fatalf("throttle is %d with W held", r.Throttle)
+	}
+	time.Sleep(keyTimeout + 20*time.Millisecond)
+	r.Update()
+	if r.Throttle != RxLow {
+		t.Errorf("throttle is %d after W was released, want %d", r.Throttle, RxLow)
+	}
+}
+
+func TestSwitches(t *testing.T) {
+	r := &RxSticks{}
+	r.Reset()
+	r.Keypress(RXKey1)
+	r.Keypress(RXKey0)
+	r.Keypress(RXKey0)
+	// Switches stay where they are put when their key is released
+	release(r)
+	if r.Channels[0] != RxHigh || r.Channels[9] != RxLow {
+		t.Errorf("got AUX channels %v", r.Channels)
+	}
+	r.ToggleChannel(5)
+	r.SetChannel(4, 1234)
+	if r.Channels[0] != RxLow || r.Throttle != 1234 {
+		t.Errorf("got AUX 1 %d and throttle %d", r.Channels[0], r.Throttle)
+	}
+}
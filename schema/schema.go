@@ -0,0 +1,229 @@
+// Package schema describes the settings a Betaflight firmware accepts, as
+// reported by the CLI `get` command, so that configurations can be checked
+// without a board.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/robhaswell/btflcli/cli"
+	"github.com/robhaswell/btflcli/config"
+)
+
+// FormatVersion is the version of the schema file format. It is increased
+// when the format changes in a way older readers can't understand.
+const FormatVersion = 1
+
+// Setting types, derived from how `get` describes the values.
+const (
+	Number = "number"
+	Lookup = "lookup"
+	String = "string"
+	Array  = "array"
+)
+
+// Schema is the settings of one firmware release.
+type Schema struct {
+	FormatVersion int `json:"format_version"`
+	// Variant and Version are the firmware the schema was taken from, e.g.
+	// BTFL and 4.5.0
+	Variant  string     `json:"variant"`
+	Version  string     `json:"version"`
+	Target   string     `json:"target,omitempty"`
+	Settings []*Setting `json:"settings"`
+
+	byName map[string]*Setting
+}
+
+// Setting describes the values a setting accepts.
+type Setting struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Section is master, profile or rateprofile
+	Section string `json:"section"`
+	// Min and Max are the range of a number, or of the length of a string
+	Min *int64 `json:"min,omitempty"`
+	Max *int64 `json:"max,omitempty"`
+	// Values are the values of a lookup
+	Values []string `json:"values,omitempty"`
+	// Length is the number of elements of an array
+	Length  int    `json:"length,omitempty"`
+	Default string `json:"default,omitempty"`
+}
+
+// New returns the schema of the settings described by `get`.
+func New(variant, version, target string, vars []cli.Variable) *Schema {
+	s := &Schema{
+		FormatVersion: FormatVersion,
+		Variant:       variant,
+		Version:       version,
+		Target:        target,
+	}
+	for _, v := range vars {
+		setting := &Setting{Name: v.Name, Section: "master", Default: v.Default}
+		switch {
+		case v.Profile != nil:
+			setting.Section = "profile"
+		case v.RateProfile != nil:
+			setting.Section = "rateprofile"
+		}
+		switch {
+		case v.Range != nil:
+			setting.Type = Number
+			setting.Min, setting.Max = &v.Range.Min, &v.Range.Max
+		case v.Values != nil:
+			setting.Type = Lookup
+			setting.Values = v.Values
+		case v.StringLength != nil:
+			setting.Type = String
+			setting.Min, setting.Max = &v.StringLength.Min, &v.StringLength.Max
+		case v.ArrayLength > 0:
+			setting.Type = Array
+			setting.Length = v.ArrayLength
+		default:
+			continue
+		}
+		s.Settings = append(s.Settings, setting)
+	}
+	sort.Slice(s.Settings, func(i, j int) bool {
+		return s.Settings[i].Name < s.Settings[j].Name
+	})
+	return s
+}
+
+// Read reads a schema file.
+func Read(r io.Reader) (*Schema, error) {
+	s := &Schema{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, err
+	}
+	if s.FormatVersion == 0 || s.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("unsupported schema format version %d", s.FormatVersion)
+	}
+	return s, nil
+}
+
+// Write writes the schema as JSON.
+func (s *Schema) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// Setting returns the named setting, or nil if there is no such setting.
+func (s *Schema) Setting(name string) *Setting {
+	if s.byName == nil {
+		s.byName = make(map[string]*Setting, len(s.Settings))
+		for _, setting := range s.Settings {
+			s.byName[setting.Name] = setting
+		}
+	}
+	return s.byName[strings.ToLower(name)]
+}
+
+// Problem is a line of a configuration which the firmware would reject.
+type Problem struct {
+	Line    int
+	Section config.Section
+	Setting string
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Setting == "" {
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", p.Line, p.Setting, p.Message)
+}
+
+// sectionKinds are the config section kinds of the Section of a Setting, and
+// how problems refer to them.
+var sectionKinds = map[string]struct {
+	kind config.SectionKind
+	name string
+}{
+	"master":      {config.Master, "master"},
+	"profile":     {config.Profile, "a profile"},
+	"rateprofile": {config.RateProfile, "a rate profile"},
+}
+
+// Validate checks every `set` command in a configuration against the
+// schema, including that it is in the right section.
+func (s *Schema) Validate(c *config.Config) []Problem {
+	var problems []Problem
+	for _, l := range c.Lines {
+		if l.Command == nil || l.Command.Name != "set" {
+			continue
+		}
+		if len(l.Command.Args) != 2 {
+			problems = append(problems, Problem{Line: l.Number, Section: l.Section, Message: "expected set <name> = <value>"})
+			continue
+		}
+		name, value := l.Command.Args[0], l.Command.Args[1]
+		msg := ""
+		if setting := s.Setting(name); setting == nil {
+			msg = "unknown setting"
+		} else if want, ok := sectionKinds[setting.Section]; ok && want.kind != l.Section.Kind {
+			msg = fmt.Sprintf("belongs in %s, not %s", want.name, l.Section)
+		} else {
+			msg = setting.Check(value)
+		}
+		if msg != "" {
+			problems = append(problems, Problem{Line: l.Number, Section: l.Section, Setting: name, Message: msg})
+		}
+	}
+	return problems
+}
+
+// Check returns why the firmware would reject a value, or "" if it
+// wouldn't.
+func (s *Setting) Check(value string) string {
+	switch s.Type {
+	case Number:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Sprintf("%q is not a number", value)
+		}
+		if (s.Min != nil && n < *s.Min) || (s.Max != nil && n > *s.Max) {
+			return fmt.Sprintf("%d is out of range %s", n, s.rangeString())
+		}
+	case Lookup:
+		for _, v := range s.Values {
+			if strings.EqualFold(v, value) {
+				return ""
+			}
+		}
+		return fmt.Sprintf("%q is not one of %s", value, strings.Join(s.Values, ", "))
+	case String:
+		if n := int64(len(value)); (s.Min != nil && n < *s.Min) || (s.Max != nil && n > *s.Max) {
+			return fmt.Sprintf("%q is not %s characters long", value, s.rangeString())
+		}
+	case Array:
+		elements := strings.Split(value, ",")
+		if len(elements) > s.Length {
+			return fmt.Sprintf("%d elements given, at most %d allowed", len(elements), s.Length)
+		}
+		for _, e := range elements {
+			if _, err := strconv.ParseInt(strings.TrimSpace(e), 10, 64); err != nil {
+				return fmt.Sprintf("%q is not a number", strings.TrimSpace(e))
+			}
+		}
+	}
+	return ""
+}
+
+func (s *Setting) rangeString() string {
+	var min, max string
+	if s.Min != nil {
+		min = strconv.FormatInt(*s.Min, 10)
+	}
+	if s.Max != nil {
+		max = strconv.FormatInt(*s.Max, 10)
+	}
+	return min + " - " + max
+}
@@ -0,0 +1,144 @@
+package schema
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/robhaswell/btflcli/cli"
+	"github.com/robhaswell/btflcli/config"
+)
+
+// getOutput is `get` output for a few settings of each type and section.
+const getOutput = "gyro_lpf1_type = PT1\r\nAllowed values: PT1, BIQUAD, PT2, PT3\r\nDefault value: PT1\r\n\r\n" +
+	"gyro_lpf1_static_hz = 250\r\nAllowed range: 0 - 1000\r\nDefault value: 250\r\n\r\n" +
+	"acc_trim_pitch = 0\r\nAllowed range: -300 - 300\r\nDefault value: 0\r\n\r\n" +
+	"acc_calibration = 0,0,0,0\r\nArray length: 4\r\n\r\nDefault value: 0,0,0,0\r\n\r\n" +
+	"craft_name = \r\nString length: 1 - 16\r\n\r\n" +
+	"p_pitch = 47\r\nprofile 0\r\nAllowed range: 0 - 250\r\nDefault value: 47\r\n\r\n" +
+	"roll_srate = 67\r\nrateprofile 0\r\nAllowed range: 0 - 255\r\nDefault value: 67\r\n"
+
+func testSchema() *Schema {
+	vars := cli.ParseGet(strings.Split(getOutput, "\r\n"))
+	return New("BTFL", "4.5.0", "STM32F405", vars)
+}
+
+func TestNew(t *testing.T) {
+	s := testSchema()
+	var names []string
+	for _, setting := range s.Settings {
+		names = append(names, setting.Name)
+	}
+	want := []string{"acc_calibration", "acc_trim_pitch", "craft_name", "gyro_lpf1_static_hz", "gyro_lpf1_type", "p_pitch", "roll_srate"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got settings %v, want %v", names, want)
+	}
+	for _, tt := range []struct {
+		name, typ, section string
+	}{
+		{"gyro_lpf1_type", Lookup, "master"},
+		{"gyro_lpf1_static_hz", Number, "master"},
+		{"acc_calibration", Array, "master"},
+		{"craft_name", String, "master"},
+		{"p_pitch", Number, "profile"},
+		{"roll_srate", Number, "rateprofile"},
+	} {
+		setting := s.Setting(tt.name)
+		if setting == nil {
+			t.Errorf("no setting %s", tt.name)
+			continue
+		}
+		if setting.Type != tt.typ || setting.Section != tt.section {
+			t.Errorf("%s is a %s %s setting, want %s %s", tt.name, setting.Section, setting.Type, tt.section, tt.typ)
+		}
+	}
+	if s.Setting("P_PITCH") == nil {
+		t.Error("setting names aren't case insensitive")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s := testSchema()
+	tests := []struct {
+		setting, value string
+		want           string
+	}{
+		{"gyro_lpf1_static_hz", "0", ""},
+		{"gyro_lpf1_static_hz", "1000", ""},
+		{"gyro_lpf1_static_hz", "1001", "1001 is out of range 0 - 1000"},
+		{"gyro_lpf1_static_hz", "-1", "-1 is out of range 0 - 1000"},
+		{"gyro_lpf1_static_hz", "fast", `"fast" is not a number`},
+		{"acc_trim_pitch", "-300", ""},
+		{"acc_trim_pitch", "-301", "-301 is out of range -300 - 300"},
+		{"gyro_lpf1_type", "BIQUAD", ""},
+		{"gyro_lpf1_type", "biquad", ""},
+		{"gyro_lpf1_type", "FOO", `"FOO" is not one of PT1, BIQUAD, PT2, PT3`},
+		{"acc_calibration", "-12,3,-40,1", ""},
+		{"acc_calibration", "1, 2", ""},
+		{"acc_calibration", "1,2,3,4,5", "5 elements given, at most 4 allowed"},
+		{"acc_calibration", "1,x,3,4", `"x" is not a number`},
+		{"craft_name", "Tiny Whoop", ""},
+		{"craft_name", "", `"" is not 1 - 16 characters long`},
+		{"craft_name", "A Very Long Craft Name", `"A Very Long Craft Name" is not 1 - 16 characters long`},
+	}
+	for _, tt := range tests {
+		if got := s.Setting(tt.setting).Check(tt.value); got != tt.want {
+			t.Errorf("%s = %q: got %q, want %q", tt.setting, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := config.ParseString("# diff all\r\n" +
+		"set gyro_lpf1_static_hz = 200\r\n" +
+		"set gyro_lpf1_type = FOO\r\n" +
+		"set no_such_setting = 1\r\n" +
+		"set p_pitch = 50\r\n" +
+		"set craft_name\r\n" +
+		"profile 1\r\n" +
+		"set p_pitch = 9999\r\n" +
+		"set P_PITCH = 60\r\n" +
+		"set roll_srate = 70\r\n" +
+		"set gyro_lpf1_static_hz = 100\r\n" +
+		"rateprofile 2\r\n" +
+		"set roll_srate = 80\r\n" +
+		"set p_pitch = 40\r\n")
+	profile1 := config.Section{Kind: config.Profile, Index: 1}
+	rates2 := config.Section{Kind: config.RateProfile, Index: 2}
+	want := []Problem{
+		{Line: 3, Section: config.MasterSection, Setting: "gyro_lpf1_type", Message: `"FOO" is not one of PT1, BIQUAD, PT2, PT3`},
+		{Line: 4, Section: config.MasterSection, Setting: "no_such_setting", Message: "unknown setting"},
+		{Line: 5, Section: config.MasterSection, Setting: "p_pitch", Message: "belongs in a profile, not master"},
+		{Line: 6, Section: config.MasterSection, Message: "expected set <name> = <value>"},
+		{Line: 8, Section: profile1, Setting: "p_pitch", Message: "9999 is out of range 0 - 250"},
+		{Line: 10, Section: profile1, Setting: "roll_srate", Message: "belongs in a rate profile, not profile 1"},
+		{Line: 11, Section: profile1, Setting: "gyro_lpf1_static_hz", Message: "belongs in master, not profile 1"},
+		{Line: 14, Section: rates2, Setting: "p_pitch", Message: "belongs in a profile, not rateprofile 2"},
+	}
+	got := testSchema().Validate(c)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got problems:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	s := testSchema()
+	var buf bytes.Buffer
+	if err := s.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read.Settings, s.Settings) || read.Version != "4.5.0" {
+		t.Errorf("got %+v", read)
+	}
+
+	for _, data := range []string{`{"format_version": 0}`, `{"format_version": 99}`, `not json`} {
+		if _, err := Read(strings.NewReader(data)); err == nil {
+			t.Errorf("read %s", data)
+		}
+	}
+}